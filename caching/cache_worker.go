@@ -2,8 +2,13 @@ package caching
 
 import (
 	"Assignment2/fsutils"
+	"Assignment2/metrics"
+	"Assignment2/tracing"
 	"Assignment2/util"
-	"log"
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"net/http"
 	"time"
 )
@@ -18,81 +23,106 @@ import (
 func RunCacheWorker(cfg *util.Config, requests chan CacheRequest, stop <-chan struct{},
 	cleanupDone chan<- struct{}) {
 
-	if cfg.DebugMode {
-		log.Println("Cache worker: running")
-	}
+	slog.Debug("cache worker: running")
 
 	// slice with any cache misses that need handling
 	cacheMisses := make([]CacheMiss, 0)
-	client := http.Client{}
+	client := tracing.InstrumentClient(&http.Client{})
 	cacheUpdated := false
 
 	// map from cca3 codes to CacheEntry structs with borders and timestamp.
 	localCache, err := localCacheInit(cfg)
 	if err != nil {
-		log.Println(err)
+		slog.Error("cache worker: failed to initialize cache", "error", err)
 	}
+	recordCacheStatus(localCache, false)
+
+	// The ticker is reset whenever the interval changes, picking up changes as the config is
+	// reloaded. Requests received in between do not hold back the periodic updates.
+	interval := cfg.Reloadable().CachePushRate
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	recordHeartbeat(WorkerCache, interval)
 
 	// Main request-handling loop. Runs until a stop signal is received or request channel is closed.
 	for {
+		if latest := cfg.Reloadable().CachePushRate; latest != interval {
+			interval = latest
+			ticker.Reset(interval)
+			recordHeartbeat(WorkerCache, interval)
+		}
 		select {
-		case <-time.After(cfg.CachePushRate):
+		case <-ticker.C:
+			recordHeartbeat(WorkerCache, interval)
 			if cacheUpdated {
-				util.LogOnDebug(cfg, "cache worker: handling updates")
+				slog.Debug("cache worker: handling updates", "entries", len(localCache))
 				// Updates external Cache file by overwriting
+				_, span := tracing.StartSpan(context.Background(), "cache worker: push cache",
+					attribute.Int("entries", len(localCache)))
 				err := fsutils.AddDocumentById(cfg, cfg.CachingCollection, cfg.PrimaryCache, &localCache)
+				tracing.EndSpan(span, err)
 				if err != nil {
-					log.Println("cache worker: failed to update cache in DB on periodic update")
+					slog.Error("cache worker: failed to update cache in DB on periodic update", "error", err)
+				} else {
+					recordCacheStatus(localCache, true)
 				}
 				cacheUpdated = false
 			} else {
-				util.LogOnDebug(cfg, "cache worker: no updates")
+				slog.Debug("cache worker: no updates")
 			}
 		case <-stop: // Signal received on stop channel, shutting down worker.
 			// Writes to primary cache in db before shutting down
 			err := fsutils.AddDocumentById(cfg, cfg.CachingCollection, cfg.PrimaryCache, &localCache)
 			if err != nil {
-				log.Println("cache worker: failed to create DB on shutdown")
+				slog.Error("cache worker: failed to create DB on shutdown", "error", err)
 			}
 			cleanupDone <- struct{}{}
 			return
 		case val, ok := <-requests: // Either request has been received or channel is closed
 			if !ok {
-				log.Println("Cache worker lost contact with request channel.\n" +
-					"Running cleanup routine and shutting down cache worker.")
+				slog.Warn("cache worker: lost contact with request channel, " +
+					"running cleanup routine and shutting down")
 				err := fsutils.AddDocumentById(cfg, cfg.CachingCollection, cfg.PrimaryCache, &localCache)
 				if err != nil {
-					log.Println("cache worker: failed to create DB on shutdown")
+					slog.Error("cache worker: failed to create DB on shutdown", "error", err)
 				}
 				cleanupDone <- struct{}{}
 				return
 			}
-			util.LogOnDebug(cfg, "cache worker: got a request")
+			// The span of the lookup is carried by the request until a response is returned.
+			var span trace.Span
+			val.Ctx, span = tracing.StartSpan(val.Ctx, "cache worker: lookup",
+				attribute.StringSlice("countries", val.CountryRequest))
+			logger := util.Logger(val.Ctx)
+			logger.Debug("cache worker: got a request", "countries", val.CountryRequest)
 			response := CacheResponse{Status: http.StatusOK, Neighbours: map[string][]string{}}
 			misses := make([]string, 0)
 			for _, code := range val.CountryRequest {
 				cacheResult, ok := localCache[code]
 				if ok {
+					metrics.CacheLookups.WithLabelValues(metrics.CacheHit).Inc()
 					response.Neighbours[code] = cacheResult.Borders
 				} else {
+					metrics.CacheLookups.WithLabelValues(metrics.CacheMiss).Inc()
 					misses = append(misses, code)
 				}
 			}
 			if len(misses) == 0 {
-				if cfg.DebugMode {
-					log.Println("cache worker dbg: returning response")
-				}
+				logger.Debug("cache worker: returning response")
+				span.End()
 				val.ChannelRef <- response
 			} else { // Some misses, will be handled when default case occurs
+				span.SetAttributes(attribute.StringSlice("misses", misses))
 				val.CountryRequest = misses
 				cacheMisses = append(cacheMisses, CacheMiss{Request: val, Response: response})
 			}
 
 			if len(cacheMisses) != 0 {
-				util.LogOnDebug(cfg, "handling ", len(cacheMisses), " cachemisses")
+				slog.Debug("cache worker: handling cache misses", "misses", len(cacheMisses))
 				// Any cache misses are checked against the external api.
 				// Any valid results are added to the local cache.
-				cacheUpdated = updateLocalCache(cfg, &client, &localCache, cacheMisses) || cacheUpdated
+				cacheUpdated = updateLocalCache(cfg, client, &localCache, cacheMisses) || cacheUpdated
+				recordCacheStatus(localCache, false)
 				// Iterates through the misses where each miss represents one Request
 				for _, miss := range cacheMisses {
 					// Iterates through the missed cca3 codes that were missed
@@ -106,6 +136,9 @@ func RunCacheWorker(cfg *util.Config, requests chan CacheRequest, stop <-chan st
 						miss.Response.Status = http.StatusNotFound
 					}
 					// A final response sent to the handler that made the current
+					util.Logger(miss.Request.Ctx).Debug("cache worker: returning response after misses",
+						"status", miss.Response.Status)
+					trace.SpanFromContext(miss.Request.Ctx).End()
 					miss.Request.ChannelRef <- miss.Response
 				}
 				// resets cache misses
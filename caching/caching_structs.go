@@ -1,6 +1,22 @@
 package caching
 
-import "time"
+import (
+	"context"
+	"time"
+)
+
+// Endpoints reported along with invocations.
+const (
+	EndpointCurrent  = "current"
+	EndpointHistory  = "history"
+	EndpointForecast = "forecast"
+)
+
+// Query types reported along with invocations.
+const (
+	QueryCountry   = "country"   // country was looked up directly
+	QueryNeighbour = "neighbour" // country was included as a neighbour of the looked up country
+)
 
 // RequestStatus represents a http status code
 type RequestStatus int16
@@ -15,8 +31,10 @@ type CacheResponse struct {
 
 // CacheRequest wraps a pointer to a channel where the response
 // should be posted along with a slice of country codes to be
-// looked up in cache or external API
+// looked up in cache or external API. Ctx is the context of the
+// originating request, carrying its request ID and trace span.
 type CacheRequest struct {
+	Ctx            context.Context
 	ChannelRef     chan CacheResponse
 	CountryRequest []string
 }
@@ -35,3 +53,20 @@ type CacheMiss struct {
 	Request  CacheRequest
 	Response CacheResponse
 }
+
+// Invocation describes a single lookup of a country on one of the service
+// endpoints, as reported by the endpoint handlers to the invocation worker.
+// Ctx is the context of the originating request, carrying its request ID and trace span.
+type Invocation struct {
+	Ctx       context.Context
+	Endpoint  string
+	Country   string
+	QueryType string
+}
+
+// IsValidEndpoint returns true if the endpoint is one reported along with
+// invocations. The empty string is valid, representing any endpoint.
+func IsValidEndpoint(endpoint string) bool {
+	return endpoint == "" || endpoint == EndpointCurrent || endpoint == EndpointHistory ||
+		endpoint == EndpointForecast
+}
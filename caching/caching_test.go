@@ -2,13 +2,21 @@ package caching
 
 import (
 	"Assignment2/consts"
+	"Assignment2/events"
+	"Assignment2/internal/assets"
 	"Assignment2/internal/stubbing"
 	"Assignment2/util"
+	"cloud.google.com/go/firestore"
 	"context"
+	"encoding/json"
 	"firebase.google.com/go"
+	"github.com/stretchr/testify/assert"
 	"google.golang.org/api/option"
+	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -60,7 +68,7 @@ func TestRunCacheWorker(t *testing.T) {
 	wg := sync.WaitGroup{}
 	defer wg.Wait()
 
-	go stubbing.RunSTUBServer(&config, &wg, "../internal/assets/", consts.StubPort, stop)
+	go stubbing.RunSTUBServer(&config, &wg, assets.New(""), consts.StubPort, stop)
 	go RunCacheWorker(&config, requests, stop, done)
 
 	time.Sleep(time.Second * 1)
@@ -114,11 +122,16 @@ func TestInvocationWorker(t *testing.T) {
 	}
 	stop := make(chan struct{})
 	done := make(chan struct{})
-	invocations := make(chan []string, 10)
+	invocations := NewInvocationRecorder(10)
 
 	go InvocationWorker(&config, stop, done, &countryDB, invocations)
-	countries := []string{"NOR", "SWE", "RUS", "GER"}
-	invocations <- countries
+	countries := []Invocation{
+		{Endpoint: EndpointCurrent, Country: "NOR", QueryType: QueryCountry},
+		{Endpoint: EndpointCurrent, Country: "SWE", QueryType: QueryNeighbour},
+		{Endpoint: EndpointHistory, Country: "RUS", QueryType: QueryCountry},
+		{Endpoint: EndpointHistory, Country: "GER", QueryType: QueryCountry},
+	}
+	invocations.Record(countries)
 	log.Println("sleeping")
 	//time.Sleep(config.WebhookEventRate)
 	log.Println("done sleeping")
@@ -128,3 +141,444 @@ func TestInvocationWorker(t *testing.T) {
 	log.Println("past the done signal")
 
 }
+
+func TestCountInvocations(t *testing.T) {
+	recorder := NewInvocationRecorder(10)
+	stop := make(chan struct{})
+	interval := 50 * time.Millisecond
+	cycles := make(chan invocationCounts, 100)
+	finished := make(chan invocationCounts)
+	go func() {
+		counts, stopped := countInvocations(func() time.Duration { return interval }, stop, recorder,
+			func(counts invocationCounts) { cycles <- counts })
+		assert.True(t, stopped)
+		finished <- counts
+	}()
+
+	// Invocations reported more often than the interval must not hold back the cycles.
+	sent := int32(0)
+	for deadline := time.Now().Add(4 * interval); time.Now().Before(deadline); sent++ {
+		recorder.Record([]Invocation{{Ctx: context.Background(), Endpoint: EndpointCurrent, Country: "NOR", QueryType: QueryCountry}})
+		time.Sleep(interval / 10)
+	}
+	stop <- struct{}{}
+	left := <-finished
+	drainInvocations(recorder.channel, left)
+	recorder.takeOverflow(left)
+	counted := left.countFor("NOR", "")
+	close(cycles)
+	pushed := 0
+	for counts := range cycles {
+		pushed++
+		counted += counts.countFor("NOR", "")
+	}
+	assert.GreaterOrEqual(t, pushed, 2)
+	assert.Equal(t, sent, counted)
+	assert.True(t, GetWorkerStatus(time.Now())[WorkerInvocation].Alive)
+}
+
+func TestCountTriggers(t *testing.T) {
+	tests := []struct {
+		name             string
+		oldCount         int32
+		newCount         int32
+		calls            int32
+		previousTriggers int32
+		triggers         int32
+	}{
+		{"no invocations", 3, 3, 5, 0, 0},
+		{"below threshold", 3, 4, 5, 0, 0},
+		{"reaching threshold", 3, 5, 5, 0, 1},
+		{"passing several thresholds", 4, 16, 5, 0, 3},
+		{"already triggered before", 10, 12, 5, 2, 0},
+		{"invalid calls value", 10, 12, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previousTriggers, triggers := countTriggers(tt.oldCount, tt.newCount, tt.calls)
+			if previousTriggers != tt.previousTriggers || triggers != tt.triggers {
+				t.Error("Expected ", tt.previousTriggers, tt.triggers, ", got ", previousTriggers, triggers)
+			}
+		})
+	}
+}
+
+func TestThresholdEventFor(t *testing.T) {
+	delivered := int32(4)
+	webhook := webhookCheck{ID: "passed", PreviousCount: 4, Body: webhookRegistration{Country: "NOR", Calls: 5,
+		Count: 16, DeliveredCount: &delivered, EndpointCalls: map[string]int32{EndpointHistory: 12}}}
+	event, ok := thresholdEventFor(webhook)
+	assert.True(t, ok)
+	assert.Equal(t, "NOR", event.Country)
+	assert.Equal(t, events.ThresholdData{WebhookId: "passed", Country: "NOR", Endpoint: EndpointHistory,
+		Calls: 15, Thresholds: []int32{5, 10, 15}}, event.Data)
+
+	// Retried deliveries only publish the thresholds passed since the last claim
+	published := int32(12)
+	webhook.Body.PublishedCount = &published
+	webhook.Body.Count = 21
+	event, ok = thresholdEventFor(webhook)
+	assert.True(t, ok)
+	assert.Equal(t, []int32{15, 20}, event.Data.Thresholds)
+
+	webhook.Body.Count = 14
+	_, ok = thresholdEventFor(webhook)
+	assert.False(t, ok)
+}
+
+func TestIsExpired(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+	if isExpired(webhookRegistration{}, now) {
+		t.Error("webhook without expiry should never expire")
+	}
+	if !isExpired(webhookRegistration{Expires: &past}, now) {
+		t.Error("webhook with passed expiry should be expired")
+	}
+	if isExpired(webhookRegistration{Expires: &future}, now) {
+		t.Error("webhook with future expiry should not be expired")
+	}
+}
+
+func TestDeliverWebhookEvents(t *testing.T) {
+	var countryDB util.CountryDataset
+	if err := countryDB.Initialize("../internal/assets/renewable-share-energy.csv"); err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{AllowPrivateWebhooks: true}
+	client := util.NewWebhookClient(&config)
+
+	var mutex sync.Mutex
+	received := make(map[string][]json.RawMessage)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		received[r.URL.Path] = append(received[r.URL.Path], body)
+		mutex.Unlock()
+		if r.URL.Path == "/failing" {
+			http.Error(w, "", http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	endpointCalls := map[string]int32{EndpointCurrent: 10, EndpointHistory: 2}
+	webhooks := []webhookCheck{
+		{ID: "single", PreviousCount: 4, Body: webhookRegistration{
+			URL: receiver.URL + "/single", Country: "NOR", Calls: 5, Count: 16, EndpointCalls: endpointCalls}},
+		{ID: "batch", PreviousCount: 4, Body: webhookRegistration{
+			URL: receiver.URL + "/batch", Country: "NOR", Calls: 5, Count: 16, EndpointCalls: endpointCalls, Batch: true}},
+		{ID: "failing", PreviousCount: 4, Body: webhookRegistration{
+			URL: receiver.URL + "/failing", Country: "NOR", Calls: 5, Count: 16, EndpointCalls: endpointCalls}},
+	}
+	deliveryErrors := deliverWebhookEvents(context.Background(), &config, client, webhooks, &countryDB)
+
+	assert.Nil(t, deliveryErrors[0])
+	assert.Nil(t, deliveryErrors[1])
+	assert.Error(t, deliveryErrors[2])
+	// Count goes from 4 to 16, passing 5, 10 and 15
+	assert.Equal(t, 3, len(received["/single"]))
+	if assert.Equal(t, 1, len(received["/batch"])) {
+		batch := webhookBatchTrigger{}
+		assert.Nil(t, json.Unmarshal(received["/batch"][0], &batch))
+		assert.Equal(t, []int32{5, 10, 15}, batch.Thresholds)
+		assert.Equal(t, int32(15), batch.TotalCalls)
+		assert.Equal(t, "Norway", batch.Country)
+		assert.Equal(t, EndpointCurrent, batch.Endpoint)
+	}
+	// Delivery stops at the first failed message
+	assert.Equal(t, 1, len(received["/failing"]))
+}
+
+func TestInvocationCounts(t *testing.T) {
+	counts := invocationCounts{}
+	for i := 0; i < 3; i++ {
+		counts.add(Invocation{Endpoint: EndpointCurrent, Country: "NOR", QueryType: QueryCountry})
+	}
+	counts.add(Invocation{Endpoint: EndpointHistory, Country: "NOR", QueryType: QueryCountry})
+	counts.add(Invocation{Endpoint: EndpointHistory, Country: "SWE", QueryType: QueryNeighbour})
+
+	assert.Equal(t, int32(4), counts.countFor("NOR", ""))
+	assert.Equal(t, int32(3), counts.countFor("NOR", EndpointCurrent))
+	assert.Equal(t, int32(1), counts.countFor("NOR", EndpointHistory))
+	assert.Equal(t, int32(0), counts.countFor("FIN", ""))
+	assert.Equal(t, int32(5), counts.countFor("", ""))
+	assert.Equal(t, int32(2), counts.countFor("", EndpointHistory))
+
+	increment := counts.incrementFor(webhookRegistration{Country: "NOR"})
+	assert.Equal(t, int32(4), increment.Total)
+	assert.Equal(t, map[string]int32{EndpointCurrent: 3, EndpointHistory: 1}, increment.EndpointCalls)
+	increment = counts.incrementFor(webhookRegistration{Endpoint: EndpointHistory})
+	assert.Equal(t, int32(2), increment.Total)
+	assert.Equal(t, map[string]int32{EndpointHistory: 2}, increment.EndpointCalls)
+
+	assert.Equal(t, EndpointHistory, triggerEndpoint(webhookRegistration{Country: "NOR", Endpoint: EndpointHistory}))
+	assert.Equal(t, EndpointCurrent, triggerEndpoint(webhookRegistration{
+		Country: "NOR", EndpointCalls: map[string]int32{EndpointCurrent: 3, EndpointHistory: 1}}))
+
+	assert.ElementsMatch(t, []string{"NOR", "SWE"}, getUpdatedCountries(counts))
+}
+
+func TestIncrementUpdates(t *testing.T) {
+	assert.Nil(t, incrementUpdates(webhookIncrement{}))
+
+	updates := incrementUpdates(webhookIncrement{
+		Total:         4,
+		EndpointCalls: map[string]int32{EndpointHistory: 1, EndpointCurrent: 3},
+	})
+	if assert.Equal(t, 4, len(updates)) {
+		assert.Equal(t, "call_count", updates[0].Path)
+		assert.Equal(t, "pending", updates[1].Path)
+		assert.Equal(t, firestore.FieldPath{"endpoint_calls", EndpointCurrent}, updates[2].FieldPath)
+		assert.Equal(t, firestore.FieldPath{"endpoint_calls", EndpointHistory}, updates[3].FieldPath)
+	}
+}
+
+func TestCanHoldLease(t *testing.T) {
+	now := time.Now()
+	active := invocationLease{Holder: "instance-a", Expires: now.Add(time.Minute)}
+	expired := invocationLease{Holder: "instance-a", Expires: now.Add(-time.Minute)}
+
+	assert.True(t, canHoldLease(invocationLease{}, false, "instance-b", now))
+	assert.True(t, canHoldLease(active, true, "instance-a", now))
+	assert.False(t, canHoldLease(active, true, "instance-b", now))
+	assert.True(t, canHoldLease(expired, true, "instance-b", now))
+	assert.NotEqual(t, newInstanceID(), newInstanceID())
+}
+
+// generateCountryCodes returns n unique three letter codes.
+func generateCountryCodes(n int) []string {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		codes = append(codes, string([]byte{byte('A' + i/676%26), byte('A' + i/26%26), byte('A' + i%26)}))
+	}
+	return codes
+}
+
+func TestChunkCountries(t *testing.T) {
+	for _, countryCount := range []int{0, 1, 29, 30, 31, 100, 157} {
+		countries := generateCountryCodes(countryCount)
+		chunks := chunkCountries(countries, maxInQuerySize)
+
+		assert.Equal(t, (countryCount+maxInQuerySize-1)/maxInQuerySize, len(chunks))
+		seen := make(map[string]int)
+		for _, chunk := range chunks {
+			assert.NotEmpty(t, chunk)
+			assert.LessOrEqual(t, len(chunk), maxInQuerySize)
+			for _, code := range chunk {
+				seen[code]++
+			}
+		}
+		// every country is covered by exactly one chunk
+		assert.Equal(t, countryCount, len(seen))
+		for code, occurrences := range seen {
+			assert.Equal(t, 1, occurrences, code)
+		}
+	}
+}
+
+func TestIncrementManyCountries(t *testing.T) {
+	countries := generateCountryCodes(120)
+	counts := invocationCounts{}
+	for i, code := range countries {
+		for j := 0; j <= i%3; j++ {
+			counts.add(Invocation{Endpoint: EndpointCurrent, Country: code, QueryType: QueryCountry})
+		}
+		counts.add(Invocation{Endpoint: EndpointHistory, Country: code, QueryType: QueryCountry})
+	}
+	// 40 countries with each of 1, 2 and 3 calls to current, and one call to history
+	total := int32(40*(1+2+3) + 120)
+
+	updatedCountries := getUpdatedCountries(counts)
+	assert.Equal(t, 120, len(updatedCountries))
+	assert.NotContains(t, updatedCountries, "")
+
+	global := counts.incrementFor(webhookRegistration{Calls: 100})
+	assert.Equal(t, total, global.Total)
+	assert.Equal(t, int32(120), global.EndpointCalls[EndpointHistory])
+
+	globalHistory := counts.incrementFor(webhookRegistration{Endpoint: EndpointHistory, Calls: 500})
+	assert.Equal(t, int32(120), globalHistory.Total)
+
+	for i, code := range countries {
+		increment := counts.incrementFor(webhookRegistration{Country: code, Calls: 2})
+		assert.Equal(t, int32(i%3+2), increment.Total, code)
+	}
+}
+
+func TestClaimWebhook(t *testing.T) {
+	now := time.Now()
+	delivered := func(count int32) *int32 { return &count }
+
+	claim := claimWebhook(webhookRegistration{Calls: 5, Count: 16, DeliveredCount: delivered(4)}, now)
+	assert.True(t, claim.Triggered)
+	assert.Equal(t, int32(4), claim.PreviousCount)
+
+	claim = claimWebhook(webhookRegistration{Calls: 5, Count: 9, DeliveredCount: delivered(6)}, now)
+	assert.False(t, claim.Triggered)
+
+	// Paused webhooks are claimed without being triggered
+	claim = claimWebhook(webhookRegistration{Calls: 5, Count: 16, DeliveredCount: delivered(4), Paused: true}, now)
+	assert.False(t, claim.Triggered)
+	assert.False(t, claim.Delete)
+
+	// Webhooks registered before delivered counts were tracked are never triggered on their first claim
+	claim = claimWebhook(webhookRegistration{Calls: 5, Count: 16}, now)
+	assert.False(t, claim.Triggered)
+	assert.Equal(t, int32(16), claim.PreviousCount)
+
+	past := now.Add(-time.Minute)
+	claim = claimWebhook(webhookRegistration{Calls: 1, Count: 16, DeliveredCount: delivered(0), Expires: &past}, now)
+	assert.True(t, claim.Delete)
+	assert.False(t, claim.Triggered)
+}
+
+// applyUpdates applies the updates of plain fields to the webhook, as firestore would.
+func applyUpdates(webhook *webhookRegistration, updates []firestore.Update) {
+	for _, update := range updates {
+		switch update.Path {
+		case "delivered_count":
+			count := update.Value.(int32)
+			webhook.DeliveredCount = &count
+		case "pending":
+			webhook.Pending = update.Value.(bool)
+		case "failures":
+			if failures, ok := update.Value.(int32); ok {
+				webhook.Failures = failures
+			} else {
+				webhook.Failures = int32(update.Value.(int))
+			}
+		case "paused":
+			webhook.Paused = update.Value.(bool)
+		}
+	}
+}
+
+func TestDeliveryRetried(t *testing.T) {
+	var countryDB util.CountryDataset
+	if err := countryDB.Initialize("../internal/assets/renewable-share-energy.csv"); err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{AllowPrivateWebhooks: true}
+	client := util.NewWebhookClient(&config)
+	requests := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "", http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	delivered := int32(4)
+	webhook := webhookRegistration{URL: receiver.URL, Country: "NOR", Calls: 5, Count: 16, DeliveredCount: &delivered,
+		Pending: true, EndpointCalls: map[string]int32{EndpointHistory: 12}}
+	deliver := func() error {
+		claim := claimWebhook(webhook, time.Now())
+		applyUpdates(&webhook, claimUpdates(webhook, claim))
+		if !claim.Triggered {
+			return nil
+		}
+		check := webhookCheck{ID: "flaky", Body: webhook, PreviousCount: claim.PreviousCount}
+		err := deliverWebhookEvents(context.Background(), &config, client, []webhookCheck{check}, &countryDB)[0]
+		applyUpdates(&webhook, deliveryOutcomeUpdates(check, err, util.SettingsWebhookMaxFailures))
+		return err
+	}
+
+	// A failed delivery keeps the delivered count, and leaves the webhook pending to be retried
+	assert.Error(t, deliver())
+	assert.Equal(t, int32(4), *webhook.DeliveredCount)
+	assert.True(t, webhook.Pending)
+	assert.Equal(t, int32(1), webhook.Failures)
+
+	// The retry delivers every threshold passed since the last successful delivery
+	assert.Nil(t, deliver())
+	assert.Equal(t, int32(16), *webhook.DeliveredCount)
+	assert.False(t, webhook.Pending)
+	assert.Equal(t, int32(0), webhook.Failures)
+	assert.Equal(t, 4, requests)
+
+	// Webhooks failing too often are paused, and their thresholds are then claimed without delivery
+	updates := deliveryOutcomeUpdates(webhookCheck{Body: webhookRegistration{Failures: 4}}, assert.AnError,
+		util.SettingsWebhookMaxFailures)
+	applyUpdates(&webhook, updates)
+	assert.True(t, webhook.Paused)
+}
+
+func TestWorkerStatus(t *testing.T) {
+	assert.False(t, WorkersAlive(time.Now()))
+	recordHeartbeat(WorkerCache, time.Second)
+	recordHeartbeat(WorkerInvocation, time.Second)
+	assert.True(t, WorkersAlive(time.Now()))
+	assert.False(t, WorkersAlive(time.Now().Add(heartbeatTolerance*time.Second)))
+	status := GetWorkerStatus(time.Now())
+	assert.True(t, status[WorkerCache].Alive)
+
+	oldest := time.Now().Add(-time.Hour)
+	recordCacheStatus(map[string]CacheEntry{
+		"NOR": {Cca3: "NOR", LastUpdated: time.Now()},
+		"SWE": {Cca3: "SWE", LastUpdated: oldest},
+	}, true)
+	cacheStatus := GetCacheStatus()
+	assert.Equal(t, 2, cacheStatus.Entries)
+	if assert.NotNil(t, cacheStatus.OldestEntry) {
+		assert.True(t, oldest.Equal(*cacheStatus.OldestEntry))
+	}
+	assert.NotNil(t, cacheStatus.LastPushed)
+}
+
+func TestDrainInvocations(t *testing.T) {
+	invocationChannel := make(chan []Invocation, 3)
+	invocationChannel <- []Invocation{{Endpoint: EndpointCurrent, Country: "NOR", QueryType: QueryCountry}}
+	invocationChannel <- []Invocation{
+		{Endpoint: EndpointHistory, Country: "NOR", QueryType: QueryCountry},
+		{Endpoint: EndpointCurrent, Country: "SWE", QueryType: QueryNeighbour},
+	}
+	counts := invocationCounts{}
+	drainInvocations(invocationChannel, counts)
+	assert.Equal(t, int32(2), counts.countFor("NOR", ""))
+	assert.Equal(t, int32(1), counts.countFor("SWE", ""))
+	assert.Equal(t, 0, len(invocationChannel))
+
+	// a closed channel ends the drain as well
+	close(invocationChannel)
+	drainInvocations(invocationChannel, counts)
+	assert.Equal(t, int32(3), counts.countFor("", ""))
+}
+
+func TestInvocationRecorder(t *testing.T) {
+	recorder := NewInvocationRecorder(1)
+	recorder.Record(nil)
+	assert.Equal(t, 0, recorder.Queued())
+
+	// reports beyond the capacity of the channel are counted in the overflow buffer
+	recorder.Record([]Invocation{{Endpoint: EndpointCurrent, Country: "NOR", QueryType: QueryCountry}})
+	recorder.Record([]Invocation{
+		{Endpoint: EndpointCurrent, Country: "NOR", QueryType: QueryCountry},
+		{Endpoint: EndpointHistory, Country: "SWE", QueryType: QueryCountry},
+	})
+	assert.Equal(t, 1, recorder.Queued())
+
+	counts := invocationCounts{}
+	drainInvocations(recorder.channel, counts)
+	recorder.takeOverflow(counts)
+	assert.Equal(t, int32(2), counts.countFor("NOR", EndpointCurrent))
+	assert.Equal(t, int32(1), counts.countFor("SWE", EndpointHistory))
+	recorder.takeOverflow(counts)
+	assert.Equal(t, int32(3), counts.countFor("", ""))
+
+	// once the buffer is full, only invocations of keys already in the buffer are counted
+	recorder.Record([]Invocation{{Endpoint: EndpointCurrent, Country: "NOR", QueryType: QueryCountry}}) // fills channel
+	for i := 0; i < maxOverflowKeys; i++ {
+		recorder.Record([]Invocation{{Endpoint: EndpointCurrent, Country: strconv.Itoa(i)}})
+	}
+	recorder.Record([]Invocation{
+		{Endpoint: EndpointCurrent, Country: "0"},
+		{Endpoint: EndpointCurrent, Country: "DNK"},
+	})
+	counts = invocationCounts{}
+	recorder.takeOverflow(counts)
+	assert.Equal(t, maxOverflowKeys, len(counts))
+	assert.Equal(t, int32(2), counts.countFor("0", ""))
+	assert.Equal(t, int32(0), counts.countFor("DNK", ""))
+}
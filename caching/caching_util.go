@@ -3,11 +3,16 @@ package caching
 import (
 	"Assignment2/consts"
 	"Assignment2/fsutils"
+	"Assignment2/metrics"
+	"Assignment2/tracing"
 	"Assignment2/util"
 	"cloud.google.com/go/firestore"
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -38,25 +43,32 @@ func localCacheInit(cfg *util.Config) (map[string]CacheEntry, error) {
 // updateLocalCache updates the local cache by attempting to retrieve data matching
 // any registered misses. Requests are either made to internal stubbing if development is
 // set in config, 3d party API if false.
+// The upstream request is traced as part of the first miss, linked to the remaining misses.
 // Returns: True if cache has been updated, False otherwise.
 func updateLocalCache(cfg *util.Config, client *http.Client, cache *map[string]CacheEntry, misses []CacheMiss) bool {
 	joinedCountryCodes := getCodesStringFromMisses(misses)
-	var url string
-	if cfg.DevelopmentMode { // Uses internal stubbing service when in development mode
-		url = consts.StubDomain
-	} else {
-		url = consts.CountryDomain
-	}
-	url += consts.CountryCodePath + "?codes=" + joinedCountryCodes
+	ctx, span := startUpstreamSpan(misses, joinedCountryCodes)
+	defer span.End()
+	// Uses internal stubbing service when in development mode
+	url := cfg.CountriesURL() + consts.CountryCodePath + "?codes=" + joinedCountryCodes
 
-	request, err := http.NewRequest(http.MethodGet, url, nil)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Println("Cache Worker failed to create request to url " + url)
+		slog.Error("cache worker: failed to create request", "url", url, "error", err)
 		return false
 	}
+	requestStart := time.Now()
 	response, err2 := client.Do(request)
+	metrics.UpstreamRequestDuration.WithLabelValues(metrics.UpstreamCountries).Observe(time.Since(requestStart).Seconds())
 	if err2 != nil {
-		log.Println("cache worker failed to do request with url" + url)
+		metrics.UpstreamErrors.WithLabelValues(metrics.UpstreamCountries).Inc()
+		slog.Error("cache worker: failed to do request", "url", url, "error", err2)
+		span.RecordError(err2)
+		return false
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
+		metrics.UpstreamErrors.WithLabelValues(metrics.UpstreamCountries).Inc()
 	}
 
 	returnedData := make([]CacheEntry, 0)
@@ -72,6 +84,25 @@ func updateLocalCache(cfg *util.Config, client *http.Client, cache *map[string]C
 	return false
 }
 
+// startUpstreamSpan starts the span of an upstream request resolving the misses, as a child of
+// the span of the first miss with links to the spans of any other misses.
+func startUpstreamSpan(misses []CacheMiss, codes string) (context.Context, trace.Span) {
+	var parent context.Context
+	links := make([]trace.Link, 0, len(misses))
+	for i, miss := range misses {
+		if i == 0 {
+			parent = miss.Request.Ctx
+		} else if miss.Request.Ctx != nil {
+			links = append(links, trace.LinkFromContext(miss.Request.Ctx))
+		}
+	}
+	if parent == nil {
+		parent = context.Background()
+	}
+	return tracing.Tracer().Start(parent, "cache worker: fetch countries",
+		trace.WithLinks(links...), trace.WithAttributes(attribute.String("codes", codes)))
+}
+
 // getCodesStringFromMisses collects all cca3 codes from the cache misses and
 // concatenates them into a single string of codes separated by ','
 func getCodesStringFromMisses(misses []CacheMiss) string {
@@ -0,0 +1,105 @@
+package caching
+
+import (
+	"Assignment2/util"
+	"cloud.google.com/go/firestore"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"time"
+)
+
+// invocationLeaseDocument is the ID of the lease document deciding which service
+// instance performs trigger checks and delivery of webhook events.
+const invocationLeaseDocument = "invocation-worker"
+
+// leaseDurationFactor is the lease duration as a multiple of Config.WebhookEventRate.
+// The leader renews the lease every cycle, so the lease only runs out if the leader
+// misses several cycles in a row.
+const leaseDurationFactor = 3
+
+// invocationLease provides the document structure of the invocation lease.
+type invocationLease struct {
+	Holder  string    `firestore:"holder"`
+	Expires time.Time `firestore:"expires"`
+}
+
+// newInstanceID returns an ID identifying this service instance as a lease holder.
+func newInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "instance"
+	}
+	suffix := make([]byte, 4)
+	if _, err = rand.Read(suffix); err != nil {
+		return hostname
+	}
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// leaseDuration returns how long the invocation lease is held for after each renewal.
+func leaseDuration(cfg *util.Config) time.Duration {
+	return leaseDurationFactor * cfg.Reloadable().WebhookEventRate
+}
+
+// canHoldLease returns true if the instance may take or renew the lease, meaning the
+// lease doesn't exist, has expired, or is already held by the instance.
+func canHoldLease(lease invocationLease, exists bool, instanceID string, now time.Time) bool {
+	return !exists || lease.Holder == instanceID || !lease.Expires.After(now)
+}
+
+// acquireLease attempts to take or renew the invocation lease for the instance within a
+// transaction, so that only one instance can hold the lease at any time.
+//
+// On success: true if the instance holds the lease, false otherwise, nil
+// On failure: false, error
+func acquireLease(cfg *util.Config, instanceID string, duration time.Duration, now time.Time) (bool, error) {
+	ref := cfg.FirestoreClient.Collection(cfg.LeaseCollection).Doc(invocationLeaseDocument)
+	isHolder := false
+	err := cfg.FirestoreClient.RunTransaction(*cfg.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		isHolder = false
+		lease := invocationLease{}
+		exists := true
+		snapshot, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			exists = false
+		} else if err != nil {
+			return err
+		} else if err = snapshot.DataTo(&lease); err != nil {
+			exists = false // a malformed lease is overwritten
+		}
+		if !canHoldLease(lease, exists, instanceID, now) {
+			return nil
+		}
+		isHolder = true
+		return tx.Set(ref, invocationLease{Holder: instanceID, Expires: now.Add(duration)})
+	})
+	if err != nil {
+		return false, err
+	}
+	return isHolder, nil
+}
+
+// releaseLease gives up the invocation lease if held by the instance, allowing another
+// instance to take over without waiting for the lease to expire.
+// On success: nil
+// On failure: error
+func releaseLease(cfg *util.Config, instanceID string) error {
+	ref := cfg.FirestoreClient.Collection(cfg.LeaseCollection).Doc(invocationLeaseDocument)
+	return cfg.FirestoreClient.RunTransaction(*cfg.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		lease := invocationLease{}
+		if err = snapshot.DataTo(&lease); err != nil || lease.Holder != instanceID {
+			return nil
+		}
+		return tx.Delete(ref)
+	})
+}
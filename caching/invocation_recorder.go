@@ -0,0 +1,80 @@
+package caching
+
+import (
+	"Assignment2/metrics"
+	"Assignment2/util"
+	"sync"
+)
+
+// maxOverflowKeys limits the number of countries and endpoints counted in the overflow
+// buffer. Handlers only report countries found in the dataset, so the limit is only
+// reached if the worker has stopped picking up the buffer.
+const maxOverflowKeys = 1024
+
+// InvocationRecorder reports invocations from the endpoint handlers to the invocation worker
+// without blocking. Invocations not fitting on the channel to the worker are counted in an
+// overflow buffer, which the worker picks up on its next cycle. Once the buffer is full,
+// invocations of countries and endpoints not already in the buffer are dropped.
+type InvocationRecorder struct {
+	channel  chan []Invocation
+	mutex    sync.Mutex
+	overflow invocationCounts
+}
+
+// NewInvocationRecorder returns a recorder queueing up to capacity reports for the worker
+// before overflowing.
+func NewInvocationRecorder(capacity int) *InvocationRecorder {
+	return &InvocationRecorder{
+		channel:  make(chan []Invocation, capacity),
+		overflow: make(invocationCounts),
+	}
+}
+
+// Record reports the invocations of a single request to the invocation worker, without
+// waiting on the worker.
+func (r *InvocationRecorder) Record(invocations []Invocation) {
+	if len(invocations) == 0 {
+		return
+	}
+	select {
+	case r.channel <- invocations:
+		metrics.InvocationReports.WithLabelValues(metrics.ReportQueued).Add(float64(len(invocations)))
+		return
+	default:
+	}
+
+	dropped := 0
+	r.mutex.Lock()
+	for _, invocation := range invocations {
+		key := invocationKey{Country: invocation.Country, Endpoint: invocation.Endpoint}
+		if _, ok := r.overflow[key]; !ok && len(r.overflow) >= maxOverflowKeys {
+			dropped++
+			continue
+		}
+		r.overflow[key] += 1
+	}
+	r.mutex.Unlock()
+
+	metrics.InvocationReports.WithLabelValues(metrics.ReportOverflow).Add(float64(len(invocations) - dropped))
+	if dropped != 0 {
+		metrics.InvocationReports.WithLabelValues(metrics.ReportDropped).Add(float64(dropped))
+		util.Logger(invocations[0].Ctx).Warn("invocation recorder: overflow buffer full, dropped invocations",
+			"dropped", dropped)
+	}
+}
+
+// Queued returns the number of reports queued on the channel to the worker.
+func (r *InvocationRecorder) Queued() int {
+	return len(r.channel)
+}
+
+// takeOverflow adds the invocations counted in the overflow buffer to counts, emptying the buffer.
+func (r *InvocationRecorder) takeOverflow(counts invocationCounts) {
+	r.mutex.Lock()
+	overflow := r.overflow
+	r.overflow = make(invocationCounts)
+	r.mutex.Unlock()
+	for key, count := range overflow {
+		counts[key] += count
+	}
+}
@@ -1,34 +1,57 @@
 package caching
 
 import (
+	"Assignment2/metrics"
+	"Assignment2/tracing"
 	"Assignment2/util"
 	"bytes"
 	"cloud.google.com/go/firestore"
+	"context"
 	"encoding/json"
 	"errors"
-	"google.golang.org/api/iterator"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 )
 
 // webhookCheck encapsulates the ID of a webhook in the DB along
-// with the fields of the document.
+// with the fields of the document. PreviousCount is the call_count
+// up to which events have already been delivered.
 type webhookCheck struct {
-	ID   string
-	Body webhookRegistration
+	ID            string
+	Body          webhookRegistration
+	PreviousCount int32
 }
 
 // WebhookRegistration provides the document structure of a
 // webhook registration. Count is the invocation
-// count for the country since the registration of the webhook.
+// count for the country since the registration of the webhook,
+// and DeliveredCount the count up to which events have been delivered.
+// Pending is set when Count has been incremented beyond DeliveredCount or a delivery failed,
+// and EndpointCalls holds the invocations per endpoint since the last delivery.
+// Failures is the number of consecutive failed deliveries, and PublishedCount the count
+// up to which threshold events have been written to the event collection.
 //
-// WARNING: Count MUST be updated in DB on an invocation check.
+// DeliveredCount is nil for webhooks registered before delivered counts were tracked.
+//
+// WARNING: Count MUST only be updated in DB through atomic increments.
 type webhookRegistration struct {
-	URL     string `firestore:"url"`
-	Country string `firestore:"country"`
-	Calls   int32  `firestore:"calls"`
-	Count   int32  `firestore:"call_count"`
+	URL            string           `firestore:"url"`
+	Country        string           `firestore:"country"`
+	Endpoint       string           `firestore:"endpoint"`
+	Calls          int32            `firestore:"calls"`
+	Count          int32            `firestore:"call_count"`
+	DeliveredCount *int32           `firestore:"delivered_count"`
+	PublishedCount *int32           `firestore:"published_count"`
+	Pending        bool             `firestore:"pending"`
+	EndpointCalls  map[string]int32 `firestore:"endpoint_calls"`
+	Expires        *time.Time       `firestore:"expires,omitempty"`
+	Batch          bool             `firestore:"batch"`
+	Paused         bool             `firestore:"paused"`
+	Failures       int32            `firestore:"failures"`
 }
 
 // WebhookTrigger contains the information to be sent to the url of a registered
@@ -36,173 +59,640 @@ type webhookRegistration struct {
 type webhookTrigger struct {
 	WebhookId  string `json:"webhook_id"`
 	Country    string `json:"country"`
+	Endpoint   string `json:"endpoint"`
 	TotalCalls int32  `json:"calls"`
 }
 
-// InvocationWorker receives updates from endpoint handlers and updates
-// an in memory data structure mapping country code to invocation count.
-// Registered webhooks are periodically checked in DB to see if they should
-// trigger, and if so, a message is sent to the registered url.
-func InvocationWorker(cfg *util.Config, stop chan struct{}, done chan struct{}, countryDB *util.CountryDataset, invocationChannel chan []string) {
+// webhookBatchTrigger contains the information sent to the url of a webhook registered
+// for batched delivery, listing every threshold passed since the last check.
+type webhookBatchTrigger struct {
+	WebhookId  string  `json:"webhook_id"`
+	Country    string  `json:"country"`
+	Endpoint   string  `json:"endpoint"`
+	TotalCalls int32   `json:"calls"`
+	Thresholds []int32 `json:"thresholds"`
+}
+
+// webhookIncrement describes the increments to apply to a webhook for the invocations of a cycle.
+type webhookIncrement struct {
+	Total         int32
+	EndpointCalls map[string]int32
+}
 
-	// maps cca3 codes to the invocation count for a current cycle.
-	invocationCounts := make(map[string]int32, 0)
+// webhookClaim describes the outcome of a leader claiming the pending invocations of a webhook.
+type webhookClaim struct {
+	Delete        bool  // webhook has expired and should be removed
+	Triggered     bool  // webhook has passed at least one threshold and should be delivered to
+	PreviousCount int32 // count up to which events had been delivered prior to the claim
+}
 
-	client := http.Client{}
+// invocationKey identifies the invocation count of a country on a single endpoint.
+type invocationKey struct {
+	Country  string
+	Endpoint string
+}
+
+// invocationCounts maps countries and endpoints to the invocation count of a cycle.
+type invocationCounts map[invocationKey]int32
+
+// add counts up a single invocation.
+func (counts invocationCounts) add(invocation Invocation) {
+	counts[invocationKey{Country: invocation.Country, Endpoint: invocation.Endpoint}] += 1
+}
+
+// countFor returns the invocation count matching a webhook registration, where
+// the empty string for country or endpoint matches any country or endpoint.
+func (counts invocationCounts) countFor(country string, endpoint string) int32 {
+	total := int32(0)
+	for key, count := range counts {
+		if (country == "" || key.Country == country) && (endpoint == "" || key.Endpoint == endpoint) {
+			total += count
+		}
+	}
+	return total
+}
+
+// incrementFor returns the increments to apply to the webhook for the invocations of the cycle.
+func (counts invocationCounts) incrementFor(webhook webhookRegistration) webhookIncrement {
+	increment := webhookIncrement{EndpointCalls: make(map[string]int32)}
+	for key, count := range counts {
+		if (webhook.Country == "" || key.Country == webhook.Country) &&
+			(webhook.Endpoint == "" || key.Endpoint == webhook.Endpoint) {
+			increment.Total += count
+			increment.EndpointCalls[key.Endpoint] += count
+		}
+	}
+	return increment
+}
+
+// triggerEndpoint returns the endpoint reported as having driven the count of a webhook;
+// the endpoint it is registered to, or otherwise the endpoint with the most invocations
+// since the last delivery. Ties are resolved alphabetically.
+func triggerEndpoint(webhook webhookRegistration) string {
+	if webhook.Endpoint != "" {
+		return webhook.Endpoint
+	}
+	dominant := ""
+	for endpoint, count := range webhook.EndpointCalls {
+		if count <= 0 {
+			continue // calls of earlier deliveries are subtracted, see deliveryOutcomeUpdates
+		}
+		if dominant == "" || count > webhook.EndpointCalls[dominant] ||
+			(count == webhook.EndpointCalls[dominant] && endpoint < dominant) {
+			dominant = endpoint
+		}
+	}
+	return dominant
+}
+
+// maxConcurrentDeliveries limits how many webhooks are delivered to at the same time.
+const maxConcurrentDeliveries = 10
+
+// maxInQuerySize is the maximum number of values supported by firestore 'in' queries.
+const maxInQuerySize = 30
+
+// maxTransactionWrites is the maximum number of writes supported by a firestore transaction.
+const maxTransactionWrites = 500
+
+// maxClaimedWebhooks is the number of webhooks claimed per transaction, each claim writing
+// the webhook along with a threshold event if triggered.
+const maxClaimedWebhooks = maxTransactionWrites / 2
+
+// InvocationWorker receives updates from endpoint handlers through the recorder and updates
+// an in memory data structure mapping country code and endpoint to invocation count.
+//
+// Counts are periodically applied to the registered webhooks in DB as atomic increments,
+// allowing any number of service instances to count invocations side by side. Only the
+// instance holding the invocation lease checks webhooks for triggers, and if triggered,
+// sends a message to the registered url. Triggers are also written to the event collection,
+// from which every instance publishes them, see WatchThresholdEvents.
+func InvocationWorker(cfg *util.Config, stop chan struct{}, done chan struct{}, countryDB *util.CountryDataset,
+	recorder *InvocationRecorder) {
+
+	instanceID := newInstanceID()
+	client := tracing.InstrumentClient(util.NewWebhookClient(cfg))
 	// Worker will stop to synchronize with the webhook DB every X seconds
 	// set in the server config. When not synchronizing and doing triggers
 	// the worker will count up any invocations of countries on the API endpoints.
+	interval := func() time.Duration { return cfg.Reloadable().WebhookEventRate }
+	counts, stopped := countInvocations(interval, stop, recorder, func(counts invocationCounts) {
+		if len(counts) != 0 {
+			pushInvocationCounts(cfg, counts)
+		}
+		isLeader, err := acquireLease(cfg, instanceID, leaseDuration(cfg), time.Now())
+		if err != nil {
+			slog.Error("invocation worker: failed to acquire lease", "error", err)
+		} else if isLeader {
+			handlePendingWebhooks(cfg, client, countryDB)
+		}
+	})
+	if !stopped {
+		// TODO: Shut down due to channel connection loss
+		return
+	}
+	// Counts any queued invocations and flushes them to the DB before shutting down.
+	drainInvocations(recorder.channel, counts)
+	recorder.takeOverflow(counts)
+	if len(counts) != 0 {
+		pushInvocationCounts(cfg, counts)
+	}
+	if err := releaseLease(cfg, instanceID); err != nil {
+		slog.Error("invocation worker: failed to release lease", "error", err)
+	}
+	done <- struct{}{}
+}
+
+// countInvocations maps cca3 codes and endpoints to the invocation counts reported through the
+// recorder, passing the counts of every cycle to cycle on a ticker. The ticker is reset whenever
+// the interval changes, picking up changes as the config is reloaded, and a heartbeat is
+// recorded on every tick. Runs until a stop signal is received or the channel is closed.
+//
+// Returns the counts of the unfinished cycle, and true if stopped by the stop signal.
+func countInvocations(interval func() time.Duration, stop chan struct{}, recorder *InvocationRecorder,
+	cycle func(invocationCounts)) (invocationCounts, bool) {
+
+	counts := make(invocationCounts, 0)
+	current := interval()
+	ticker := time.NewTicker(current)
+	defer ticker.Stop()
+	recordHeartbeat(WorkerInvocation, current)
 	for {
+		if latest := interval(); latest != current {
+			current = latest
+			ticker.Reset(current)
+			recordHeartbeat(WorkerInvocation, current)
+		}
 		select {
-		case <-time.After(cfg.WebhookEventRate):
-			if len(invocationCounts) != 0 {
-				handleInvocations(cfg, &client, countryDB, invocationCounts)
-				invocationCounts = map[string]int32{} // reset of counters
-			}
+		case <-ticker.C:
+			recordHeartbeat(WorkerInvocation, current)
+			recorder.takeOverflow(counts)
+			cycle(counts)
+			counts = invocationCounts{} // reset of counters
 		case <-stop:
-			if len(invocationCounts) != 0 {
-				handleInvocations(cfg, &client, countryDB, invocationCounts)
+			return counts, true
+		case invocations, ok := <-recorder.channel:
+			if !ok {
+				return counts, false
 			}
-			done <- struct{}{}
-			break
+			if len(invocations) == 0 {
+				continue
+			}
+			// invocations are reported in batches from a single request
+			ctx, span := tracing.StartSpan(invocations[0].Ctx, "invocation worker: count invocations",
+				attribute.Int("invocations", len(invocations)))
+			for _, invocation := range invocations {
+				util.Logger(ctx).Debug("invocation worker: counting invocation",
+					"country", invocation.Country, "endpoint", invocation.Endpoint, "query_type", invocation.QueryType)
+				counts.add(invocation)
+			}
+			span.End()
+		}
+	}
+}
+
+// drainInvocations counts any invocations still queued on the channel, without blocking.
+func drainInvocations(invocationChannel chan []Invocation, counts invocationCounts) {
+	for {
+		select {
 		case invocations, ok := <-invocationChannel:
-			if ok != true {
-				// TODO: Shut down due to channel connection loss
+			if !ok {
 				return
-			} // updates invocation count and sets updated to true
+			}
 			for _, invocation := range invocations {
-				if _, ok = invocationCounts[invocation]; ok {
-					invocationCounts[invocation] += 1
-				} else {
-					invocationCounts[invocation] = 1
-				}
+				counts.add(invocation)
 			}
+		default:
+			return
 		}
 	}
 }
 
-// handleInvocations
-func handleInvocations(cfg *util.Config, client *http.Client, countryDB *util.CountryDataset, invocationCounts map[string]int32) {
-	totalInvocations := int32(0)
-	for _, val := range invocationCounts {
-		totalInvocations += val
-	}
-	invocationCounts[""] = totalInvocations
-	if cfg.DebugMode {
-		log.Println("handling invocations for ", len(invocationCounts), " invocations")
-		for code := range invocationCounts {
-			log.Println(code)
-		}
+// pushInvocationCounts applies the invocation counts of a cycle to all affected webhooks as
+// atomic increments, marking them as pending. Webhooks registered to a set of countries are
+// updated in chunks of up to maxInQuerySize countries, while webhooks registered to any
+// country are updated through a query of their own.
+func pushInvocationCounts(cfg *util.Config, invocationCounts invocationCounts) {
+	slog.Debug("invocation worker: pushing invocation counts", "keys", len(invocationCounts))
+	_, span := tracing.StartSpan(context.Background(), "invocation worker: push invocation counts",
+		attribute.Int("keys", len(invocationCounts)))
+	defer span.End()
+	ref := cfg.FirestoreClient.Collection(cfg.WebhookCollection)
+	queries := make([]firestore.Query, 0)
+	for _, chunk := range chunkCountries(getUpdatedCountries(invocationCounts), maxInQuerySize) {
+		queries = append(queries, ref.Where("country", "in", chunk))
 	}
-	updatedCountries := getUpdatedCountries(invocationCounts)
-	// firestore queries using 'in' supports up to 30 entries.
-	maxInSize := 30
-	// chunks = count of request batches that has to be performed to complete sync.
-	chunks := ((len(updatedCountries) - 1) / maxInSize) + 1
-	for i := 0; i < chunks; i++ {
-		// queries only on countries that have seen an update in invocations
-		ref := cfg.FirestoreClient.Collection(cfg.WebhookCollection)
-		query := ref.Where("country", "in",
-			updatedCountries[i*maxInSize:util.Min((i+1)*maxInSize, len(invocationCounts))],
-		)
-		iter := query.Documents(*cfg.Ctx)
-		// update is done as atomic bulk operations
-		bulkOperation := cfg.FirestoreClient.BulkWriter(*cfg.Ctx)
+	queries = append(queries, ref.Where("country", "==", ""))
 
-		err, webhooksToCheck := updateCallCountsAndGetEvents(iter, bulkOperation, invocationCounts)
+	for _, query := range queries {
+		docs, err := query.Documents(*cfg.Ctx).GetAll()
 		if err != nil {
-			log.Println("invocation worker:", err)
+			slog.Error("invocation worker: failed to read webhooks", "error", err)
+			continue
 		}
-		// Outbound messages done for all triggered webhooks
-		for _, webhook := range webhooksToCheck {
-			if err := doWebhookEvents(cfg, client, webhook, countryDB, invocationCounts); err != nil {
-				log.Println("invocation worker: ", err)
+		bulkOperation := cfg.FirestoreClient.BulkWriter(*cfg.Ctx)
+		for _, doc := range docs {
+			webhook := webhookRegistration{}
+			if err = doc.DataTo(&webhook); err != nil {
+				slog.Warn("invocation worker: skipping malformed webhook", "webhook", doc.Ref.ID, "error", err)
+				continue
+			}
+			updates := incrementUpdates(invocationCounts.incrementFor(webhook))
+			if len(updates) == 0 {
+				continue
+			}
+			if _, err = bulkOperation.Update(doc.Ref, updates); err != nil {
+				slog.Error("invocation worker: failed to update webhook", "webhook", doc.Ref.ID, "error", err)
 			}
 		}
 		bulkOperation.End() // Executes write operations
 	}
 }
 
-// getUpdatedCountries returns a list of all countries found in the map for use with
-// firestore queries.
-func getUpdatedCountries(invocations map[string]int32) []string {
-	updatedCountries := make([]string, 0)
-	for cca3 := range invocations {
-		updatedCountries = append(updatedCountries, cca3)
+// incrementUpdates returns the firestore updates applying the increment to a webhook,
+// or nil if there is nothing to increment.
+func incrementUpdates(increment webhookIncrement) []firestore.Update {
+	if increment.Total == 0 {
+		return nil
 	}
-	return updatedCountries
+	updates := []firestore.Update{
+		{Path: "call_count", Value: firestore.Increment(increment.Total)},
+		{Path: "pending", Value: true},
+	}
+	// sorted for a deterministic order of updates
+	endpoints := make([]string, 0, len(increment.EndpointCalls))
+	for endpoint := range increment.EndpointCalls {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		if endpoint == "" {
+			continue // not addressable as a field path
+		}
+		updates = append(updates, firestore.Update{
+			FieldPath: firestore.FieldPath{"endpoint_calls", endpoint},
+			Value:     firestore.Increment(increment.EndpointCalls[endpoint]),
+		})
+	}
+	return updates
 }
 
-// updateCallCountsAndGetEvents iterates through the documents for a batch of countries
-// and updates the call_count of all documents.
-// On success: nil, list of webhooks that have been triggered
-// On failure: error, nil slice or partially constructed slice.
-func updateCallCountsAndGetEvents(iter *firestore.DocumentIterator, bulkOperation *firestore.BulkWriter,
-	invocationMap map[string]int32) (error, []webhookCheck) {
+// handlePendingWebhooks claims the pending invocations of all webhooks, delivering events
+// to triggered webhooks and recording the outcome of each delivery. Expired webhooks
+// are deleted, along with threshold events older than eventRetention. Webhooks whose delivery failed are made pending again once every webhook
+// has been claimed, so that they are retried in the next cycle rather than in this one.
+// Should only be done by the instance holding the invocation lease.
+func handlePendingWebhooks(cfg *util.Config, client *http.Client, countryDB *util.CountryDataset) {
+	ctx, span := tracing.StartSpan(context.Background(), "invocation worker: handle pending webhooks")
+	defer span.End()
+	ref := cfg.FirestoreClient.Collection(cfg.WebhookCollection)
+	deleteExpired(cfg, ref.Where("expires", "<=", time.Now()), "webhook")
+	deleteExpired(cfg, cfg.FirestoreClient.Collection(cfg.EventCollection).
+		Where("created", "<=", time.Now().Add(-eventRetention)), "event")
 
-	var webhooksToCheck []webhookCheck
+	query := ref.Where("pending", "==", true).Limit(maxClaimedWebhooks)
+	var failed []webhookCheck
+	var failedErrors []error
 	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
+		_, claimSpan := tracing.StartSpan(ctx, "invocation worker: claim pending webhooks")
+		triggeredWebhooks, claimed, err := claimPendingWebhooks(cfg, query)
+		claimSpan.SetAttributes(attribute.Int("claimed", claimed), attribute.Int("triggered", len(triggeredWebhooks)))
+		tracing.EndSpan(claimSpan, err)
+		if err != nil {
+			slog.Error("invocation worker: failed to claim pending webhooks", "error", err)
 			break
 		}
-		if err != nil {
-			return err, webhooksToCheck
+		deliveryErrors := deliverWebhookEvents(ctx, cfg, client, triggeredWebhooks, countryDB)
+
+		outcomeOperation := cfg.FirestoreClient.BulkWriter(*cfg.Ctx)
+		for j, webhook := range triggeredWebhooks {
+			if deliveryErrors[j] != nil {
+				slog.Warn("invocation worker: delivery failed", "webhook", webhook.ID, "error", deliveryErrors[j])
+				failed = append(failed, webhook)
+				failedErrors = append(failedErrors, deliveryErrors[j])
+				continue
+			}
+			if err := recordDeliveryOutcome(cfg, outcomeOperation, webhook, nil); err != nil {
+				slog.Error("invocation worker: failed to record delivery outcome", "webhook", webhook.ID, "error", err)
+			}
 		}
-		webhook := webhookRegistration{}
-		if err = doc.DataTo(&webhook); err != nil {
-			return err, webhooksToCheck
+		outcomeOperation.End()
+		// Claimed webhooks are no longer pending, so a full batch implies more may remain.
+		if claimed < maxClaimedWebhooks {
+			break
+		}
+	}
+	failureOperation := cfg.FirestoreClient.BulkWriter(*cfg.Ctx)
+	for j, webhook := range failed {
+		if err := recordDeliveryOutcome(cfg, failureOperation, webhook, failedErrors[j]); err != nil {
+			slog.Error("invocation worker: failed to record delivery outcome", "webhook", webhook.ID, "error", err)
+		}
+	}
+	failureOperation.End()
+}
+
+// deleteExpired deletes all documents matched by the query, logged as the kind of document.
+func deleteExpired(cfg *util.Config, query firestore.Query, kind string) {
+	docs, err := query.Documents(*cfg.Ctx).GetAll()
+	if err != nil {
+		slog.Error("invocation worker: failed to read expired documents", "kind", kind, "error", err)
+		return
+	}
+	bulkOperation := cfg.FirestoreClient.BulkWriter(*cfg.Ctx)
+	for _, doc := range docs {
+		if _, err = bulkOperation.Delete(doc.Ref); err != nil {
+			slog.Error("invocation worker: failed to delete expired document", kind, doc.Ref.ID, "error", err)
 		}
-		_, err = bulkOperation.Update(
-			doc.Ref,
-			[]firestore.Update{
-				{Path: "call_count", Value: webhook.Count + invocationMap[webhook.Country]},
-			})
+	}
+	bulkOperation.End()
+}
+
+// claimPendingWebhooks claims the pending invocations of the webhooks matched by the query
+// within a single transaction, see claimUpdates, writing a threshold event for each triggered
+// webhook with thresholds not yet published. Increments done concurrently by other service
+// instances cause the transaction to be retried, so no invocations are lost or claimed twice.
+//
+// On success: webhooks that have been triggered, the number of claimed webhooks, nil
+// On failure: nil, 0, error
+func claimPendingWebhooks(cfg *util.Config, query firestore.Query) ([]webhookCheck, int, error) {
+	var triggeredWebhooks []webhookCheck
+	claimed := 0
+	err := cfg.FirestoreClient.RunTransaction(*cfg.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// The transaction is retried on contention, discarding results of any earlier attempt.
+		triggeredWebhooks = make([]webhookCheck, 0)
+		docs, err := tx.Documents(query).GetAll()
 		if err != nil {
-			return err, webhooksToCheck
+			return err
 		}
-		webhooksToCheck = append(webhooksToCheck, webhookCheck{ID: doc.Ref.ID, Body: webhook})
+		claimed = len(docs)
+		now := time.Now()
+		eventRef := cfg.FirestoreClient.Collection(cfg.EventCollection)
+		for _, doc := range docs {
+			webhook := webhookRegistration{}
+			if err = doc.DataTo(&webhook); err != nil {
+				slog.Warn("invocation worker: skipping malformed webhook", "webhook", doc.Ref.ID, "error", err)
+				err = tx.Update(doc.Ref, []firestore.Update{{Path: "pending", Value: false}})
+			} else if claim := claimWebhook(webhook, now); claim.Delete {
+				err = tx.Delete(doc.Ref)
+			} else {
+				err = tx.Update(doc.Ref, claimUpdates(webhook, claim))
+				if claim.Triggered {
+					check := webhookCheck{ID: doc.Ref.ID, Body: webhook, PreviousCount: claim.PreviousCount}
+					triggeredWebhooks = append(triggeredWebhooks, check)
+					if event, ok := thresholdEventFor(check); ok && err == nil {
+						err = tx.Create(eventRef.NewDoc(), event)
+					}
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
 	}
-	return nil, webhooksToCheck
+	return triggeredWebhooks, claimed, nil
+}
+
+// claimWebhook determines the outcome of claiming the pending invocations of a webhook.
+// Expired webhooks are to be deleted, while paused webhooks are claimed without ever
+// being triggered. Webhooks without a delivered count are claimed up to their current
+// count without being triggered, as it is unknown what has already been delivered.
+func claimWebhook(webhook webhookRegistration, now time.Time) webhookClaim {
+	if isExpired(webhook, now) {
+		return webhookClaim{Delete: true}
+	}
+	if webhook.DeliveredCount == nil {
+		return webhookClaim{PreviousCount: webhook.Count}
+	}
+	previousCount := *webhook.DeliveredCount
+	_, triggers := countTriggers(previousCount, webhook.Count, webhook.Calls)
+	return webhookClaim{PreviousCount: previousCount, Triggered: triggers > 0 && !webhook.Paused}
+}
+
+// claimUpdates returns the firestore updates claiming the pending invocations of a webhook.
+// Webhooks that are not triggered are marked as delivered up to their current count, while
+// triggered webhooks keep their delivered count until their delivery succeeds, so that
+// thresholds are delivered at least once. Triggered webhooks are marked as published up to
+// their current count, as their threshold event is written along with the claim.
+func claimUpdates(webhook webhookRegistration, claim webhookClaim) []firestore.Update {
+	if claim.Triggered {
+		return []firestore.Update{
+			{Path: "pending", Value: false},
+			{Path: "published_count", Value: webhook.Count},
+		}
+	}
+	return []firestore.Update{
+		{Path: "delivered_count", Value: webhook.Count},
+		{Path: "pending", Value: false},
+		{Path: "endpoint_calls", Value: map[string]int32{}},
+	}
+}
+
+// getUpdatedCountries returns a list of all unique countries found in the map for use with
+// firestore queries. The empty string is left out, as it represents any country.
+func getUpdatedCountries(invocations invocationCounts) []string {
+	uniqueCountries := make(map[string]struct{})
+	for key := range invocations {
+		if key.Country != "" {
+			uniqueCountries[key.Country] = struct{}{}
+		}
+	}
+	updatedCountries := make([]string, 0, len(uniqueCountries))
+	for cca3 := range uniqueCountries {
+		updatedCountries = append(updatedCountries, cca3)
+	}
+	return updatedCountries
+}
+
+// chunkCountries splits the countries into consecutive chunks of at most size countries.
+func chunkCountries(countries []string, size int) [][]string {
+	chunks := make([][]string, 0, (len(countries)+size-1)/size)
+	for start := 0; start < len(countries); start += size {
+		chunks = append(chunks, countries[start:util.Min(start+size, len(countries))])
+	}
+	return chunks
+}
+
+// isExpired returns true if the webhook has an expiry timestamp that has passed.
+func isExpired(webhook webhookRegistration, now time.Time) bool {
+	return webhook.Expires != nil && !webhook.Expires.After(now)
+}
+
+// countTriggers returns how many multiples of calls had been passed at oldCount, along with
+// how many further multiples have been passed going from oldCount to newCount.
+func countTriggers(oldCount int32, newCount int32, calls int32) (int32, int32) {
+	if calls <= 0 {
+		return 0, 0
+	}
+	previousTriggers := oldCount / calls
+	return previousTriggers, newCount/calls - previousTriggers
+}
+
+// passedThresholds returns the multiples of the calls of the webhook passed since the last
+// delivery, in increasing order.
+func passedThresholds(webhook webhookCheck) []int32 {
+	previousTriggers, triggers := countTriggers(webhook.PreviousCount, webhook.Body.Count, webhook.Body.Calls)
+	if triggers <= 0 {
+		return nil
+	}
+	thresholds := make([]int32, triggers)
+	for j := range thresholds {
+		thresholds[j] = (previousTriggers + int32(j) + 1) * webhook.Body.Calls
+	}
+	return thresholds
+}
+
+// deliverWebhookEvents performs the outgoing messaging of all the triggered webhooks
+// concurrently, with up to maxConcurrentDeliveries deliveries in progress at once.
+// Returns the outcome of each delivery, in the same order as the webhooks.
+func deliverWebhookEvents(ctx context.Context, cfg *util.Config, client *http.Client, webhooks []webhookCheck,
+	countryDB *util.CountryDataset) []error {
+
+	deliveryErrors := make([]error, len(webhooks))
+	slots := make(chan struct{}, maxConcurrentDeliveries)
+	var wg sync.WaitGroup
+	for i := range webhooks {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			deliveryCtx, span := tracing.StartSpan(ctx, "invocation worker: deliver webhook events",
+				attribute.String("webhook", webhooks[i].ID))
+			deliveryErrors[i] = doWebhookEvents(deliveryCtx, cfg, client, webhooks[i], countryDB)
+			tracing.EndSpan(span, deliveryErrors[i])
+			<-slots
+		}(i)
+	}
+	wg.Wait()
+	return deliveryErrors
 }
 
 // doWebhookEvents performs outgoing messaging for triggered webhooks.
 // A separate message will be sent out for each multiple of the clients
-// 'calls' value since the last check was done, where 'calls' how many
+// 'calls' value passed since the last delivery, where 'calls' how many
 // calls should go to a specified endpoint before an event triggers.
+// Messages report the endpoint driving the count, see triggerEndpoint.
+// Webhooks registered for batched delivery instead receive a single
+// message listing every multiple passed.
 // On success: nil
 // On failure: error
-func doWebhookEvents(cfg *util.Config, client *http.Client, webhook webhookCheck,
-	countryDB *util.CountryDataset, invocations map[string]int32) error {
-
-	oldCount := webhook.Body.Count
-	newCount := invocations[webhook.Body.Country] + oldCount
-	previousTriggers := oldCount / webhook.Body.Calls
-	triggers := newCount/webhook.Body.Calls - previousTriggers
-	if triggers != 0 {
-		for j := 0; int32(j) < triggers; j++ {
-			countryName, err := countryDB.GetFullName(webhook.Body.Country)
-			if err != nil {
-				log.Println("webhook worker: ", err)
-			}
-			message := webhookTrigger{
-				WebhookId:  webhook.ID,
-				Country:    countryName,
-				TotalCalls: previousTriggers*webhook.Body.Calls + int32(j+1)*webhook.Body.Calls,
-			}
-			payload, err := json.Marshal(message)
-			if err != nil {
-				return err
-			}
-			request, err := http.NewRequest(http.MethodPost, webhook.Body.URL, bytes.NewBuffer(payload))
-			if err != nil {
-				return err
-			}
-			_, err = client.Do(request)
-			if err != nil {
-				return errors.New(err.Error())
-			}
+func doWebhookEvents(ctx context.Context, cfg *util.Config, client *http.Client, webhook webhookCheck,
+	countryDB *util.CountryDataset) error {
+
+	thresholds := passedThresholds(webhook)
+	if len(thresholds) == 0 {
+		return nil
+	}
+	countryName := "" // webhooks registered to any country are sent an empty name
+	if webhook.Body.Country != "" {
+		var err error
+		if countryName, err = countryDB.GetFullName(webhook.Body.Country); err != nil {
+			slog.Warn("invocation worker: unknown country of webhook", "webhook", webhook.ID, "error", err)
+		}
+	}
+	endpoint := triggerEndpoint(webhook.Body)
+
+	if webhook.Body.Batch {
+		return postWebhookMessage(ctx, client, webhook, webhookBatchTrigger{
+			WebhookId:  webhook.ID,
+			Country:    countryName,
+			Endpoint:   endpoint,
+			TotalCalls: thresholds[len(thresholds)-1],
+			Thresholds: thresholds,
+		})
+	}
+	for _, threshold := range thresholds {
+		err := postWebhookMessage(ctx, client, webhook, webhookTrigger{
+			WebhookId:  webhook.ID,
+			Country:    countryName,
+			Endpoint:   endpoint,
+			TotalCalls: threshold,
+		})
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+// postWebhookMessage sends the message as json to the url of the webhook.
+// Any response outside the 2xx range is treated as a failed delivery.
+// On success: nil
+// On failure: error
+func postWebhookMessage(ctx context.Context, client *http.Client, webhook webhookCheck, message any) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Body.URL, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("content-type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		metrics.WebhookDeliveries.WithLabelValues(metrics.DeliveryFailure).Inc()
+		return errors.New("delivery to webhook " + webhook.ID + " failed: " + err.Error())
+	}
+	_ = response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		metrics.WebhookDeliveries.WithLabelValues(metrics.DeliveryFailure).Inc()
+		return errors.New("delivery to webhook " + webhook.ID + " failed: " + response.Status)
+	}
+	metrics.WebhookDeliveries.WithLabelValues(metrics.DeliverySuccess).Inc()
+	return nil
+}
+
+// recordDeliveryOutcome queues the updates of a webhook for the outcome of its delivery,
+// see deliveryOutcomeUpdates.
+// On success: nil
+// On failure: error
+func recordDeliveryOutcome(cfg *util.Config, bulkOperation *firestore.BulkWriter, webhook webhookCheck,
+	deliveryErr error) error {
+
+	ref := cfg.FirestoreClient.Collection(cfg.WebhookCollection).Doc(webhook.ID)
+	_, err := bulkOperation.Update(ref, deliveryOutcomeUpdates(webhook, deliveryErr, cfg.WebhookMaxFailures))
+	return err
+}
+
+// deliveryOutcomeUpdates returns the firestore updates recording the outcome of the delivery
+// to a webhook. A successful delivery marks the webhook as delivered up to the count it was
+// claimed at, subtracts the endpoint calls it reported and resets the consecutive failures.
+// A failed delivery leaves the delivered count in place and makes the webhook pending again,
+// so that it is retried, until it reaches maxFailures consecutive failures and is paused.
+func deliveryOutcomeUpdates(webhook webhookCheck, deliveryErr error, maxFailures int32) []firestore.Update {
+	if deliveryErr != nil {
+		failures := webhook.Body.Failures + 1
+		updates := []firestore.Update{
+			{Path: "failures", Value: failures},
+			{Path: "pending", Value: true},
+		}
+		if failures >= maxFailures {
+			slog.Warn("invocation worker: pausing webhook", "webhook", webhook.ID, "failures", failures)
+			updates = append(updates, firestore.Update{Path: "paused", Value: true})
+		}
+		return updates
+	}
+	updates := []firestore.Update{{Path: "delivered_count", Value: webhook.Body.Count}}
+	if webhook.Body.Failures != 0 {
+		updates = append(updates, firestore.Update{Path: "failures", Value: 0})
+	}
+	// sorted for a deterministic order of updates
+	endpoints := make([]string, 0, len(webhook.Body.EndpointCalls))
+	for endpoint := range webhook.Body.EndpointCalls {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		if endpoint == "" || webhook.Body.EndpointCalls[endpoint] == 0 {
+			continue
+		}
+		updates = append(updates, firestore.Update{
+			FieldPath: firestore.FieldPath{"endpoint_calls", endpoint},
+			Value:     firestore.Increment(-webhook.Body.EndpointCalls[endpoint]),
+		})
+	}
+	return updates
+}
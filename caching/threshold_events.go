@@ -0,0 +1,99 @@
+package caching
+
+import (
+	"Assignment2/events"
+	"Assignment2/util"
+	"cloud.google.com/go/firestore"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// eventRetention is how long threshold events are kept in the DB before being deleted by the
+// instance holding the invocation lease.
+const eventRetention = time.Hour
+
+// eventWatchRetryDelay is the delay before watching the event collection again after the
+// watch failed.
+const eventWatchRetryDelay = 5 * time.Second
+
+// thresholdEvent is the document of a threshold event in the DB. Events are written by the
+// instance holding the invocation lease as webhooks are claimed, and published to the
+// subscribers of every service instance watching the event collection.
+type thresholdEvent struct {
+	Country string               `firestore:"country"`
+	Data    events.ThresholdData `firestore:"data"`
+	Created time.Time            `firestore:"created,serverTimestamp"`
+}
+
+// thresholdEventFor returns the threshold event of a triggered webhook, listing the thresholds
+// passed since the last delivery that have not been published yet, and false if there are none.
+// Thresholds are published once however often their delivery is retried.
+func thresholdEventFor(webhook webhookCheck) (thresholdEvent, bool) {
+	if webhook.Body.PublishedCount != nil {
+		webhook.PreviousCount = util.Max(webhook.PreviousCount, *webhook.Body.PublishedCount)
+	}
+	thresholds := passedThresholds(webhook)
+	if len(thresholds) == 0 {
+		return thresholdEvent{}, false
+	}
+	return thresholdEvent{
+		Country: webhook.Body.Country,
+		Data: events.ThresholdData{
+			WebhookId:  webhook.ID,
+			Country:    webhook.Body.Country,
+			Endpoint:   triggerEndpoint(webhook.Body),
+			Calls:      thresholds[len(thresholds)-1],
+			Thresholds: thresholds,
+		},
+	}, true
+}
+
+// WatchThresholdEvents publishes the threshold events written to the event collection to the
+// broker until the context is cancelled, letting subscribers of every service instance receive
+// the events of webhooks claimed by the instance holding the invocation lease. Only events
+// written after the watch started are published.
+func WatchThresholdEvents(ctx context.Context, cfg *util.Config, broker *events.Broker) {
+	since := time.Now()
+	for {
+		since = watchThresholdEvents(ctx, cfg, broker, since)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventWatchRetryDelay):
+		}
+	}
+}
+
+// watchThresholdEvents publishes the threshold events created after since to the broker until
+// the watch fails or the context is cancelled, returning when the latest published event was
+// created.
+func watchThresholdEvents(ctx context.Context, cfg *util.Config, broker *events.Broker, since time.Time) time.Time {
+	query := cfg.FirestoreClient.Collection(cfg.EventCollection).
+		Where("created", ">", since).OrderBy("created", firestore.Asc)
+	snapshots := query.Snapshots(ctx)
+	defer snapshots.Stop()
+	for {
+		snapshot, err := snapshots.Next()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("event watcher: failed to watch threshold events", "error", err)
+			}
+			return since
+		}
+		for _, change := range snapshot.Changes {
+			if change.Kind != firestore.DocumentAdded {
+				continue
+			}
+			event := thresholdEvent{}
+			if err = change.Doc.DataTo(&event); err != nil {
+				slog.Warn("event watcher: skipping malformed event", "event", change.Doc.Ref.ID, "error", err)
+				continue
+			}
+			broker.Publish(events.TypeThreshold, event.Country, event.Data)
+			if event.Created.After(since) {
+				since = event.Created
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package caching
+
+import (
+	"Assignment2/metrics"
+	"sync"
+	"time"
+)
+
+// Names of the workers reporting heartbeats.
+const (
+	WorkerCache      = "cache"
+	WorkerInvocation = "invocation"
+)
+
+// heartbeatTolerance is the number of heartbeat intervals a worker may go without a
+// heartbeat before it is no longer considered alive.
+const heartbeatTolerance = 3
+
+// WorkerStatus reports the liveness of a worker.
+type WorkerStatus struct {
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Alive         bool      `json:"alive"`
+}
+
+// CacheStatus reports the size and age of the local cache held by the cache worker.
+type CacheStatus struct {
+	Entries     int        `json:"entries"`
+	OldestEntry *time.Time `json:"oldest_entry,omitempty"`
+	LastPushed  *time.Time `json:"last_pushed,omitempty"`
+}
+
+// heartbeat holds the time of the last heartbeat of a worker, along with the time
+// by which the next heartbeat is expected.
+type heartbeat struct {
+	last     time.Time
+	deadline time.Time
+}
+
+// workerStatus holds the latest status reported by the workers.
+var workerStatus = struct {
+	mutex      sync.RWMutex
+	heartbeats map[string]heartbeat
+	cache      CacheStatus
+}{heartbeats: make(map[string]heartbeat)}
+
+// recordHeartbeat records that the worker is alive, expecting a new heartbeat within
+// heartbeatTolerance multiples of the interval.
+func recordHeartbeat(worker string, interval time.Duration) {
+	now := time.Now()
+	workerStatus.mutex.Lock()
+	defer workerStatus.mutex.Unlock()
+	workerStatus.heartbeats[worker] = heartbeat{last: now, deadline: now.Add(heartbeatTolerance * interval)}
+}
+
+// GetWorkerStatus returns the status of every worker that has reported a heartbeat.
+func GetWorkerStatus(now time.Time) map[string]WorkerStatus {
+	workerStatus.mutex.RLock()
+	defer workerStatus.mutex.RUnlock()
+	status := make(map[string]WorkerStatus, len(workerStatus.heartbeats))
+	for worker, beat := range workerStatus.heartbeats {
+		status[worker] = WorkerStatus{LastHeartbeat: beat.last, Alive: now.Before(beat.deadline)}
+	}
+	return status
+}
+
+// WorkersAlive returns true if every worker has reported a heartbeat and is still alive.
+func WorkersAlive(now time.Time) bool {
+	status := GetWorkerStatus(now)
+	for _, worker := range []string{WorkerCache, WorkerInvocation} {
+		if !status[worker].Alive {
+			return false
+		}
+	}
+	return true
+}
+
+// recordCacheStatus records the size and age of the local cache, along with the time of the
+// last push to the DB if pushed is true.
+func recordCacheStatus(cache map[string]CacheEntry, pushed bool) {
+	metrics.CacheSize.Set(float64(len(cache)))
+	var oldest *time.Time
+	for _, entry := range cache {
+		if oldest == nil || entry.LastUpdated.Before(*oldest) {
+			lastUpdated := entry.LastUpdated
+			oldest = &lastUpdated
+		}
+	}
+	workerStatus.mutex.Lock()
+	defer workerStatus.mutex.Unlock()
+	workerStatus.cache.Entries = len(cache)
+	workerStatus.cache.OldestEntry = oldest
+	if pushed {
+		now := time.Now()
+		workerStatus.cache.LastPushed = &now
+	}
+}
+
+// GetCacheStatus returns the latest status of the local cache.
+func GetCacheStatus() CacheStatus {
+	workerStatus.mutex.RLock()
+	defer workerStatus.mutex.RUnlock()
+	return workerStatus.cache
+}
@@ -3,74 +3,256 @@ package main
 import (
 	"Assignment2/caching"
 	"Assignment2/consts"
+	"Assignment2/events"
+	"Assignment2/fsutils"
+	"Assignment2/grpcapi"
 	"Assignment2/handlers"
+	"Assignment2/internal/assets"
 	"Assignment2/internal/stubbing"
+	"Assignment2/metrics"
+	"Assignment2/openapi"
+	"Assignment2/ratelimit"
+	"Assignment2/router"
+	"Assignment2/tracing"
 	"Assignment2/util"
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 )
 
-var wg sync.WaitGroup
+// flushTimeout is the time given to the workers, and then to tracing, to flush their state once
+// in-flight requests have drained. Each gets its own deadline, as draining may take up the whole
+// shutdown timeout.
+const flushTimeout = 10 * time.Second
 
 func main() {
-	defer wg.Wait()
-	var countryDataset util.CountryDataset
-	err := countryDataset.Initialize(consts.DataSetPath)
+	config, printConfig, err := util.LoadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatal("service startup: ", err)
 	}
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Println("main: $PORT has been set. Default: " + consts.DefaultPort)
-		port = consts.DefaultPort
+	if printConfig {
+		if err = util.PrintConfig(os.Stdout, &config); err != nil {
+			log.Fatal("service startup: ", err)
+		}
+		return
+	}
+	if err = util.SetUpLogging(&config, os.Stderr); err != nil {
+		log.Fatal("service startup: ", err)
 	}
 
-	config, err := util.SetUpServiceConfig(consts.ConfigPath, consts.CredentialsPath)
+	// Static files are embedded in the binary, optionally replaced by files in the assets dir.
+	files := assets.New(config.AssetsDir)
+	var countryDataset util.CountryDataset
+	if config.DatasetPath != "" {
+		err = countryDataset.Initialize(config.DatasetPath)
+	} else {
+		err = countryDataset.InitializeFS(files, assets.Dataset)
+	}
 	if err != nil {
+		log.Fatal("service startup: ", err)
+	}
+	if _, countries := countryDataset.GetLengthOfDataset(); countries > 0 {
+		metrics.DatasetCountries.Set(float64(countries))
+	}
+
+	if err = util.ConnectFirestore(&config); err != nil {
 		log.Fatal("service startup: unable to utilize firebase: ", err)
 	}
+	shutdownTracing, err := tracing.SetUpTracing(context.Background(), &config, os.Stdout)
+	if err != nil {
+		log.Fatal("service startup: ", err)
+	}
+
+	// Cancelled on SIGINT or SIGTERM, starting the shutdown of the service.
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	// Reloads the config on SIGHUP or change of the config file.
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go util.WatchConfig(signalCtx, &config, os.Args[1:], os.LookupEnv, hangup)
 
 	// Stub server setup
+	var stubGroup sync.WaitGroup
 	stubStop := make(chan struct{})
 	if config.DevelopmentMode {
-		wg.Add(1)
-		go stubbing.RunSTUBServer(&config, &wg, "./internal/assets/", consts.StubPort, stubStop)
+		stubGroup.Add(1)
+		go stubbing.RunSTUBServer(&config, &stubGroup, files, config.StubPort, stubStop)
+	}
+
+	// Publishes webhook triggers and dataset changes to clients of the notification stream.
+	broker := events.NewBroker()
+	if datasetPath := watchedDatasetPath(&config); datasetPath != "" {
+		go util.WatchDataset(signalCtx, &countryDataset, datasetPath, func(countries []string) {
+			if _, length := countryDataset.GetLengthOfDataset(); length > 0 {
+				metrics.DatasetCountries.Set(float64(length))
+			}
+			broker.PublishDataset(&countryDataset, countries)
+		})
 	}
 
 	// Invocation worker setup
-	invocation := make(chan []string, 10)
+	invocation := caching.NewInvocationRecorder(10)
 	invocationStop := make(chan struct{})
 	invocationDone := make(chan struct{})
 	go caching.InvocationWorker(&config, invocationStop, invocationDone, &countryDataset, invocation)
-	defer func() {
-		invocationStop <- struct{}{}
-		<-invocationDone
-	}()
+	// Threshold events are written by the instance delivering webhook events, and published
+	// to the clients of every instance.
+	go caching.WatchThresholdEvents(signalCtx, &config, broker)
+
 	// Cache worker setup
 	requestChannel := make(chan caching.CacheRequest, 10)
 	cacheStop := make(chan struct{})
 	cacheDone := make(chan struct{})
-
 	go caching.RunCacheWorker(&config, requestChannel, cacheStop, cacheDone)
 
-	defer func() {
-		cacheStop <- struct{}{}
-		<-cacheDone
-	}()
-	notificationHandler := handlers.NotificationHandler(&config, &countryDataset)
+	metrics.RegisterChannelDepth("invocation_channel_depth",
+		"Number of invocation reports queued for the invocation worker.",
+		invocation.Queued)
+	metrics.RegisterChannelDepth("cache_request_channel_depth",
+		"Number of requests queued for the cache worker.",
+		func() int { return len(requestChannel) })
+
+	notificationHandler := handlers.NotificationHandler(&config, &countryDataset, broker)
+	graphQLHandler, err := handlers.HandlerGraphQL(&config, requestChannel, &countryDataset, invocation)
+	if err != nil {
+		log.Fatal("service startup: ", err)
+	}
 	serviceStartTime := time.Now()
-	statusHandler := handlers.HandlerStatus(&config, serviceStartTime)
-	http.HandleFunc("/energy/v1/usage", handlers.InfoHandler)
-	http.HandleFunc("/", handlers.InvalidPathHandler)
-	http.HandleFunc(consts.RenewablesPath, handlers.HandlerRenew(requestChannel, &countryDataset, invocation))
-	http.HandleFunc(consts.NotificationPath, notificationHandler)
-	http.HandleFunc(consts.StatusPath, statusHandler)
-	log.Println("main: service listening on port " + port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-	// stub service can now be stopped with: stubStop <- struct{}{}
+	statusHandler := handlers.HandlerStatus(&config, serviceStartTime, &countryDataset)
+	// Clients of the API are rate limited, keeping bursts from backing up the workers.
+	limiter := ratelimit.NewLimiter(&config)
+	api := func(name string) func(http.Handler) http.Handler {
+		return func(handler http.Handler) http.Handler {
+			return instrument(name, limiter.Limit(handler.ServeHTTP))
+		}
+	}
+	routes := router.New()
+	routes.Handle(http.MethodGet, consts.UsagePath, api("usage")(http.HandlerFunc(handlers.InfoHandler(files))))
+	routes.Handle(http.MethodGet, consts.OpenAPIPath, api("docs")(http.HandlerFunc(openapi.SpecHandler)))
+	routes.Handle(http.MethodGet, consts.DocsPath, api("docs")(http.HandlerFunc(openapi.DocsHandler)))
+	routes.Merge(handlers.HandlerRenew(requestChannel, &countryDataset, invocation), api("renewables"))
+	routes.Merge(notificationHandler, api("notifications"))
+	routes.Handle(http.MethodGet, consts.GraphQLPath, api("graphql")(graphQLHandler))
+	routes.Handle(http.MethodPost, consts.GraphQLPath, api("graphql")(graphQLHandler))
+	routes.Handle(http.MethodGet, consts.StatusPath, api("status")(http.HandlerFunc(statusHandler)))
+	routes.Handle(http.MethodGet, consts.MetricsPath, metrics.Handler())
+	routes.HandleFunc(http.MethodGet, consts.HealthPath, handlers.HandlerHealth())
+	routes.HandleFunc(http.MethodGet, consts.ReadyPath, handlers.HandlerReady(&config, &countryDataset))
+	routes.WrapUnmatched(func(handler http.Handler) http.Handler { return instrument("invalid", handler.ServeHTTP) })
+
+	server := &http.Server{Addr: ":" + config.Port, Handler: routes}
+	// Event streams stay open until the broker is closed, so are ended as shutdown starts.
+	server.RegisterOnShutdown(broker.Close)
+	serverErr := make(chan error, 2)
+	go func() {
+		slog.Info("main: service listening", "port", config.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// The gRPC services share the dataset, cache worker and DB with the REST API.
+	grpcServer := grpcapi.NewServer(&config, requestChannel, &countryDataset, invocation)
+	grpcListener, err := net.Listen("tcp", ":"+config.GRPCPort)
+	if err != nil {
+		log.Fatal("service startup: ", err)
+	}
+	go func() {
+		slog.Info("main: grpc services listening", "port", config.GRPCPort)
+		serverErr <- grpcServer.Serve(grpcListener)
+	}()
+
+	select {
+	case <-signalCtx.Done():
+		slog.Info("main: shutdown signal received")
+	case err = <-serverErr:
+		slog.Error("main: service stopped unexpectedly", "error", err)
+	}
+	stopSignals() // a second signal terminates the service immediately
+
+	// Stops accepting connections and drains in-flight requests. Handlers may depend on
+	// the workers, so the workers are stopped only once the server has shut down.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("main: failed to drain in-flight requests", "error", err)
+	}
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		slog.Error("main: failed to drain in-flight calls", "error", shutdownCtx.Err())
+		grpcServer.Stop()
+	}
+
+	// Workers flush invocation counts and cache to the DB before signaling done.
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancelFlush()
+	stopWorker(flushCtx, "invocation", invocationStop, invocationDone)
+	stopWorker(flushCtx, "cache", cacheStop, cacheDone)
+
+	if config.DevelopmentMode {
+		stubStop <- struct{}{}
+		stubGroup.Wait()
+	}
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancelTracing()
+	if err = shutdownTracing(tracingCtx); err != nil {
+		slog.Error("main: failed to flush trace spans", "error", err)
+	}
+	if err = fsutils.Close(&config); err != nil {
+		slog.Error("main: failed to close firestore client", "error", err)
+	}
+	slog.Info("main: service shut down")
+}
+
+// stopWorker signals the worker to stop and waits for it to flush its state, giving up once the
+// context is done so that a slow DB cannot hold up the shutdown.
+func stopWorker(ctx context.Context, name string, stop chan<- struct{}, done <-chan struct{}) {
+	select {
+	case stop <- struct{}{}:
+	case <-ctx.Done():
+		slog.Error("main: worker did not stop in time", "worker", name, "error", ctx.Err())
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Error("main: worker did not flush in time", "worker", name, "error", ctx.Err())
+	}
+}
+
+// watchedDatasetPath returns the path of the dataset file reloaded on change: the dataset path
+// if set, otherwise the dataset of the assets dir if set. The embedded dataset never changes.
+func watchedDatasetPath(cfg *util.Config) string {
+	if cfg.DatasetPath != "" {
+		return cfg.DatasetPath
+	}
+	if cfg.AssetsDir != "" {
+		return filepath.Join(cfg.AssetsDir, assets.Dataset)
+	}
+	return ""
+}
 
+// instrument wraps a handler with a trace span, request IDs for logging and metrics
+// labeled with name.
+func instrument(name string, handler http.HandlerFunc) http.Handler {
+	return tracing.InstrumentHandler(name, metrics.InstrumentHandler(name, util.WithRequestLogging(handler)))
 }
@@ -15,13 +15,21 @@ const ConfigPath = "./config/config.yaml"
 const DataSetPath = "./internal/assets/renewable-share-energy.csv"
 
 const RenewablesPath = "/energy/" + Version + "/renewables/"
+const UsagePath = "/energy/" + Version + "/usage"
+const OpenAPIPath = "/energy/" + Version + "/openapi.json"
+const DocsPath = "/energy/" + Version + "/docs"
 const NotificationPath = "/energy/" + Version + "/notifications/"
+const GraphQLPath = "/energy/" + Version + "/graphql"
 const StatusPath = "/energy/" + Version + "/status/"
+const MetricsPath = "/metrics"
+const HealthPath = "/healthz"
+const ReadyPath = "/readyz"
 const CredentialsPath = "./cmd/sha.json"
 
 // Development
 
 const Version = "v1"
 const DefaultPort = "10000"
+const DefaultGRPCPort = "10001"
 const StubPort = "8888"
 const StubDomain = "http://localhost:" + StubPort
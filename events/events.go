@@ -0,0 +1,185 @@
+// Package events broadcasts live activity of the service, such as webhooks passing their
+// thresholds and reloads of the dataset, to clients subscribed to the notification stream.
+package events
+
+import (
+	"Assignment2/util"
+	"sync"
+)
+
+// Types of events.
+const (
+	TypeThreshold = "threshold" // a webhook has passed one or more multiples of its calls
+	TypeDataset   = "dataset"   // a reload of the dataset changed the values of a country
+)
+
+// historySize is the number of recent events kept for replay to reconnecting subscribers.
+const historySize = 256
+
+// subscriberBuffer is the number of events queued for a subscriber before it is dropped.
+const subscriberBuffer = 64
+
+// Event is a single event, identified by an ID increasing with every event published.
+type Event struct {
+	ID      uint64
+	Type    string
+	Country string // cca3 code of the country, or the empty string for events of any country
+	Data    any    // encoded as json in the stream
+}
+
+// ThresholdData describes the thresholds passed by a webhook as delivered to the webhook, but
+// with the country given by its cca3 code.
+type ThresholdData struct {
+	WebhookId  string  `json:"webhook_id"`
+	Country    string  `json:"country"`
+	Endpoint   string  `json:"endpoint"`
+	Calls      int32   `json:"calls"`
+	Thresholds []int32 `json:"thresholds"`
+}
+
+// DatasetData describes the values of a country after a reload of the dataset. Removed is set
+// if the country is no longer in the dataset, leaving the other fields empty.
+type DatasetData struct {
+	Name       string  `json:"name,omitempty"`
+	Isocode    string  `json:"isocode"`
+	Year       int     `json:"year,omitempty"`
+	Percentage float64 `json:"percentage,omitempty"`
+	Average    float64 `json:"average,omitempty"`
+	Removed    bool    `json:"removed,omitempty"`
+}
+
+// subscription is a subscriber to the events of a country, or of every country if empty.
+type subscription struct {
+	country string
+	events  chan Event
+}
+
+// Broker publishes events to every matching subscriber without blocking the publisher.
+// Subscribers falling behind are dropped rather than slowing down the service, and may
+// resume from the last event they received while it is kept in the history.
+type Broker struct {
+	mutex       sync.Mutex
+	lastID      uint64
+	history     []Event
+	subscribers map[*subscription]struct{}
+	closed      bool
+}
+
+// NewBroker returns a broker without any subscribers.
+func NewBroker() *Broker {
+	return &Broker{
+		history:     make([]Event, 0, historySize),
+		subscribers: make(map[*subscription]struct{}),
+	}
+}
+
+// Publish sends an event of the type and country to every matching subscriber.
+func (b *Broker) Publish(eventType string, country string, data any) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return
+	}
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, Country: country, Data: data}
+	if len(b.history) == historySize {
+		b.history = append(b.history[:0], b.history[1:]...)
+	}
+	b.history = append(b.history, event)
+	for subscriber := range b.subscribers {
+		if !subscriber.matches(event) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			b.drop(subscriber)
+		}
+	}
+}
+
+// PublishDataset publishes an event with the values of each of the countries after a reload
+// of the dataset.
+func (b *Broker) PublishDataset(dataset *util.CountryDataset, countries []string) {
+	for _, cca3 := range countries {
+		statistic, err := dataset.GetStatistic(cca3)
+		if err != nil {
+			b.Publish(TypeDataset, cca3, DatasetData{Isocode: cca3, Removed: true})
+			continue
+		}
+		_, average := dataset.GetAverage(cca3)
+		b.Publish(TypeDataset, cca3, DatasetData{
+			Name:       statistic.Name,
+			Isocode:    cca3,
+			Year:       statistic.Year,
+			Percentage: statistic.Percentage,
+			Average:    average,
+		})
+	}
+}
+
+// Subscribe returns a channel receiving the events of the country, or of every country if
+// empty. Events kept in the history with an ID above lastID are replayed first, letting
+// clients resume a stream. The channel is closed once the subscription is cancelled, the
+// subscriber falls behind or the broker is closed.
+func (b *Broker) Subscribe(country string, lastID uint64) (<-chan Event, func()) {
+	subscriber := &subscription{country: country}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	replayed := make([]Event, 0)
+	for _, event := range b.history {
+		if lastID != 0 && event.ID > lastID && subscriber.matches(event) {
+			replayed = append(replayed, event)
+		}
+	}
+	subscriber.events = make(chan Event, len(replayed)+subscriberBuffer)
+	for _, event := range replayed {
+		subscriber.events <- event
+	}
+	if b.closed {
+		close(subscriber.events)
+		return subscriber.events, func() {}
+	}
+	b.subscribers[subscriber] = struct{}{}
+	cancel := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		b.drop(subscriber)
+	}
+	return subscriber.events, cancel
+}
+
+// Subscribers returns the number of current subscribers.
+func (b *Broker) Subscribers() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subscribers)
+}
+
+// Close drops every subscriber, ending their streams, and discards any later events. Used
+// on shutdown, as the server waits for open streams to end.
+func (b *Broker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for subscriber := range b.subscribers {
+		b.drop(subscriber)
+	}
+}
+
+// drop removes the subscriber, closing its channel. The mutex must be held.
+func (b *Broker) drop(subscriber *subscription) {
+	if _, ok := b.subscribers[subscriber]; ok {
+		delete(b.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// matches returns true if the subscriber is subscribed to the event. Events of any country
+// are only sent to subscribers of every country.
+func (s *subscription) matches(event Event) bool {
+	return s.country == "" || s.country == event.Country
+}
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"Assignment2/consts"
+	"Assignment2/util"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// received returns the events queued on the channel, without blocking.
+func received(stream <-chan Event) []Event {
+	events := make([]Event, 0)
+	for {
+		select {
+		case event, ok := <-stream:
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker()
+	all, cancelAll := broker.Subscribe("", 0)
+	norway, cancelNorway := broker.Subscribe("NOR", 0)
+	assert.Equal(t, 2, broker.Subscribers())
+
+	broker.Publish(TypeThreshold, "NOR", ThresholdData{Country: "NOR", Calls: 5})
+	broker.Publish(TypeThreshold, "SWE", ThresholdData{Country: "SWE", Calls: 5})
+	broker.Publish(TypeThreshold, "", ThresholdData{Calls: 10})
+
+	// Events of any country are only sent to subscribers of every country.
+	assert.Len(t, received(all), 3)
+	events := received(norway)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, uint64(1), events[0].ID)
+		assert.Equal(t, TypeThreshold, events[0].Type)
+	}
+
+	// Resuming from an event replays the later events of the country.
+	cancelNorway()
+	broker.Publish(TypeDataset, "NOR", DatasetData{Isocode: "NOR"})
+	resumed, cancelResumed := broker.Subscribe("NOR", 1)
+	events = received(resumed)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, uint64(4), events[0].ID)
+	}
+	cancelResumed()
+	cancelResumed() // cancelling twice is harmless
+
+	// Subscribers falling behind are dropped, closing their channel.
+	for i := 0; i <= subscriberBuffer; i++ {
+		broker.Publish(TypeDataset, "SWE", DatasetData{Isocode: "SWE"})
+	}
+	assert.Len(t, received(all), subscriberBuffer)
+	_, ok := <-all
+	assert.False(t, ok)
+	cancelAll()
+	assert.Equal(t, 0, broker.Subscribers())
+}
+
+func TestBrokerHistory(t *testing.T) {
+	broker := NewBroker()
+	for i := 0; i < historySize+10; i++ {
+		broker.Publish(TypeDataset, "NOR", DatasetData{Isocode: "NOR"})
+	}
+	// Only the kept events are replayed.
+	stream, cancel := broker.Subscribe("", 1)
+	defer cancel()
+	events := received(stream)
+	if assert.Len(t, events, historySize) {
+		assert.Equal(t, uint64(11), events[0].ID)
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	broker := NewBroker()
+	stream, cancel := broker.Subscribe("", 0)
+	defer cancel()
+	broker.Close()
+	_, ok := <-stream
+	assert.False(t, ok)
+
+	// Subscribing after close ends the stream at once, and later events are discarded.
+	broker.Publish(TypeDataset, "NOR", DatasetData{Isocode: "NOR"})
+	late, cancelLate := broker.Subscribe("", 0)
+	defer cancelLate()
+	_, ok = <-late
+	assert.False(t, ok)
+
+	var missing *Broker
+	missing.Publish(TypeDataset, "NOR", nil) // brokers are optional for publishers
+}
+
+func TestPublishDataset(t *testing.T) {
+	var dataset util.CountryDataset
+	if err := dataset.Initialize("." + consts.DataSetPath); err != nil {
+		t.Fatal(err)
+	}
+	broker := NewBroker()
+	stream, cancel := broker.Subscribe("", 0)
+	defer cancel()
+	broker.PublishDataset(&dataset, []string{"NOR", "XYZ"})
+	events := received(stream)
+	if assert.Len(t, events, 2) {
+		norway := events[0].Data.(DatasetData)
+		assert.Equal(t, "Norway", norway.Name)
+		assert.Equal(t, 2021, norway.Year)
+		assert.False(t, norway.Removed)
+		assert.Equal(t, DatasetData{Isocode: "XYZ", Removed: true}, events[1].Data)
+	}
+}
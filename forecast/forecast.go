@@ -0,0 +1,379 @@
+// Package forecast projects the share of renewables of a country from its yearly shares,
+// fitting simple models in-process: a linear trend, Holt's exponential smoothing and a
+// logistic curve bounded by 0 and 100 percent.
+package forecast
+
+import (
+	"Assignment2/util"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Models fitted to the observations. Auto selects the model predicting the latest years best.
+const (
+	ModelAuto     = "auto"
+	ModelLinear   = "linear"
+	ModelHolt     = "holt"
+	ModelLogistic = "logistic"
+)
+
+// Confidence is the coverage of the intervals around projections.
+const Confidence = 0.95
+
+// zConfidence is the quantile of the standard normal distribution for Confidence, as
+// intervals are approximated by a normal distribution of the errors.
+const zConfidence = 1.959963984540054
+
+// MinimumObservations is the fewest yearly shares a model can be fitted to.
+const MinimumObservations = 5
+
+// Bounds of shares, in percent.
+const (
+	minimumShare = 0.0
+	maximumShare = 100.0
+)
+
+// logisticMargin keeps shares of 0 and 100 percent off the asymptotes of the logistic curve.
+const logisticMargin = 0.1
+
+// smoothingSteps is the number of steps of the grid searched for each smoothing parameter of
+// Holt's method, from 0 to 1 exclusive.
+const smoothingSteps = 20
+
+// holdoutFraction and maximumHoldout bound the number of latest years held out when the
+// model is selected automatically.
+const (
+	holdoutFraction = 5
+	maximumHoldout  = 5
+)
+
+// ErrInsufficientData is returned for fewer than MinimumObservations observations.
+var ErrInsufficientData = errors.New("at least " + strconv.Itoa(MinimumObservations) +
+	" years of data are needed for a forecast")
+
+// ErrUnknownModel is returned for models other than those listed above.
+var ErrUnknownModel = errors.New("model must be auto, linear, holt or logistic")
+
+// ValidateModel returns ErrUnknownModel if model is not one of the models listed above.
+func ValidateModel(model string) error {
+	if model != ModelAuto && model != ModelLinear && model != ModelHolt && model != ModelLogistic {
+		return ErrUnknownModel
+	}
+	return nil
+}
+
+// Observation is the share of renewables of a year.
+type Observation struct {
+	Year       int
+	Percentage float64
+}
+
+// Projection is the projected share of a year, along with the bounds of its confidence interval.
+type Projection struct {
+	Year       int     `json:"year"`
+	Percentage float64 `json:"percentage"`
+	Lower      float64 `json:"lower"`
+	Upper      float64 `json:"upper"`
+}
+
+// Forecast is the projection of the years following the observations by the fitted model.
+// RMSE is the root mean square error of the model over the observations, in percentage points.
+type Forecast struct {
+	Model       string
+	RMSE        float64
+	Projections []Projection
+}
+
+// fitted is a model fitted to observations, projecting years ahead of the latest observation.
+type fitted interface {
+	// project returns the share projected steps years after the latest observation, along with
+	// the bounds of its confidence interval.
+	project(steps int) (float64, float64, float64)
+	// rmse returns the root mean square error of the fit over the observations.
+	rmse() float64
+}
+
+// Project fits the model to the observations and projects the share of each of the horizon
+// years following the latest observation. Projections and intervals are kept within 0 and 100
+// percent.
+//
+// On success: the forecast, nil
+// On failure: empty forecast, ErrInsufficientData or ErrUnknownModel
+func Project(observations []Observation, model string, horizon int) (Forecast, error) {
+	if err := ValidateModel(model); err != nil {
+		return Forecast{}, err
+	}
+	observations = sorted(observations)
+	if len(observations) < MinimumObservations {
+		return Forecast{}, ErrInsufficientData
+	}
+	if model == ModelAuto {
+		model = selectModel(observations)
+	}
+	fit, err := fitModel(observations, model)
+	if err != nil {
+		return Forecast{}, err
+	}
+	last := observations[len(observations)-1].Year
+	projections := make([]Projection, 0, horizon)
+	for steps := 1; steps <= horizon; steps++ {
+		percentage, lower, upper := fit.project(steps)
+		projections = append(projections, Projection{
+			Year:       last + steps,
+			Percentage: clamp(percentage),
+			Lower:      clamp(lower),
+			Upper:      clamp(upper),
+		})
+	}
+	return Forecast{Model: model, RMSE: fit.rmse(), Projections: projections}, nil
+}
+
+// fitModel fits the named model to the observations, ordered by year.
+func fitModel(observations []Observation, model string) (fitted, error) {
+	switch model {
+	case ModelLinear:
+		return fitLinear(observations), nil
+	case ModelHolt:
+		return fitHolt(observations), nil
+	case ModelLogistic:
+		return fitLogistic(observations), nil
+	}
+	return nil, ErrUnknownModel
+}
+
+// selectModel returns the model best projecting the latest years when fitted to the earlier
+// years, preferring the simpler model on ties. The linear model is used if too few years
+// remain once the latest years are held out.
+func selectModel(observations []Observation) string {
+	holdout := util.Max(1, util.Min(len(observations)/holdoutFraction, maximumHoldout))
+	training := observations[:len(observations)-holdout]
+	if len(training) < MinimumObservations {
+		return ModelLinear
+	}
+	best, bestError := ModelLinear, math.Inf(1)
+	for _, model := range []string{ModelLinear, ModelHolt, ModelLogistic} {
+		fit, _ := fitModel(training, model)
+		last := training[len(training)-1].Year
+		squared := 0.0
+		for _, observation := range observations[len(training):] {
+			percentage, _, _ := fit.project(observation.Year - last)
+			squared += math.Pow(clamp(percentage)-observation.Percentage, 2)
+		}
+		if squared < bestError {
+			best, bestError = model, squared
+		}
+	}
+	return best
+}
+
+// linearFit is an ordinary least squares fit of the shares to the year.
+// Years are counted from the latest observation.
+type linearFit struct {
+	intercept     float64
+	slope         float64
+	meanX         float64 // mean of the years
+	sumSquaresX   float64 // sum of squared deviations of the years from their mean
+	residualError float64 // standard error of the residuals
+	n             int
+	fitError      float64 // root mean square error over the observations
+}
+
+// fitLinear fits a linear trend to the observations.
+func fitLinear(observations []Observation) *linearFit {
+	fit := regress(observations, func(percentage float64) float64 { return percentage })
+	fit.fitError = fitRMSE(observations, func(i int) float64 {
+		return fit.intercept + fit.slope*float64(observations[i].Year-observations[len(observations)-1].Year)
+	})
+	return fit
+}
+
+func (f *linearFit) project(steps int) (float64, float64, float64) {
+	value, margin := f.predict(float64(steps))
+	return value, value - margin, value + margin
+}
+
+func (f *linearFit) rmse() float64 {
+	return f.fitError
+}
+
+// predict returns the regressed value at x, along with the margin of its prediction interval.
+func (f *linearFit) predict(x float64) (float64, float64) {
+	value := f.intercept + f.slope*x
+	margin := zConfidence * f.residualError *
+		math.Sqrt(1+1/float64(f.n)+math.Pow(x-f.meanX, 2)/f.sumSquaresX)
+	return value, margin
+}
+
+// regress fits a line to the transformed shares by ordinary least squares, with years counted
+// from the latest observation.
+func regress(observations []Observation, transform func(float64) float64) *linearFit {
+	last := observations[len(observations)-1].Year
+	n := float64(len(observations))
+	meanX, meanY := 0.0, 0.0
+	for _, observation := range observations {
+		meanX += float64(observation.Year-last) / n
+		meanY += transform(observation.Percentage) / n
+	}
+	sumSquaresX, sumProducts := 0.0, 0.0
+	for _, observation := range observations {
+		dx := float64(observation.Year-last) - meanX
+		sumSquaresX += dx * dx
+		sumProducts += dx * (transform(observation.Percentage) - meanY)
+	}
+	slope := sumProducts / sumSquaresX
+	intercept := meanY - slope*meanX
+	squaredResiduals := 0.0
+	for _, observation := range observations {
+		residual := transform(observation.Percentage) - (intercept + slope*float64(observation.Year-last))
+		squaredResiduals += residual * residual
+	}
+	return &linearFit{
+		intercept:     intercept,
+		slope:         slope,
+		meanX:         meanX,
+		sumSquaresX:   sumSquaresX,
+		residualError: math.Sqrt(squaredResiduals / (n - 2)),
+		n:             len(observations),
+	}
+}
+
+// logisticFit is a logistic curve fitted as a line to the log-odds of the shares, keeping
+// projections and their intervals within 0 and 100 percent.
+type logisticFit struct {
+	logOdds  *linearFit
+	fitError float64
+}
+
+// fitLogistic fits a logistic curve to the observations.
+func fitLogistic(observations []Observation) *logisticFit {
+	fit := &logisticFit{logOdds: regress(observations, logit)}
+	last := observations[len(observations)-1].Year
+	fit.fitError = fitRMSE(observations, func(i int) float64 {
+		return logistic(fit.logOdds.intercept + fit.logOdds.slope*float64(observations[i].Year-last))
+	})
+	return fit
+}
+
+func (f *logisticFit) project(steps int) (float64, float64, float64) {
+	value, margin := f.logOdds.predict(float64(steps))
+	return logistic(value), logistic(value - margin), logistic(value + margin)
+}
+
+func (f *logisticFit) rmse() float64 {
+	return f.fitError
+}
+
+// holtFit is Holt's linear exponential smoothing, with the smoothing parameters minimising the
+// one-step-ahead errors over the observations. Missing years are interpolated.
+type holtFit struct {
+	level, trend  float64
+	alpha, beta   float64
+	residualError float64 // standard deviation of the one-step-ahead errors
+}
+
+// fitHolt fits Holt's method to the observations.
+func fitHolt(observations []Observation) *holtFit {
+	series := interpolate(observations)
+	best := &holtFit{residualError: math.Inf(1)}
+	for i := 1; i < smoothingSteps; i++ {
+		for j := 1; j < smoothingSteps; j++ {
+			fit := smooth(series, float64(i)/smoothingSteps, float64(j)/smoothingSteps)
+			if fit.residualError < best.residualError {
+				best = fit
+			}
+		}
+	}
+	return best
+}
+
+// smooth applies Holt's method to the series with the smoothing parameters.
+func smooth(series []float64, alpha float64, beta float64) *holtFit {
+	level, trend := series[0], series[1]-series[0]
+	squaredErrors := 0.0
+	for _, value := range series[1:] {
+		forecast := level + trend
+		squaredErrors += math.Pow(value-forecast, 2)
+		previous := level
+		level = alpha*value + (1-alpha)*forecast
+		trend = beta*(level-previous) + (1-beta)*trend
+	}
+	return &holtFit{
+		level:         level,
+		trend:         trend,
+		alpha:         alpha,
+		beta:          beta,
+		residualError: math.Sqrt(squaredErrors / float64(len(series)-1)),
+	}
+}
+
+func (f *holtFit) project(steps int) (float64, float64, float64) {
+	value := f.level + float64(steps)*f.trend
+	// variance of Holt's forecasts grows with the smoothing of each step ahead
+	variance := 1.0
+	for j := 1; j < steps; j++ {
+		variance += math.Pow(f.alpha*(1+float64(j)*f.beta), 2)
+	}
+	margin := zConfidence * f.residualError * math.Sqrt(variance)
+	return value, value - margin, value + margin
+}
+
+func (f *holtFit) rmse() float64 {
+	return f.residualError
+}
+
+// interpolate returns the shares of every year from the first to the latest observation,
+// interpolating missing years linearly.
+func interpolate(observations []Observation) []float64 {
+	first := observations[0].Year
+	series := make([]float64, observations[len(observations)-1].Year-first+1)
+	for i := 1; i < len(observations); i++ {
+		from, to := observations[i-1], observations[i]
+		span := float64(to.Year - from.Year)
+		for year := from.Year; year < to.Year; year++ {
+			series[year-first] = from.Percentage + (to.Percentage-from.Percentage)*float64(year-from.Year)/span
+		}
+	}
+	series[len(series)-1] = observations[len(observations)-1].Percentage
+	return series
+}
+
+// fitRMSE returns the root mean square error of the fitted values of the observations.
+func fitRMSE(observations []Observation, fittedValue func(int) float64) float64 {
+	squared := 0.0
+	for i, observation := range observations {
+		squared += math.Pow(fittedValue(i)-observation.Percentage, 2)
+	}
+	return math.Sqrt(squared / float64(len(observations)))
+}
+
+// sorted returns a copy of the observations ordered by year, keeping the first of any
+// duplicate years.
+func sorted(observations []Observation) []Observation {
+	ordered := append([]Observation(nil), observations...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Year < ordered[j].Year })
+	unique := ordered[:0]
+	for _, observation := range ordered {
+		if len(unique) == 0 || unique[len(unique)-1].Year != observation.Year {
+			unique = append(unique, observation)
+		}
+	}
+	return unique
+}
+
+// logit returns the log-odds of the share, kept off the asymptotes of the logistic curve.
+func logit(percentage float64) float64 {
+	share := math.Min(math.Max(percentage, minimumShare+logisticMargin), maximumShare-logisticMargin)
+	return math.Log(share / (maximumShare - share))
+}
+
+// logistic returns the share with the log-odds, the inverse of logit.
+func logistic(logOdds float64) float64 {
+	return maximumShare / (1 + math.Exp(-logOdds))
+}
+
+// clamp keeps the share within 0 and 100 percent.
+func clamp(percentage float64) float64 {
+	return math.Min(math.Max(percentage, minimumShare), maximumShare)
+}
@@ -0,0 +1,91 @@
+package forecast
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+// series returns observations of the years from first, with shares given by share.
+func series(first int, years int, share func(int) float64) []Observation {
+	observations := make([]Observation, 0, years)
+	for i := 0; i < years; i++ {
+		observations = append(observations, Observation{Year: first + i, Percentage: share(i)})
+	}
+	return observations
+}
+
+func TestProject_Linear(t *testing.T) {
+	// noise keeps the residuals, and so the intervals, from vanishing
+	observations := series(2000, 20, func(i int) float64 { return 10 + 2*float64(i) + 0.5*math.Sin(float64(i)) })
+	forecast, err := Project(observations, ModelLinear, 5)
+	if !assert.Nil(t, err) || !assert.Len(t, forecast.Projections, 5) {
+		return
+	}
+	assert.Equal(t, ModelLinear, forecast.Model)
+	assert.Less(t, forecast.RMSE, 1.0)
+	for i, projection := range forecast.Projections {
+		assert.Equal(t, 2020+i, projection.Year)
+		assert.InDelta(t, 50+2*float64(i), projection.Percentage, 1)
+		assert.Less(t, projection.Lower, projection.Percentage)
+		assert.Greater(t, projection.Upper, projection.Percentage)
+	}
+	// intervals widen further ahead
+	first, last := forecast.Projections[0], forecast.Projections[4]
+	assert.Greater(t, last.Upper-last.Lower, first.Upper-first.Lower)
+}
+
+func TestProject_Bounds(t *testing.T) {
+	observations := series(2000, 10, func(i int) float64 { return 60 + 4*float64(i) })
+	linear, err := Project(observations, ModelLinear, 20)
+	assert.Nil(t, err)
+	logistic, err := Project(observations, ModelLogistic, 20)
+	assert.Nil(t, err)
+	for i := range linear.Projections {
+		assert.LessOrEqual(t, linear.Projections[i].Upper, maximumShare)
+		assert.Less(t, logistic.Projections[i].Percentage, maximumShare)
+		assert.GreaterOrEqual(t, logistic.Projections[i].Lower, minimumShare)
+	}
+	// the linear trend is clamped at 100 percent, while the logistic curve levels off below it
+	assert.Equal(t, maximumShare, linear.Projections[19].Percentage)
+	assert.Greater(t, logistic.Projections[19].Percentage, logistic.Projections[0].Percentage)
+}
+
+func TestProject_Holt(t *testing.T) {
+	// missing years are interpolated
+	observations := []Observation{{2000, 10}, {2001, 12}, {2003, 16}, {2004, 18}, {2005, 20}, {2006, 22}}
+	forecast, err := Project(observations, ModelHolt, 3)
+	if !assert.Nil(t, err) || !assert.Len(t, forecast.Projections, 3) {
+		return
+	}
+	assert.Equal(t, 2007, forecast.Projections[0].Year)
+	assert.InDelta(t, 24, forecast.Projections[0].Percentage, 0.5)
+	assert.InDelta(t, 28, forecast.Projections[2].Percentage, 1)
+}
+
+func TestProject_Auto(t *testing.T) {
+	// shares rising steadily towards 100 percent fit the logistic curve better than a line
+	observations := series(1990, 30, func(i int) float64 { return logistic(-3 + 0.25*float64(i)) })
+	forecast, err := Project(observations, ModelAuto, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, ModelLogistic, forecast.Model)
+
+	// too few years to hold out any fall back to the linear model
+	forecast, err = Project(series(2000, MinimumObservations, func(i int) float64 { return float64(i) }), ModelAuto, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, ModelLinear, forecast.Model)
+}
+
+func TestProject_Errors(t *testing.T) {
+	// duplicate years are only counted once
+	observations := series(2000, MinimumObservations-1, func(i int) float64 { return float64(i) })
+	observations = append(observations, observations[0])
+	_, err := Project(observations, ModelLinear, 1)
+	assert.ErrorIs(t, err, ErrInsufficientData)
+
+	_, err = Project(series(2000, MinimumObservations, func(i int) float64 { return float64(i) }), "arima", 1)
+	assert.ErrorIs(t, err, ErrUnknownModel)
+	// unknown models are reported before missing data
+	_, err = Project(observations, "arima", 1)
+	assert.ErrorIs(t, err, ErrUnknownModel)
+}
@@ -5,13 +5,18 @@ package fsutils
 import (
 	"Assignment2/util"
 	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
 	"context"
+	"errors"
 	"firebase.google.com/go"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
-	"log"
+	"log/slog"
 )
 
+// countAlias is the alias of the count in aggregation queries.
+const countAlias = "count"
+
 // NewFirestoreContext initializes a new context and a Firestore cLient in a Config struct
 func NewFirestoreContext(config *util.Config, configFilePath string) error {
 
@@ -24,18 +29,35 @@ func NewFirestoreContext(config *util.Config, configFilePath string) error {
 	serviceAccount := option.WithCredentialsFile(configFilePath)
 	app, err := firebase.NewApp(*config.Ctx, nil, serviceAccount)
 	if err != nil {
-		log.Println("unable to create new caching app")
+		slog.Error("fsutils: unable to create new firebase app", "error", err)
 		return err
 	}
 	// Instantiate caching client
 	config.FirestoreClient, err = app.Firestore(*config.Ctx)
 	if err != nil {
-		log.Println("unable to instantiate firestore client")
+		slog.Error("fsutils: unable to instantiate firestore client", "error", err)
 		return err
 	}
 	return nil
 }
 
+// CountQuery counts the documents matched by the query through an aggregation query,
+// without reading the documents themselves.
+//
+// On success: count, nil
+// On failure: 0, error
+func CountQuery(ctx context.Context, query firestore.Query) (int64, error) {
+	result, err := query.NewAggregationQuery().WithCount(countAlias).Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := result[countAlias].(*firestorepb.Value)
+	if !ok {
+		return 0, errors.New("count query: unexpected result")
+	}
+	return count.GetIntegerValue(), nil
+}
+
 // Close closes the Firestore client
 func Close(config *util.Config) error {
 	return config.FirestoreClient.Close()
@@ -65,6 +87,13 @@ func DeleteDocument(config *util.Config, collection, id string) error {
 	return err
 }
 
+// UpdateDocument applies the updates to the fields of a document with a specific id.
+// Fails with codes.NotFound if the document doesn't exist.
+func UpdateDocument(config *util.Config, collection, id string, updates []firestore.Update) error {
+	_, err := config.FirestoreClient.Collection(collection).Doc(id).Update(*config.Ctx, updates)
+	return err
+}
+
 // ReadDocument reads a specific document by id.
 func ReadDocument(config *util.Config, collection, id string) (map[string]interface{}, error) {
 	documentSnap, err := config.FirestoreClient.Collection(collection).Doc(id).Get(*config.Ctx)
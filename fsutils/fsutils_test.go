@@ -2,6 +2,7 @@ package fsutils
 
 import (
 	"Assignment2/util"
+	"cloud.google.com/go/firestore"
 	"fmt"
 	"reflect"
 	"testing"
@@ -240,6 +241,50 @@ func TestReadDocumentGeneral(t *testing.T) {
 	}
 }
 
+// TestUpdateDocument creates a new document, updates one of its fields and reads it back.
+// Updating a non-existing document should fail.
+func TestUpdateDocument(t *testing.T) {
+	var config util.Config
+	err := NewFirestoreContext(&config, serviceAccountPath)
+	defer func() {
+		err := Close(&config)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	if err != nil {
+		t.Error("could not initialize")
+	}
+
+	testData := MockData{First: "testing", Third: 0.456}
+	newDoc, err := AddDocument(&config, testCollection, testData)
+	if err != nil {
+		t.Error("unable to create new document")
+	}
+	err = UpdateDocument(&config, testCollection, newDoc, []firestore.Update{{Path: "first", Value: "updated"}})
+	if err != nil {
+		t.Error("unable to update document")
+	}
+	testReadData := MockData{}
+	err = ReadDocumentGeneral(&config, testCollection, newDoc, &testReadData)
+	if err != nil {
+		t.Error("unable to read document")
+	}
+	if testReadData.First != "updated" || testReadData.Third != testData.Third {
+		t.Error("document not updated as expected")
+	}
+
+	err = UpdateDocument(&config, testCollection, "invalid_name", []firestore.Update{{Path: "first", Value: "x"}})
+	if err == nil {
+		t.Error("updating non-existing document returned no error")
+	}
+
+	err = DeleteDocument(&config, testCollection, newDoc)
+	if err != nil {
+		t.Error("could not delete document")
+	}
+}
+
 // TestReadDocumentNonexisting tries to read a document with invalid id
 func TestReadDocumentNonexisting(t *testing.T) {
 	var config util.Config
@@ -0,0 +1,491 @@
+// Services of the renewable energy API for internal consumers, mirroring the renewables and
+// notification endpoints of the REST API.
+//
+// The Go code in this directory is generated from this file by protoc-gen-go and
+// protoc-gen-go-grpc, see the generate directive in grpcapi/grpcapi.go.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: energy.proto
+
+package energypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Renewables_Current_FullMethodName = "/energy.v1.Renewables/Current"
+	Renewables_History_FullMethodName = "/energy.v1.Renewables/History"
+	Renewables_Compare_FullMethodName = "/energy.v1.Renewables/Compare"
+)
+
+// RenewablesClient is the client API for Renewables service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RenewablesClient interface {
+	// Current returns the share of renewables in the latest year of the dataset, for every
+	// country or for one country, optionally followed by its neighbours.
+	Current(ctx context.Context, in *CurrentRequest, opts ...grpc.CallOption) (*StatisticsList, error)
+	// History returns the yearly shares of renewables of one country, or the mean share of
+	// every country, from begin to end if given. Years missing in the dataset are reported
+	// as gaps, and left out or filled as set by fill.
+	History(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (*StatisticsList, error)
+	// Compare ranks countries by their mean share of renewables from begin to end if given,
+	// with missing years left out or filled as set by fill.
+	Compare(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*Comparison, error)
+}
+
+type renewablesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRenewablesClient(cc grpc.ClientConnInterface) RenewablesClient {
+	return &renewablesClient{cc}
+}
+
+func (c *renewablesClient) Current(ctx context.Context, in *CurrentRequest, opts ...grpc.CallOption) (*StatisticsList, error) {
+	out := new(StatisticsList)
+	err := c.cc.Invoke(ctx, Renewables_Current_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *renewablesClient) History(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (*StatisticsList, error) {
+	out := new(StatisticsList)
+	err := c.cc.Invoke(ctx, Renewables_History_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *renewablesClient) Compare(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*Comparison, error) {
+	out := new(Comparison)
+	err := c.cc.Invoke(ctx, Renewables_Compare_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RenewablesServer is the server API for Renewables service.
+// All implementations must embed UnimplementedRenewablesServer
+// for forward compatibility
+type RenewablesServer interface {
+	// Current returns the share of renewables in the latest year of the dataset, for every
+	// country or for one country, optionally followed by its neighbours.
+	Current(context.Context, *CurrentRequest) (*StatisticsList, error)
+	// History returns the yearly shares of renewables of one country, or the mean share of
+	// every country, from begin to end if given. Years missing in the dataset are reported
+	// as gaps, and left out or filled as set by fill.
+	History(context.Context, *HistoryRequest) (*StatisticsList, error)
+	// Compare ranks countries by their mean share of renewables from begin to end if given,
+	// with missing years left out or filled as set by fill.
+	Compare(context.Context, *CompareRequest) (*Comparison, error)
+	mustEmbedUnimplementedRenewablesServer()
+}
+
+// UnimplementedRenewablesServer must be embedded to have forward compatible implementations.
+type UnimplementedRenewablesServer struct {
+}
+
+func (UnimplementedRenewablesServer) Current(context.Context, *CurrentRequest) (*StatisticsList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Current not implemented")
+}
+func (UnimplementedRenewablesServer) History(context.Context, *HistoryRequest) (*StatisticsList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method History not implemented")
+}
+func (UnimplementedRenewablesServer) Compare(context.Context, *CompareRequest) (*Comparison, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Compare not implemented")
+}
+func (UnimplementedRenewablesServer) mustEmbedUnimplementedRenewablesServer() {}
+
+// UnsafeRenewablesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RenewablesServer will
+// result in compilation errors.
+type UnsafeRenewablesServer interface {
+	mustEmbedUnimplementedRenewablesServer()
+}
+
+func RegisterRenewablesServer(s grpc.ServiceRegistrar, srv RenewablesServer) {
+	s.RegisterService(&Renewables_ServiceDesc, srv)
+}
+
+func _Renewables_Current_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CurrentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RenewablesServer).Current(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Renewables_Current_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RenewablesServer).Current(ctx, req.(*CurrentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Renewables_History_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RenewablesServer).History(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Renewables_History_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RenewablesServer).History(ctx, req.(*HistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Renewables_Compare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RenewablesServer).Compare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Renewables_Compare_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RenewablesServer).Compare(ctx, req.(*CompareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Renewables_ServiceDesc is the grpc.ServiceDesc for Renewables service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Renewables_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "energy.v1.Renewables",
+	HandlerType: (*RenewablesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Current",
+			Handler:    _Renewables_Current_Handler,
+		},
+		{
+			MethodName: "History",
+			Handler:    _Renewables_History_Handler,
+		},
+		{
+			MethodName: "Compare",
+			Handler:    _Renewables_Compare_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "energy.proto",
+}
+
+const (
+	Notifications_Register_FullMethodName = "/energy.v1.Notifications/Register"
+	Notifications_List_FullMethodName     = "/energy.v1.Notifications/List"
+	Notifications_Get_FullMethodName      = "/energy.v1.Notifications/Get"
+	Notifications_Delete_FullMethodName   = "/energy.v1.Notifications/Delete"
+	Notifications_Pause_FullMethodName    = "/energy.v1.Notifications/Pause"
+	Notifications_Resume_FullMethodName   = "/energy.v1.Notifications/Resume"
+)
+
+// NotificationsClient is the client API for Notifications service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type NotificationsClient interface {
+	// Register registers a webhook, returning its id.
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	// List returns every registered webhook.
+	List(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*WebhookList, error)
+	// Get returns the webhook.
+	Get(ctx context.Context, in *WebhookRequest, opts ...grpc.CallOption) (*Webhook, error)
+	// Delete deletes the webhook.
+	Delete(ctx context.Context, in *WebhookRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Pause stops the webhook from being triggered, while it keeps counting invocations.
+	Pause(ctx context.Context, in *WebhookRequest, opts ...grpc.CallOption) (*Webhook, error)
+	// Resume lets the webhook be triggered again, resetting its count of failed deliveries.
+	Resume(ctx context.Context, in *WebhookRequest, opts ...grpc.CallOption) (*Webhook, error)
+}
+
+type notificationsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNotificationsClient(cc grpc.ClientConnInterface) NotificationsClient {
+	return &notificationsClient{cc}
+}
+
+func (c *notificationsClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, Notifications_Register_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *notificationsClient) List(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*WebhookList, error) {
+	out := new(WebhookList)
+	err := c.cc.Invoke(ctx, Notifications_List_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *notificationsClient) Get(ctx context.Context, in *WebhookRequest, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, Notifications_Get_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *notificationsClient) Delete(ctx context.Context, in *WebhookRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Notifications_Delete_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *notificationsClient) Pause(ctx context.Context, in *WebhookRequest, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, Notifications_Pause_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *notificationsClient) Resume(ctx context.Context, in *WebhookRequest, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, Notifications_Resume_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NotificationsServer is the server API for Notifications service.
+// All implementations must embed UnimplementedNotificationsServer
+// for forward compatibility
+type NotificationsServer interface {
+	// Register registers a webhook, returning its id.
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	// List returns every registered webhook.
+	List(context.Context, *emptypb.Empty) (*WebhookList, error)
+	// Get returns the webhook.
+	Get(context.Context, *WebhookRequest) (*Webhook, error)
+	// Delete deletes the webhook.
+	Delete(context.Context, *WebhookRequest) (*emptypb.Empty, error)
+	// Pause stops the webhook from being triggered, while it keeps counting invocations.
+	Pause(context.Context, *WebhookRequest) (*Webhook, error)
+	// Resume lets the webhook be triggered again, resetting its count of failed deliveries.
+	Resume(context.Context, *WebhookRequest) (*Webhook, error)
+	mustEmbedUnimplementedNotificationsServer()
+}
+
+// UnimplementedNotificationsServer must be embedded to have forward compatible implementations.
+type UnimplementedNotificationsServer struct {
+}
+
+func (UnimplementedNotificationsServer) Register(context.Context, *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedNotificationsServer) List(context.Context, *emptypb.Empty) (*WebhookList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedNotificationsServer) Get(context.Context, *WebhookRequest) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedNotificationsServer) Delete(context.Context, *WebhookRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedNotificationsServer) Pause(context.Context, *WebhookRequest) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pause not implemented")
+}
+func (UnimplementedNotificationsServer) Resume(context.Context, *WebhookRequest) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resume not implemented")
+}
+func (UnimplementedNotificationsServer) mustEmbedUnimplementedNotificationsServer() {}
+
+// UnsafeNotificationsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NotificationsServer will
+// result in compilation errors.
+type UnsafeNotificationsServer interface {
+	mustEmbedUnimplementedNotificationsServer()
+}
+
+func RegisterNotificationsServer(s grpc.ServiceRegistrar, srv NotificationsServer) {
+	s.RegisterService(&Notifications_ServiceDesc, srv)
+}
+
+func _Notifications_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NotificationsServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Notifications_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NotificationsServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Notifications_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NotificationsServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Notifications_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NotificationsServer).List(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Notifications_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NotificationsServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Notifications_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NotificationsServer).Get(ctx, req.(*WebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Notifications_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NotificationsServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Notifications_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NotificationsServer).Delete(ctx, req.(*WebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Notifications_Pause_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NotificationsServer).Pause(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Notifications_Pause_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NotificationsServer).Pause(ctx, req.(*WebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Notifications_Resume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NotificationsServer).Resume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Notifications_Resume_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NotificationsServer).Resume(ctx, req.(*WebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Notifications_ServiceDesc is the grpc.ServiceDesc for Notifications service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Notifications_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "energy.v1.Notifications",
+	HandlerType: (*NotificationsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _Notifications_Register_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Notifications_List_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _Notifications_Get_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Notifications_Delete_Handler,
+		},
+		{
+			MethodName: "Pause",
+			Handler:    _Notifications_Pause_Handler,
+		},
+		{
+			MethodName: "Resume",
+			Handler:    _Notifications_Resume_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "energy.proto",
+}
@@ -0,0 +1,62 @@
+// Package grpcapi serves the renewables and notification operations over gRPC, for internal
+// consumers preferring it over the REST API. The services share the dataset, the cache worker
+// and the notification DB with the REST handlers, and invocations are recorded in the same way.
+package grpcapi
+
+//go:generate protoc -I energypb --go_out=energypb --go_opt=paths=source_relative --go-grpc_out=energypb --go-grpc_opt=paths=source_relative energypb/energy.proto
+
+import (
+	"Assignment2/caching"
+	"Assignment2/grpcapi/energypb"
+	"Assignment2/tracing"
+	"Assignment2/util"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDKey is the metadata key of the request ID, mirroring the X-Request-ID header.
+const requestIDKey = "x-request-id"
+
+// maxRequestIDLength is the longest request ID accepted from clients.
+const maxRequestIDLength = 64
+
+// NewServer returns a gRPC server with the Renewables and Notifications services registered,
+// along with server reflection for tools such as grpcurl.
+func NewServer(cfg *util.Config, request chan caching.CacheRequest, dataset *util.CountryDataset, invocation *caching.InvocationRecorder) *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(instrument))
+	energypb.RegisterRenewablesServer(server, &renewablesServer{
+		request:    request,
+		dataset:    dataset,
+		invocation: invocation,
+	})
+	energypb.RegisterNotificationsServer(server, &notificationsServer{cfg: cfg, dataset: dataset})
+	reflection.Register(server)
+	return server
+}
+
+// instrument starts a span named after the method for every call, and attaches a request ID
+// for logging. The ID is taken from the x-request-id metadata if supplied by the client, and
+// generated if missing or too long.
+func instrument(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := ""
+	if values := metadata.ValueFromIncomingContext(ctx, requestIDKey); len(values) > 0 {
+		id = values[0]
+	}
+	if id == "" || len(id) > maxRequestIDLength {
+		id = util.NewRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+	ctx, span := tracing.StartSpan(util.WithRequestID(ctx, id), info.FullMethod)
+	util.Logger(ctx).Debug("call received", "method", info.FullMethod)
+	response, err := handler(ctx, request)
+	if status.Code(err) == codes.Internal {
+		tracing.EndSpan(span, err)
+	} else {
+		tracing.EndSpan(span, nil)
+	}
+	return response, err
+}
@@ -0,0 +1,193 @@
+package grpcapi
+
+import (
+	"Assignment2/caching"
+	"Assignment2/consts"
+	"Assignment2/grpcapi/energypb"
+	"Assignment2/util"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"net"
+	"testing"
+	"testing/fstest"
+)
+
+// bufferSize is the size of the in-memory connection between the test client and server.
+const bufferSize = 1 << 20
+
+// dial serves the services on an in-memory listener, returning a connection to it.
+func dial(t *testing.T, server *grpc.Server) *grpc.ClientConn {
+	listener := bufconn.Listen(bufferSize)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	connection, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = connection.Close() })
+	return connection
+}
+
+func TestRenewables(t *testing.T) {
+	var dataset util.CountryDataset
+	if err := dataset.Initialize("." + consts.DataSetPath); err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{}
+	config.InitializeWithDefaults()
+
+	// Stands in for the cache worker, answering with fixed neighbours.
+	requests := make(chan caching.CacheRequest)
+	defer close(requests)
+	go func() {
+		for request := range requests {
+			request.ChannelRef <- caching.CacheResponse{
+				Neighbours: map[string][]string{"NOR": {"FIN", "SWE", "XKX"}},
+			}
+		}
+	}()
+	invocations := caching.NewInvocationRecorder(10)
+	client := energypb.NewRenewablesClient(dial(t, NewServer(&config, requests, &dataset, invocations)))
+	ctx := context.Background()
+
+	var header metadata.MD
+	current, err := client.Current(ctx, &energypb.CurrentRequest{Country: "norway", Neighbours: true}, grpc.Header(&header))
+	if assert.Nil(t, err) {
+		codes := make([]string, 0)
+		for _, statistic := range current.Statistics {
+			codes = append(codes, statistic.Isocode)
+		}
+		assert.Equal(t, []string{"NOR", "FIN", "SWE"}, codes)
+		assert.Equal(t, int32(2021), current.Statistics[0].Year)
+	}
+	assert.Len(t, header.Get(requestIDKey), 1)
+	// The country and its neighbours are recorded as separate reports.
+	assert.Equal(t, 2, invocations.Queued())
+
+	all, err := client.Current(ctx, &energypb.CurrentRequest{})
+	if assert.Nil(t, err) {
+		_, length := dataset.GetLengthOfDataset()
+		assert.Len(t, all.Statistics, length)
+	}
+	assert.Equal(t, 2, invocations.Queued())
+
+	history, err := client.History(ctx, &energypb.HistoryRequest{Country: "NOR", Begin: 2019, End: 2030, SortByValue: true})
+	if assert.Nil(t, err) && assert.Len(t, history.Statistics, 3) {
+		assert.Equal(t, int32(2019), history.Statistics[0].Year)
+		assert.Equal(t, 71.55836486816406, history.Statistics[2].Percentage)
+	}
+	assert.Equal(t, 3, invocations.Queued())
+
+	comparison, err := client.Compare(ctx, &energypb.CompareRequest{Countries: []string{"SWE", "norway", "NOR"}, Begin: 2020})
+	if assert.Nil(t, err) && assert.Len(t, comparison.Ranking, 2) {
+		assert.Equal(t, "NOR", comparison.Ranking[0].Isocode)
+		assert.Equal(t, "Sweden", comparison.Ranking[1].Name)
+		assert.InDelta(t, comparison.Ranking[0].Percentage-comparison.Ranking[1].Percentage, comparison.Spread, 1e-9)
+	}
+	assert.Equal(t, 4, invocations.Queued())
+
+	failing := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"unknown country", func() error {
+			_, err := client.Current(ctx, &energypb.CurrentRequest{Country: "XYZ"})
+			return err
+		}, codes.NotFound},
+		{"unknown name", func() error {
+			_, err := client.History(ctx, &energypb.HistoryRequest{Country: "atlantis"})
+			return err
+		}, codes.NotFound},
+		{"reversed span", func() error {
+			_, err := client.History(ctx, &energypb.HistoryRequest{Begin: 2010, End: 2000})
+			return err
+		}, codes.InvalidArgument},
+		{"span out of record", func() error {
+			_, err := client.History(ctx, &energypb.HistoryRequest{Country: "NOR", Begin: 2030})
+			return err
+		}, codes.NotFound},
+		{"one country compared", func() error {
+			_, err := client.Compare(ctx, &energypb.CompareRequest{Countries: []string{"NOR"}})
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, test := range failing {
+		assert.Equal(t, test.code, status.Code(test.call()), test.name)
+	}
+}
+
+func TestHistoryFill(t *testing.T) {
+	var dataset util.CountryDataset
+	files := fstest.MapFS{"dataset.csv": {Data: []byte("Entity,Code,Year,Renewables\n" +
+		"Norway,NOR,2000,10\nNorway,NOR,2001,20\nNorway,NOR,2004,50\nNorway,NOR,2005,60\n" +
+		"Sweden,SWE,2000,40\nSweden,SWE,2001,50\n")}}
+	if err := dataset.InitializeFS(files, "dataset.csv"); err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{}
+	config.InitializeWithDefaults()
+	client := energypb.NewRenewablesClient(dial(t, NewServer(&config, make(chan caching.CacheRequest), &dataset,
+		caching.NewInvocationRecorder(10))))
+	ctx := context.Background()
+
+	history, err := client.History(ctx, &energypb.HistoryRequest{Country: "NOR"})
+	if assert.Nil(t, err) {
+		assert.Len(t, history.Statistics, 4)
+		assert.Equal(t, []int32{2002, 2003}, history.Gaps)
+	}
+	history, err = client.History(ctx, &energypb.HistoryRequest{Country: "NOR", Fill: util.FillLinear})
+	if assert.Nil(t, err) && assert.Len(t, history.Statistics, 6) {
+		assert.True(t, history.Statistics[2].Filled)
+		assert.Equal(t, 30.0, history.Statistics[2].Percentage)
+	}
+
+	// a span made up of one country's missing years lists that country with its gaps only
+	history, err = client.History(ctx, &energypb.HistoryRequest{Begin: 2002, End: 2003})
+	if assert.Nil(t, err) && assert.Len(t, history.Statistics, 1) {
+		assert.True(t, history.Statistics[0].Missing)
+		assert.Equal(t, []int32{2002, 2003}, history.Statistics[0].Gaps)
+	}
+
+	comparison, err := client.Compare(ctx, &energypb.CompareRequest{Countries: []string{"NOR", "SWE"}, Fill: util.FillPrevious})
+	if assert.Nil(t, err) && assert.Len(t, comparison.Ranking, 2) {
+		assert.Equal(t, "SWE", comparison.Ranking[0].Isocode)
+		assert.Equal(t, 30.0, comparison.Ranking[1].Percentage)
+		assert.Equal(t, []int32{2002, 2003}, comparison.Ranking[1].Gaps)
+	}
+
+	_, err = client.History(ctx, &energypb.HistoryRequest{Fill: "next"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestNotifications(t *testing.T) {
+	var dataset util.CountryDataset
+	if err := dataset.Initialize("." + consts.DataSetPath); err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{}
+	config.InitializeWithDefaults()
+	client := energypb.NewNotificationsClient(dial(t, NewServer(&config, nil, &dataset, caching.NewInvocationRecorder(1))))
+	ctx := context.Background()
+
+	// Without a DB client, every operation is unavailable.
+	_, err := client.Register(ctx, &energypb.RegisterRequest{Url: "https://example.com/hook", Country: "NOR", Calls: 5})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = client.List(ctx, &emptypb.Empty{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = client.Get(ctx, &energypb.WebhookRequest{WebhookId: "id"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = client.Pause(ctx, &energypb.WebhookRequest{WebhookId: "id"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,148 @@
+package grpcapi
+
+import (
+	"Assignment2/grpcapi/energypb"
+	"Assignment2/handlers"
+	"Assignment2/util"
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+// notificationsServer serves the Notifications service from the notification DB, validating
+// registrations as done by the notification endpoint.
+type notificationsServer struct {
+	energypb.UnimplementedNotificationsServer
+	cfg     *util.Config
+	dataset *util.CountryDataset
+}
+
+// Register registers the webhook, returning its id.
+func (s *notificationsServer) Register(ctx context.Context, request *energypb.RegisterRequest) (*energypb.RegisterResponse, error) {
+	if err := s.available(); err != nil {
+		return nil, err
+	}
+	webhook := handlers.Webhook{
+		URL:      request.Url,
+		Country:  request.Country,
+		Endpoint: request.Endpoint,
+		Calls:    request.Calls,
+		Batch:    request.Batch,
+	}
+	if request.Expires != nil {
+		expires := request.Expires.AsTime()
+		webhook.Expires = &expires
+	}
+	id, err := handlers.RegisterWebhook(ctx, s.cfg, s.dataset, webhook)
+	switch {
+	case errors.Is(err, handlers.ErrInvalidWebhook):
+		return nil, status.Error(codes.InvalidArgument, "url must be an absolute http or https url not "+
+			"pointing to a private address, country a cca3 code, name or empty, endpoint current, history, "+
+			"forecast or empty, calls above zero and expires in the future if set")
+	case errors.Is(err, handlers.ErrVerificationFailed):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, s.storageError(ctx, err, "")
+	}
+	return &energypb.RegisterResponse{WebhookId: id}, nil
+}
+
+// List returns every registered webhook.
+func (s *notificationsServer) List(ctx context.Context, _ *emptypb.Empty) (*energypb.WebhookList, error) {
+	if err := s.available(); err != nil {
+		return nil, err
+	}
+	webhooks, err := handlers.ListWebhooks(ctx, s.cfg)
+	if err != nil {
+		return nil, s.storageError(ctx, err, "")
+	}
+	list := &energypb.WebhookList{Webhooks: make([]*energypb.Webhook, 0, len(webhooks))}
+	for _, webhook := range webhooks {
+		list.Webhooks = append(list.Webhooks, webhookMessage(webhook))
+	}
+	return list, nil
+}
+
+// Get returns the webhook.
+func (s *notificationsServer) Get(ctx context.Context, request *energypb.WebhookRequest) (*energypb.Webhook, error) {
+	if err := s.available(); err != nil {
+		return nil, err
+	}
+	webhook, err := handlers.ReadWebhook(s.cfg, request.WebhookId)
+	if err != nil {
+		return nil, s.storageError(ctx, err, request.WebhookId)
+	}
+	return webhookMessage(webhook), nil
+}
+
+// Delete deletes the webhook.
+func (s *notificationsServer) Delete(ctx context.Context, request *energypb.WebhookRequest) (*emptypb.Empty, error) {
+	if err := s.available(); err != nil {
+		return nil, err
+	}
+	if err := handlers.DeleteWebhook(s.cfg, request.WebhookId); err != nil {
+		return nil, s.storageError(ctx, err, request.WebhookId)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Pause pauses the webhook, returning the updated webhook.
+func (s *notificationsServer) Pause(ctx context.Context, request *energypb.WebhookRequest) (*energypb.Webhook, error) {
+	return s.setPaused(ctx, request.WebhookId, true)
+}
+
+// Resume resumes the webhook, returning the updated webhook.
+func (s *notificationsServer) Resume(ctx context.Context, request *energypb.WebhookRequest) (*energypb.Webhook, error) {
+	return s.setPaused(ctx, request.WebhookId, false)
+}
+
+// setPaused pauses or resumes the webhook.
+func (s *notificationsServer) setPaused(ctx context.Context, id string, paused bool) (*energypb.Webhook, error) {
+	if err := s.available(); err != nil {
+		return nil, err
+	}
+	webhook, err := handlers.SetWebhookPaused(s.cfg, id, paused)
+	if err != nil {
+		return nil, s.storageError(ctx, err, id)
+	}
+	return webhookMessage(webhook), nil
+}
+
+// available returns an Unavailable error if the service has no connection to the DB.
+func (s *notificationsServer) available() error {
+	if s.cfg.FirestoreClient == nil {
+		return status.Error(codes.Unavailable, "notification DB is unavailable")
+	}
+	return nil
+}
+
+// storageError returns NotFound if the error shows the webhook doesn't exist, and Internal for
+// any other failure of the firestore interaction, leaving out its details.
+func (s *notificationsServer) storageError(ctx context.Context, err error, id string) error {
+	if status.Code(err) == codes.NotFound {
+		return status.Error(codes.NotFound, "no webhook with id "+id)
+	}
+	util.Logger(ctx).Error("grpc notifications: firestore interaction failed", "webhook", id, "error", err)
+	return status.Error(codes.Internal, "something went wrong, try again later")
+}
+
+// webhookMessage converts a webhook to its protobuf message.
+func webhookMessage(webhook handlers.WebhookDisplay) *energypb.Webhook {
+	message := &energypb.Webhook{
+		WebhookId: webhook.WebhookId,
+		Url:       webhook.URL,
+		Country:   webhook.Country,
+		Endpoint:  webhook.Endpoint,
+		Calls:     webhook.Calls,
+		Batch:     webhook.Batch,
+		Paused:    webhook.Paused,
+	}
+	if webhook.Expires != nil {
+		message.Expires = timestamppb.New(webhook.Expires.In(time.UTC))
+	}
+	return message
+}
@@ -0,0 +1,222 @@
+package grpcapi
+
+import (
+	"Assignment2/caching"
+	"Assignment2/grpcapi/energypb"
+	"Assignment2/handlers"
+	"Assignment2/util"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// minimumCompared is the fewest countries accepted by Compare.
+const minimumCompared = 2
+
+// renewablesServer serves the Renewables service from the dataset, finding neighbours through
+// the cache worker.
+type renewablesServer struct {
+	energypb.UnimplementedRenewablesServer
+	request    chan caching.CacheRequest
+	dataset    *util.CountryDataset
+	invocation *caching.InvocationRecorder
+}
+
+// Current returns the share of renewables in the latest year, as done by the current endpoint.
+func (s *renewablesServer) Current(ctx context.Context, request *energypb.CurrentRequest) (*energypb.StatisticsList, error) {
+	if request.Country == "" {
+		return statisticsList(s.dataset.GetStatistics()), nil
+	}
+	code, err := s.resolveCountry(request.Country)
+	if err != nil {
+		return nil, err
+	}
+	statistic, err := s.dataset.GetStatistic(code)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "no statistics found for "+code)
+	}
+	s.invocation.Record([]caching.Invocation{
+		{Ctx: ctx, Endpoint: caching.EndpointCurrent, Country: code, QueryType: caching.QueryCountry},
+	})
+	stats := []util.RenewableStatistics{statistic}
+	if request.Neighbours {
+		response := make(chan caching.CacheResponse)
+		s.request <- caching.CacheRequest{Ctx: ctx, ChannelRef: response, CountryRequest: []string{code}}
+		result := <-response
+		if result.Status != http.StatusNotFound {
+			neighbourInvocations := make([]caching.Invocation, 0, len(result.Neighbours[code]))
+			for _, neighbour := range result.Neighbours[code] {
+				neighbourInvocations = append(neighbourInvocations, caching.Invocation{
+					Ctx: ctx, Endpoint: caching.EndpointCurrent, Country: neighbour, QueryType: caching.QueryNeighbour,
+				})
+				if statistic, err := s.dataset.GetStatistic(neighbour); err == nil {
+					stats = append(stats, statistic)
+				}
+			}
+			s.invocation.Record(neighbourInvocations)
+		}
+	}
+	return statisticsList(stats), nil
+}
+
+// History returns the yearly shares of a country, or the mean share of every country, as done
+// by the history endpoint.
+func (s *renewablesServer) History(ctx context.Context, request *energypb.HistoryRequest) (*energypb.StatisticsList, error) {
+	begin, end := int(request.Begin), int(request.End)
+	if begin != 0 && end != 0 && begin > end {
+		return nil, status.Error(codes.InvalidArgument, "begin must be smaller than end")
+	}
+	fill, err := parseFill(request.Fill)
+	if err != nil {
+		return nil, err
+	}
+	if request.Country == "" {
+		averages := s.dataset.GetHistoricAverages(begin, end, fill, request.SortByValue)
+		list := &energypb.StatisticsList{Statistics: make([]*energypb.Statistic, 0, len(averages))}
+		for _, average := range averages {
+			statistic := &energypb.Statistic{Name: average.Name, Isocode: average.Isocode, Gaps: years(average.Gaps)}
+			if average.Percentage != nil {
+				statistic.Percentage = *average.Percentage
+			} else {
+				statistic.Missing = true
+			}
+			list.Statistics = append(list.Statistics, statistic)
+		}
+		return list, nil
+	}
+	code, err := s.resolveCountry(request.Country)
+	if err != nil {
+		return nil, err
+	}
+	begin, end = s.span(code, begin, end)
+	if begin > end {
+		return nil, status.Error(codes.NotFound, "span of years indicated by begin/end is not in record")
+	}
+	s.invocation.Record([]caching.Invocation{
+		{Ctx: ctx, Endpoint: caching.EndpointHistory, Country: code, QueryType: caching.QueryCountry},
+	})
+	stats := s.dataset.GetFilledStatisticsRange(code, begin, end, fill)
+	if request.SortByValue {
+		stats = handlers.SortStatistics(stats)
+	}
+	list := statisticsList(stats)
+	list.Gaps = years(s.dataset.GetGaps(code, begin, end))
+	return list, nil
+}
+
+// Compare ranks the countries by their mean share of renewables over the span of years. Each
+// country is recorded as an invocation of the history endpoint.
+func (s *renewablesServer) Compare(ctx context.Context, request *energypb.CompareRequest) (*energypb.Comparison, error) {
+	if len(request.Countries) < minimumCompared {
+		return nil, status.Error(codes.InvalidArgument, "at least two countries must be compared")
+	}
+	begin, end := int(request.Begin), int(request.End)
+	if begin != 0 && end != 0 && begin > end {
+		return nil, status.Error(codes.InvalidArgument, "begin must be smaller than end")
+	}
+	fill, err := parseFill(request.Fill)
+	if err != nil {
+		return nil, err
+	}
+	ranking := make([]util.RenewableStatistics, 0, len(request.Countries))
+	gaps := make(map[string][]int)
+	invocations := make([]caching.Invocation, 0, len(request.Countries))
+	seen := make(map[string]bool)
+	for _, country := range request.Countries {
+		code, err := s.resolveCountry(country)
+		if err != nil {
+			return nil, err
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		percentage, missing, err := s.dataset.CalculatePercentage(code, begin, end, fill)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, code+": "+err.Error())
+		}
+		name, _ := s.dataset.GetFullName(code)
+		ranking = append(ranking, util.RenewableStatistics{Name: name, Isocode: code, Percentage: percentage})
+		gaps[code] = missing
+		invocations = append(invocations, caching.Invocation{
+			Ctx: ctx, Endpoint: caching.EndpointHistory, Country: code, QueryType: caching.QueryCountry,
+		})
+	}
+	s.invocation.Record(invocations)
+	sort.SliceStable(ranking, func(i, j int) bool { return ranking[i].Percentage > ranking[j].Percentage })
+	comparison := &energypb.Comparison{
+		Ranking: statisticsList(ranking).Statistics,
+		Spread:  ranking[0].Percentage - ranking[len(ranking)-1].Percentage,
+	}
+	for _, statistic := range comparison.Ranking {
+		statistic.Gaps = years(gaps[statistic.Isocode])
+	}
+	return comparison, nil
+}
+
+// parseFill returns the way of filling missing years of a request, none if empty.
+func parseFill(fill string) (string, error) {
+	if fill == "" {
+		return util.FillNone, nil
+	}
+	if err := util.ValidateFill(fill); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	return fill, nil
+}
+
+// resolveCountry returns the cca3 code of the country given by its code or name.
+func (s *renewablesServer) resolveCountry(country string) (string, error) {
+	code := strings.ToUpper(country)
+	if len(code) > 3 {
+		var err error
+		if code, err = s.dataset.GetCountryByName(code); err != nil {
+			return "", status.Error(codes.NotFound, "no country with the name "+country+" in dataset")
+		}
+	}
+	if !s.dataset.HasCountryInRecords(code) {
+		return "", status.Error(codes.NotFound, "code misspelled or country not in dataset: "+country)
+	}
+	return code, nil
+}
+
+// span limits the span of years to the records of the country, with zero for an unbounded end.
+func (s *renewablesServer) span(code string, begin int, end int) (int, int) {
+	begin = util.Max(begin, s.dataset.GetFirstYear(code))
+	if end == 0 {
+		end = s.dataset.GetLastYear(code)
+	} else {
+		end = util.Min(end, s.dataset.GetLastYear(code))
+	}
+	return begin, end
+}
+
+// statisticsList converts statistics of the dataset to their protobuf messages.
+func statisticsList(stats []util.RenewableStatistics) *energypb.StatisticsList {
+	list := &energypb.StatisticsList{Statistics: make([]*energypb.Statistic, 0, len(stats))}
+	for _, statistic := range stats {
+		list.Statistics = append(list.Statistics, &energypb.Statistic{
+			Name:       statistic.Name,
+			Isocode:    statistic.Isocode,
+			Year:       int32(statistic.Year),
+			Percentage: statistic.Percentage,
+			Filled:     statistic.Filled,
+		})
+	}
+	return list
+}
+
+// years converts years of the dataset to their protobuf values, nil if there are none.
+func years(values []int) []int32 {
+	if len(values) == 0 {
+		return nil
+	}
+	converted := make([]int32, 0, len(values))
+	for _, value := range values {
+		converted = append(converted, int32(value))
+	}
+	return converted
+}
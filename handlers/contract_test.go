@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"Assignment2/caching"
+	"Assignment2/consts"
+	"Assignment2/events"
+	"Assignment2/openapi"
+	"Assignment2/ratelimit"
+	"Assignment2/router"
+	"Assignment2/util"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestContract checks the responses of the handlers against the OpenAPI specification, for
+// every response that can be produced without the notification DB or countries API.
+func TestContract(t *testing.T) {
+	ctx := context.Background()
+	doc, err := openapi3.NewLoader().LoadFromData(openapi.Spec())
+	if !assert.Nil(t, err) || !assert.Nil(t, doc.Validate(ctx)) {
+		return
+	}
+	specRouter, err := gorillamux.NewRouter(doc)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var dataset util.CountryDataset
+	if err = dataset.Initialize("." + consts.DataSetPath); err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{}
+	config.InitializeWithDefaults()
+	// Requests for neighbours are not covered, so no cache worker serves the channel.
+	requests := make(chan caching.CacheRequest)
+	invocations := caching.NewInvocationRecorder(10)
+
+	routes := router.New()
+	routes.Merge(HandlerRenew(requests, &dataset, invocations), func(h http.Handler) http.Handler { return h })
+	routes.Merge(NotificationHandler(&config, &dataset, events.NewBroker()), func(h http.Handler) http.Handler { return h })
+	graphQLHandler, err := HandlerGraphQL(&config, requests, &dataset, invocations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes.HandleFunc(http.MethodPost, consts.GraphQLPath, graphQLHandler)
+	routes.HandleFunc(http.MethodGet, consts.StatusPath, HandlerStatus(&config, time.Now(), &dataset))
+	routes.HandleFunc(http.MethodGet, consts.HealthPath, HandlerHealth())
+	routes.HandleFunc(http.MethodGet, consts.ReadyPath, HandlerReady(&config, &dataset))
+
+	limitedConfig := config
+	limitedConfig.RateLimitPerMinute = 1
+	limitedConfig.RateLimitBurst = 1
+	limited := ratelimit.NewLimiter(&limitedConfig).Limit(routes.ServeHTTP)
+
+	// Paths of the specification have no trailing slashes.
+	currentRoute := consts.RenewablesPath + currentPath
+	historyRoute := consts.RenewablesPath + historyPath
+	forecastRoute := consts.RenewablesPath + forecastPath
+	summaryRoute := consts.RenewablesPath + summaryPath
+	statusPath := strings.TrimSuffix(consts.StatusPath, "/")
+	notificationPath := strings.TrimSuffix(consts.NotificationPath, "/")
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		header  http.Header
+		handler http.HandlerFunc
+		status  int
+	}{
+		{"current", http.MethodGet, currentRoute, "", nil, routes.ServeHTTP, http.StatusOK},
+		{"current country", http.MethodGet, currentRoute + "/NOR", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"current country name", http.MethodGet, currentRoute + "/norway", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"current unknown country", http.MethodGet, currentRoute + "/XYZ", "", nil, routes.ServeHTTP, http.StatusNotFound},
+		{"current not modified", http.MethodGet, currentRoute + "/NOR", "", http.Header{"If-None-Match": {"*"}}, routes.ServeHTTP, http.StatusNotModified},
+		{"current without neighbours", http.MethodGet, currentRoute + "/NOR?neighbours=false", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"history", http.MethodGet, historyRoute, "", nil, routes.ServeHTTP, http.StatusOK},
+		{"history span", http.MethodGet, historyRoute + "?begin=1995&end=2006&sortByValue=true", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"history compressed", http.MethodGet, historyRoute, "", http.Header{"Accept-Encoding": {"gzip"}}, routes.ServeHTTP, http.StatusOK},
+		{"history country", http.MethodGet, historyRoute + "/NOR?begin=2000", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"history reversed span", http.MethodGet, historyRoute + "/NOR?begin=2010&end=2000", "", nil, routes.ServeHTTP, http.StatusBadRequest},
+		{"history filled", http.MethodGet, historyRoute + "?fill=linear", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"history country filled", http.MethodGet, historyRoute + "/NOR?fill=previous", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"history invalid fill", http.MethodGet, historyRoute + "/NOR?fill=next", "", nil, routes.ServeHTTP, http.StatusBadRequest},
+		{"history unknown country", http.MethodGet, historyRoute + "/XYZ", "", nil, routes.ServeHTTP, http.StatusNotFound},
+		{"forecast", http.MethodGet, forecastRoute + "/NOR", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"forecast model", http.MethodGet, forecastRoute + "/norway?horizon=30&model=logistic", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"forecast invalid horizon", http.MethodGet, forecastRoute + "/NOR?horizon=0", "", nil, routes.ServeHTTP, http.StatusBadRequest},
+		{"forecast unknown model", http.MethodGet, forecastRoute + "/NOR?model=arima", "", nil, routes.ServeHTTP, http.StatusBadRequest},
+		{"forecast unknown country", http.MethodGet, forecastRoute + "/XYZ", "", nil, routes.ServeHTTP, http.StatusNotFound},
+		{"summary", http.MethodGet, summaryRoute, "", nil, routes.ServeHTTP, http.StatusOK},
+		{"summary span", http.MethodGet, summaryRoute + "?begin=2000&end=2010", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"summary reversed span", http.MethodGet, summaryRoute + "?begin=2010&end=2000", "", nil, routes.ServeHTTP, http.StatusBadRequest},
+		{"summary year", http.MethodGet, summaryRoute + "/2021", "", nil, routes.ServeHTTP, http.StatusOK},
+		{"summary unknown year", http.MethodGet, summaryRoute + "/1800", "", nil, routes.ServeHTTP, http.StatusNotFound},
+		{"register malformed webhook", http.MethodPost, notificationPath, "{", nil, routes.ServeHTTP, http.StatusBadRequest},
+		{"register invalid webhook", http.MethodPost, notificationPath, `{"url": "http://127.0.0.1/", "calls": 0}`, nil, routes.ServeHTTP, http.StatusUnprocessableEntity},
+		{"graphql", http.MethodPost, consts.GraphQLPath, `{"query": "{ country(code: \"NOR\") { name series { year } } }"}`, nil, routes.ServeHTTP, http.StatusOK},
+		{"graphql invalid query", http.MethodPost, consts.GraphQLPath, `{"query": "{ country }"}`, nil, routes.ServeHTTP, http.StatusOK},
+		{"graphql without query", http.MethodPost, consts.GraphQLPath, `{}`, nil, routes.ServeHTTP, http.StatusBadRequest},
+		{"status", http.MethodGet, statusPath, "", nil, routes.ServeHTTP, http.StatusOK},
+		{"health", http.MethodGet, consts.HealthPath, "", nil, routes.ServeHTTP, http.StatusServiceUnavailable},
+		{"ready", http.MethodGet, consts.ReadyPath, "", nil, routes.ServeHTTP, http.StatusServiceUnavailable},
+		{"rate limited", http.MethodGet, currentRoute + "/NOR", "", nil, func(w http.ResponseWriter, r *http.Request) {
+			limited(httptest.NewRecorder(), r.Clone(r.Context()))
+			limited(w, r)
+		}, http.StatusTooManyRequests},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			for name, values := range test.header {
+				request.Header[name] = values
+			}
+			if test.body != "" {
+				request.Header.Set("content-type", "application/json")
+			}
+			route, params, err := specRouter.FindRoute(request)
+			if !assert.Nil(t, err, "no operation in the specification") {
+				return
+			}
+			recorder := httptest.NewRecorder()
+			test.handler(recorder, request)
+			assert.Equal(t, test.status, recorder.Code)
+
+			body := recorder.Body.Bytes()
+			if recorder.Header().Get("content-encoding") == "gzip" {
+				reader, err := gzip.NewReader(bytes.NewReader(body))
+				if !assert.Nil(t, err) {
+					return
+				}
+				if body, err = io.ReadAll(reader); !assert.Nil(t, err) {
+					return
+				}
+			}
+			err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{
+					Request:    request,
+					PathParams: params,
+					Route:      route,
+				},
+				Status: recorder.Code,
+				Header: recorder.Result().Header,
+				Body:   io.NopCloser(bytes.NewReader(body)),
+			})
+			assert.Nil(t, err, string(body))
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"Assignment2/caching"
+	"Assignment2/forecast"
+	"Assignment2/util"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Internal - paths
+const forecastPath = "forecast"
+
+// defaultHorizon and maximumHorizon bound the number of years projected by a forecast.
+const (
+	defaultHorizon = 10
+	maximumHorizon = 50
+)
+
+// forecastResponse is the projection of a country's renewable energy share, as sent to clients.
+type forecastResponse struct {
+	Name        string                `json:"name"`
+	Isocode     string                `json:"isocode"`
+	Model       string                `json:"model"`
+	Confidence  float64               `json:"confidence"`
+	RMSE        float64               `json:"rmse"`
+	Projections []forecast.Projection `json:"projections"`
+}
+
+// handlerForecast handles requests for the projected renewable energy share of one country,
+// fitting a model to the yearly shares of the country. The number of years projected is set by
+// the horizon query, and the model by the model query, selecting the best fit by default
+func handlerForecast(w http.ResponseWriter, r *http.Request, code string, dataset *util.CountryDataset, invocation *caching.InvocationRecorder) {
+	ctx := r.Context()
+	// if code is longer than three characters, then it is treated as a country name
+	if len(code) > 3 {
+		var err error
+		code, err = dataset.GetCountryByName(code)
+		if err != nil {
+			util.WriteProblem(w, r, http.StatusNotFound, util.ProblemCountryNotFound,
+				"no country with that name in dataset")
+			return
+		}
+	}
+	if !dataset.HasCountryInRecords(code) {
+		util.WriteProblem(w, r, http.StatusNotFound, util.ProblemCountryNotFound,
+			"code misspelled or country not in dataset")
+		return
+	}
+	horizon, model, err := parseForecastQuery(r)
+	if err != nil {
+		util.WriteProblem(w, r, http.StatusBadRequest, util.ProblemInvalidParameter, err.Error())
+		return
+	}
+	invocation.Record([]caching.Invocation{
+		{Ctx: ctx, Endpoint: caching.EndpointForecast, Country: code, QueryType: caching.QueryCountry},
+	})
+
+	stats := dataset.GetStatisticsRange(code, dataset.GetFirstYear(code), dataset.GetLastYear(code))
+	observations := make([]forecast.Observation, 0, len(stats))
+	for _, statistic := range stats {
+		observations = append(observations, forecast.Observation{Year: statistic.Year, Percentage: statistic.Percentage})
+	}
+	projected, err := forecast.Project(observations, model, horizon)
+	if errors.Is(err, forecast.ErrInsufficientData) {
+		util.WriteProblem(w, r, http.StatusUnprocessableEntity, util.ProblemInsufficientData, err.Error())
+		return
+	} else if err != nil {
+		util.WriteProblem(w, r, http.StatusBadRequest, util.ProblemInvalidParameter, err.Error())
+		return
+	}
+	util.Logger(ctx).Debug("forecast", "country", code, "model", projected.Model, "rmse", projected.RMSE)
+
+	name, _ := dataset.GetFullName(code)
+	http.Header.Add(w.Header(), "content-type", "application/json")
+	util.EncodeAndWriteResponse(&w, forecastResponse{
+		Name:        name,
+		Isocode:     code,
+		Model:       projected.Model,
+		Confidence:  forecast.Confidence,
+		RMSE:        projected.RMSE,
+		Projections: projected.Projections,
+	})
+}
+
+// parseForecastQuery parses the horizon and model queries of a request to the forecast handler,
+// returning the defaults for those not present
+func parseForecastQuery(r *http.Request) (int, string, error) {
+	query := r.URL.Query()
+	horizon := defaultHorizon
+	if _, ok := query["horizon"]; ok {
+		var err error
+		horizon, err = strconv.Atoi(query.Get("horizon"))
+		if err != nil || horizon < 1 || horizon > maximumHorizon {
+			return 0, "", errors.New("horizon must be a whole number from 1 to " + strconv.Itoa(maximumHorizon))
+		}
+	}
+	model := forecast.ModelAuto
+	if _, ok := query["model"]; ok {
+		model = query.Get("model")
+		if err := forecast.ValidateModel(model); err != nil {
+			return 0, "", err
+		}
+	}
+	return horizon, model, nil
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"Assignment2/caching"
+	"Assignment2/consts"
+	"Assignment2/util"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerForecast(t *testing.T) {
+	var dataset util.CountryDataset
+	if err := dataset.Initialize("." + consts.DataSetPath); err != nil {
+		t.Fatal(err)
+	}
+	invocations := caching.NewInvocationRecorder(10)
+	routes := HandlerRenew(make(chan caching.CacheRequest), &dataset, invocations)
+
+	get := func(path string) (int, forecastResponse) {
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, consts.RenewablesPath+forecastPath+path, nil))
+		response := forecastResponse{}
+		if recorder.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&response))
+		}
+		return recorder.Code, response
+	}
+
+	status, response := get("/nor")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Norway", response.Name)
+	assert.Equal(t, "NOR", response.Isocode)
+	assert.NotEqual(t, "", response.Model)
+	if assert.Len(t, response.Projections, defaultHorizon) {
+		assert.Equal(t, dataset.GetLastYear("NOR")+1, response.Projections[0].Year)
+	}
+
+	status, response = get("/Norway?horizon=3&model=holt")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "holt", response.Model)
+	assert.Len(t, response.Projections, 3)
+	assert.Equal(t, 2, invocations.Queued())
+
+	for _, path := range []string{"/NOR?horizon=51", "/NOR?horizon=ten", "/NOR?model=arima"} {
+		status, _ = get(path)
+		assert.Equal(t, http.StatusBadRequest, status, path)
+	}
+	// invalid requests are not recorded as invocations
+	assert.Equal(t, 2, invocations.Queued())
+	status, _ = get("/XYZ")
+	assert.Equal(t, http.StatusNotFound, status)
+}
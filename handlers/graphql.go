@@ -0,0 +1,562 @@
+package handlers
+
+import (
+	"Assignment2/caching"
+	"Assignment2/util"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"google.golang.org/api/iterator"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxGraphQLRequestSize limits the size of the body of GraphQL requests, in bytes.
+const maxGraphQLRequestSize = 64 << 10
+
+// introspectionPrefix prefixes the names of introspection fields, which are left out of the
+// depth and cost of queries.
+const introspectionPrefix = "__"
+
+// lookupCost is the cost of fields looked up outside the dataset, through the cache worker or
+// the notification DB. Other fields cost 1.
+const lookupCost = 50
+
+// costCeiling caps estimated costs, keeping fragments spread many times over from overflowing.
+const costCeiling = math.MaxInt32
+
+// neighbourEstimate is the number of neighbours assumed for each country when estimating the
+// cost of fields below neighbours.
+const neighbourEstimate = 8
+
+// Fields with a cost or number of results differing from other fields.
+const (
+	fieldCountries  = "countries"
+	fieldNeighbours = "neighbours"
+	fieldWebhooks   = "webhooks"
+)
+
+// GraphQLRequest is the body of POST requests to the GraphQL endpoint. GET requests carry the
+// same fields as query parameters, with variables encoded as json.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// graphCountry is a country resolved by the GraphQL schema. Lookups of the series and current
+// share of a country are recorded as invocations of the query type, unless it is empty.
+type graphCountry struct {
+	code      string
+	queryType string
+}
+
+// graphResolver resolves the fields of the GraphQL schema from the dataset, the cache worker
+// and the notification DB.
+type graphResolver struct {
+	cfg        *util.Config
+	request    chan caching.CacheRequest
+	dataset    *util.CountryDataset
+	invocation *caching.InvocationRecorder
+}
+
+// HandlerGraphQL Handler for the GraphQL endpoint, exposing countries of the dataset along with
+// their yearly series, neighbours and webhooks. Lets clients follow neighbours in one request,
+// rather than chaining requests to the renewables endpoints:
+//
+//	{
+//	  country(code: "NOR") {
+//	    name
+//	    average
+//	    neighbours { code series(begin: 2015) { year percentage } }
+//	  }
+//	}
+//
+// Queries nesting fields deeper than set in config, or with a higher estimated cost, are refused
+// before they are executed.
+//
+// On success: the handler
+// On failure: error describing why the schema could not be built
+func HandlerGraphQL(cfg *util.Config, request chan caching.CacheRequest, dataset *util.CountryDataset, invocation *caching.InvocationRecorder) (http.HandlerFunc, error) {
+	resolver := &graphResolver{cfg: cfg, request: request, dataset: dataset, invocation: invocation}
+	schema, err := resolver.schema()
+	if err != nil {
+		return nil, errors.New("graphql schema: " + err.Error())
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := decodeGraphQLRequest(w, r)
+		if err != nil {
+			util.WriteProblem(w, r, http.StatusBadRequest, util.ProblemInvalidParameter, err.Error())
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		_, countries := dataset.GetLengthOfDataset()
+		limits := queryLimits{maxDepth: cfg.GraphQLMaxDepth, maxCost: cfg.GraphQLMaxCost, countries: countries}
+		util.EncodeAndWriteResponse(&w, executeGraphQL(r.Context(), &schema, query, limits))
+	}, nil
+}
+
+// decodeGraphQLRequest reads the GraphQL request from the body of POST requests, and from the
+// query parameters of GET requests.
+func decodeGraphQLRequest(w http.ResponseWriter, r *http.Request) (GraphQLRequest, error) {
+	query := GraphQLRequest{}
+	if r.Method == http.MethodPost {
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLRequestSize))
+		if err := decoder.Decode(&query); err != nil {
+			return query, errors.New("body must be a json object with a query, see the GraphQL over HTTP specification")
+		}
+	} else {
+		parameters := r.URL.Query()
+		query.Query = parameters.Get("query")
+		query.OperationName = parameters.Get("operationName")
+		if variables := parameters.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &query.Variables); err != nil {
+				return query, errors.New("variables must be a json object")
+			}
+		}
+	}
+	if strings.TrimSpace(query.Query) == "" {
+		return query, errors.New("query must not be empty")
+	}
+	return query, nil
+}
+
+// queryLimits bounds the work done by a query. Countries is the number of countries listed by
+// the countries field, used when estimating the cost of queries.
+type queryLimits struct {
+	maxDepth  int
+	maxCost   int
+	countries int
+}
+
+// executeGraphQL parses, validates and executes the query. Errors in the query are reported
+// in the result, as required by the GraphQL specification.
+func executeGraphQL(ctx context.Context, schema *graphql.Schema, query GraphQLRequest, limits queryLimits) *graphql.Result {
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(query.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if depth := queryDepth(document); depth > limits.maxDepth {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New(
+			"query depth " + strconv.Itoa(depth) + " exceeds the maximum of " + strconv.Itoa(limits.maxDepth)))}
+	}
+	if cost := queryCost(document, limits.countries); cost > limits.maxCost {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New(
+			"query cost " + strconv.Itoa(cost) + " exceeds the maximum of " + strconv.Itoa(limits.maxCost)))}
+	}
+	if validation := graphql.ValidateDocument(schema, document, nil); !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        *schema,
+		AST:           document,
+		OperationName: query.OperationName,
+		Args:          query.Variables,
+		Context:       ctx,
+	})
+}
+
+// queryDepth returns the deepest nesting of fields in any operation of the document, following
+// fragments. Introspection fields are not counted.
+func queryDepth(document *ast.Document) int {
+	walk := newFragmentWalk(document)
+	depth := 0
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			depth = util.Max(depth, selectionDepth(operation.SelectionSet, walk))
+		}
+	}
+	return depth
+}
+
+// selectionDepth returns the deepest nesting of fields in the selection set.
+func selectionDepth(selections *ast.SelectionSet, walk *fragmentWalk) int {
+	if selections == nil {
+		return 0
+	}
+	depth := 0
+	for _, selection := range selections.Selections {
+		switch node := selection.(type) {
+		case *ast.Field:
+			if !strings.HasPrefix(node.Name.Value, introspectionPrefix) {
+				depth = util.Max(depth, 1+selectionDepth(node.SelectionSet, walk))
+			}
+		case *ast.InlineFragment:
+			depth = util.Max(depth, selectionDepth(node.SelectionSet, walk))
+		case *ast.FragmentSpread:
+			depth = util.Max(depth, walk.follow(node.Name.Value, selectionDepth))
+		}
+	}
+	return depth
+}
+
+// queryCost returns the highest estimated cost of any operation of the document, following
+// fragments. Every field is counted, including aliases of the same field, and fields below
+// lists are counted once for every expected result. Introspection fields are not counted.
+func queryCost(document *ast.Document, countries int) int {
+	walk := newFragmentWalk(document)
+	var selectionCost func(*ast.SelectionSet, *fragmentWalk) int
+	selectionCost = func(selections *ast.SelectionSet, walk *fragmentWalk) int {
+		if selections == nil {
+			return 0
+		}
+		cost := 0
+		for _, selection := range selections.Selections {
+			switch node := selection.(type) {
+			case *ast.Field:
+				name := node.Name.Value
+				if strings.HasPrefix(name, introspectionPrefix) {
+					continue
+				}
+				fieldCost, results := 1, 1
+				switch name {
+				case fieldCountries:
+					results = countries
+				case fieldNeighbours:
+					fieldCost, results = lookupCost, neighbourEstimate
+				case fieldWebhooks:
+					fieldCost = lookupCost
+				}
+				cost += fieldCost + results*selectionCost(node.SelectionSet, walk)
+			case *ast.InlineFragment:
+				cost += selectionCost(node.SelectionSet, walk)
+			case *ast.FragmentSpread:
+				cost += walk.follow(node.Name.Value, selectionCost)
+			}
+		}
+		return util.Min(cost, costCeiling)
+	}
+	cost := 0
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			cost = util.Max(cost, selectionCost(operation.SelectionSet, walk))
+		}
+	}
+	return cost
+}
+
+// fragmentWalk follows the fragments of a document while measuring its selection sets, measuring
+// each fragment once however often it is spread. Fragments already being followed are skipped,
+// as cycles of fragments are only refused by validation.
+type fragmentWalk struct {
+	fragments map[string]*ast.FragmentDefinition
+	following map[string]bool
+	measured  map[string]int
+}
+
+// newFragmentWalk returns a walk of the fragments defined in the document.
+func newFragmentWalk(document *ast.Document) *fragmentWalk {
+	walk := &fragmentWalk{
+		fragments: make(map[string]*ast.FragmentDefinition),
+		following: make(map[string]bool),
+		measured:  make(map[string]int),
+	}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			walk.fragments[fragment.Name.Value] = fragment
+		}
+	}
+	return walk
+}
+
+// follow returns the measure of the named fragment, or 0 if unknown or already being followed.
+func (w *fragmentWalk) follow(name string, measure func(*ast.SelectionSet, *fragmentWalk) int) int {
+	if value, ok := w.measured[name]; ok {
+		return value
+	}
+	fragment, ok := w.fragments[name]
+	if !ok || w.following[name] {
+		return 0
+	}
+	w.following[name] = true
+	value := measure(fragment.SelectionSet, w)
+	delete(w.following, name)
+	w.measured[name] = value
+	return value
+}
+
+// schema builds the GraphQL schema:
+//
+//	type Query {
+//	  country(code: String!): Country
+//	  countries: [Country!]!
+//	}
+//	type Country {
+//	  name: String!
+//	  code: String!
+//	  average: Float!
+//	  current: RenewableShare
+//	  series(begin: Int, end: Int, fill: String): [RenewableShare!]!
+//	  gaps(begin: Int, end: Int): [Int!]!
+//	  neighbours: [Country!]!
+//	  webhooks: [Webhook!]!
+//	}
+func (g *graphResolver) schema() (graphql.Schema, error) {
+	share := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "RenewableShare",
+		Description: "Share of renewables in the energy consumption of a country in a year.",
+		Fields: graphql.Fields{
+			"year":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"percentage": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"filled": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Set if the year is missing in the dataset and its share filled.",
+			},
+		},
+	})
+	webhook := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Webhook",
+		Description: "Webhook counting invocations for a country.",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(WebhookDisplay).WebhookId, nil
+				},
+			},
+			"url":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"endpoint": &graphql.Field{Type: graphql.String},
+			"calls":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"expires":  &graphql.Field{Type: graphql.DateTime},
+			"batch":    &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"paused":   &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+	var country *graphql.Object
+	country = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Country",
+		Description: "Country of the renewables dataset.",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"code": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "cca3 code of the country.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(graphCountry).code, nil
+					},
+				},
+				"name": &graphql.Field{
+					Type:    graphql.NewNonNull(graphql.String),
+					Resolve: g.resolveName,
+				},
+				"average": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Float),
+					Description: "Mean share of renewables over the years of the dataset.",
+					Resolve:     g.resolveAverage,
+				},
+				"current": &graphql.Field{
+					Type:        share,
+					Description: "Share of renewables in the latest year of the dataset.",
+					Resolve:     g.resolveCurrent,
+				},
+				"series": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(share))),
+					Description: "Yearly shares of renewables, from begin to end if given, with missing years filled as set by fill.",
+					Args: graphql.FieldConfigArgument{
+						"begin": &graphql.ArgumentConfig{Type: graphql.Int},
+						"end":   &graphql.ArgumentConfig{Type: graphql.Int},
+						"fill":  &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: util.FillNone},
+					},
+					Resolve: g.resolveSeries,
+				},
+				"gaps": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int))),
+					Description: "Years missing in the dataset, from begin to end if given.",
+					Args: graphql.FieldConfigArgument{
+						"begin": &graphql.ArgumentConfig{Type: graphql.Int},
+						"end":   &graphql.ArgumentConfig{Type: graphql.Int},
+					},
+					Resolve: g.resolveGaps,
+				},
+				"neighbours": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(country))),
+					Description: "Bordering countries found in the dataset.",
+					Resolve:     g.resolveNeighbours,
+				},
+				"webhooks": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(webhook))),
+					Description: "Webhooks registered for the country.",
+					Resolve:     g.resolveWebhooks,
+				},
+			}
+		}),
+	})
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"country": &graphql.Field{
+				Type:        country,
+				Description: "Country given by its cca3 code or name, or null if not in the dataset.",
+				Args: graphql.FieldConfigArgument{
+					"code": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: g.resolveCountry,
+			},
+			"countries": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(country))),
+				Description: "Every country of the dataset, ordered by code.",
+				Resolve:     g.resolveCountries,
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// resolveCountry resolves a country by its cca3 code or name, as done by the renewables endpoints.
+func (g *graphResolver) resolveCountry(p graphql.ResolveParams) (interface{}, error) {
+	code := strings.ToUpper(p.Args["code"].(string))
+	if len(code) > 3 {
+		var err error
+		if code, err = g.dataset.GetCountryByName(code); err != nil {
+			return nil, nil
+		}
+	}
+	if !g.dataset.HasCountryInRecords(code) {
+		return nil, nil
+	}
+	return graphCountry{code: code, queryType: caching.QueryCountry}, nil
+}
+
+// resolveCountries resolves every country of the dataset. As with the renewables endpoints,
+// listing every country is not recorded as invocations.
+func (g *graphResolver) resolveCountries(graphql.ResolveParams) (interface{}, error) {
+	statistics := g.dataset.GetStatistics()
+	sort.Slice(statistics, func(i, j int) bool { return statistics[i].Isocode < statistics[j].Isocode })
+	countries := make([]graphCountry, 0, len(statistics))
+	for _, statistic := range statistics {
+		countries = append(countries, graphCountry{code: statistic.Isocode})
+	}
+	return countries, nil
+}
+
+// resolveName resolves the full name of a country.
+func (g *graphResolver) resolveName(p graphql.ResolveParams) (interface{}, error) {
+	return g.dataset.GetFullName(p.Source.(graphCountry).code)
+}
+
+// resolveAverage resolves the mean share of renewables of a country.
+func (g *graphResolver) resolveAverage(p graphql.ResolveParams) (interface{}, error) {
+	err, average := g.dataset.GetAverage(p.Source.(graphCountry).code)
+	return average, err
+}
+
+// resolveCurrent resolves the share of renewables of a country in the latest year.
+func (g *graphResolver) resolveCurrent(p graphql.ResolveParams) (interface{}, error) {
+	country := p.Source.(graphCountry)
+	statistic, err := g.dataset.GetStatistic(country.code)
+	if err != nil {
+		return nil, err
+	}
+	g.record(p.Context, country, caching.EndpointCurrent)
+	return statistic, nil
+}
+
+// resolveSeries resolves the yearly shares of renewables of a country, limited to the years
+// from begin to end if given, with missing years filled as set by fill.
+func (g *graphResolver) resolveSeries(p graphql.ResolveParams) (interface{}, error) {
+	country := p.Source.(graphCountry)
+	fill, _ := p.Args["fill"].(string)
+	if err := util.ValidateFill(fill); err != nil {
+		return nil, err
+	}
+	begin, end := g.span(p)
+	g.record(p.Context, country, caching.EndpointHistory)
+	statistics := g.dataset.GetFilledStatisticsRange(country.code, begin, end, fill)
+	if statistics == nil {
+		return []util.RenewableStatistics{}, nil
+	}
+	return statistics, nil
+}
+
+// resolveGaps resolves the years missing in the dataset for a country, limited to the years
+// from begin to end if given.
+func (g *graphResolver) resolveGaps(p graphql.ResolveParams) (interface{}, error) {
+	begin, end := g.span(p)
+	return g.dataset.GetGaps(p.Source.(graphCountry).code, begin, end), nil
+}
+
+// span returns the years of the country from begin to end if given.
+func (g *graphResolver) span(p graphql.ResolveParams) (int, int) {
+	code := p.Source.(graphCountry).code
+	begin, end := g.dataset.GetFirstYear(code), g.dataset.GetLastYear(code)
+	if value, ok := p.Args["begin"].(int); ok {
+		begin = util.Max(begin, value)
+	}
+	if value, ok := p.Args["end"].(int); ok {
+		end = util.Min(end, value)
+	}
+	return begin, end
+}
+
+// resolveNeighbours resolves the neighbours of a country through the cache worker, leaving
+// out neighbours missing in the dataset.
+func (g *graphResolver) resolveNeighbours(p graphql.ResolveParams) (interface{}, error) {
+	country := p.Source.(graphCountry)
+	response := make(chan caching.CacheResponse)
+	g.request <- caching.CacheRequest{Ctx: p.Context, ChannelRef: response, CountryRequest: []string{country.code}}
+	result := <-response
+	if result.Status == http.StatusNotFound {
+		return nil, errors.New("neighbours of " + country.code + " could not be found")
+	}
+	queryType := ""
+	if country.queryType != "" {
+		queryType = caching.QueryNeighbour
+	}
+	neighbours := make([]graphCountry, 0, len(result.Neighbours[country.code]))
+	for _, code := range result.Neighbours[country.code] {
+		if g.dataset.HasCountryInRecords(code) {
+			neighbours = append(neighbours, graphCountry{code: code, queryType: queryType})
+		}
+	}
+	return neighbours, nil
+}
+
+// resolveWebhooks resolves the webhooks registered for a country.
+func (g *graphResolver) resolveWebhooks(p graphql.ResolveParams) (interface{}, error) {
+	if g.cfg.FirestoreClient == nil {
+		return nil, errors.New("notification DB is unavailable")
+	}
+	code := p.Source.(graphCountry).code
+	iter := g.cfg.FirestoreClient.Collection(g.cfg.WebhookCollection).Where("country", "==", code).Documents(p.Context)
+	defer iter.Stop()
+	webhooks := make([]WebhookDisplay, 0)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			util.Logger(p.Context).Error("graphql: failed to read webhooks", "country", code, "error", err)
+			return nil, errors.New("webhooks could not be read")
+		}
+		webhook := WebhookDisplay{}
+		if err = doc.DataTo(&webhook); err != nil {
+			util.Logger(p.Context).Warn("graphql: failed to unmarshal document", "webhook", doc.Ref.ID, "error", err)
+			continue
+		}
+		webhook.WebhookId = doc.Ref.ID
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// record records a lookup of the country as an invocation of the endpoint, unless the country
+// was listed along with every other country.
+func (g *graphResolver) record(ctx context.Context, country graphCountry, endpoint string) {
+	if country.queryType == "" {
+		return
+	}
+	g.invocation.Record([]caching.Invocation{
+		{Ctx: ctx, Endpoint: endpoint, Country: country.code, QueryType: country.queryType},
+	})
+}
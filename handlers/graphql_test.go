@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"Assignment2/caching"
+	"Assignment2/consts"
+	"Assignment2/util"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// graphQLResponse is the body of responses from the GraphQL endpoint.
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func TestHandlerGraphQL(t *testing.T) {
+	var dataset util.CountryDataset
+	if err := dataset.Initialize("." + consts.DataSetPath); err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{}
+	config.InitializeWithDefaults()
+	config.GraphQLMaxDepth = 4
+
+	// Stands in for the cache worker, answering with fixed neighbours.
+	requests := make(chan caching.CacheRequest)
+	defer close(requests)
+	go func() {
+		for request := range requests {
+			request.ChannelRef <- caching.CacheResponse{
+				Neighbours: map[string][]string{"NOR": {"FIN", "RUS", "SWE", "XKX"}},
+			}
+		}
+	}()
+	invocations := caching.NewInvocationRecorder(10)
+	handler, err := HandlerGraphQL(&config, requests, &dataset, invocations)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	post := func(query string) (int, graphQLResponse) {
+		body, _ := json.Marshal(GraphQLRequest{Query: query})
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, consts.GraphQLPath, strings.NewReader(string(body))))
+		response := graphQLResponse{}
+		if recorder.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&response))
+		}
+		return recorder.Code, response
+	}
+
+	status, response := post(`{ country(code: "norway") { code name series(begin: 2020) { year percentage } } }`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"code": "NOR", "name": "Norway", "series": [
+		{"year": 2020, "percentage": 70.96305847167969},
+		{"year": 2021, "percentage": 71.55836486816406}]}`, string(response.Data["country"]))
+	assert.Equal(t, 1, invocations.Queued())
+
+	// Neighbours missing in the dataset are left out.
+	_, response = post(`{ country(code: "NOR") { neighbours { code current { year } } } }`)
+	assert.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"neighbours": [
+		{"code": "FIN", "current": {"year": 2021}},
+		{"code": "RUS", "current": {"year": 2021}},
+		{"code": "SWE", "current": {"year": 2021}}]}`, string(response.Data["country"]))
+	assert.Equal(t, 4, invocations.Queued())
+
+	_, response = post(`{ country(code: "XYZ") { code } }`)
+	assert.Empty(t, response.Errors)
+	assert.JSONEq(t, `null`, string(response.Data["country"]))
+
+	_, response = post(`{ countries { code } }`)
+	countries := make([]map[string]string, 0)
+	if assert.Nil(t, json.Unmarshal(response.Data["countries"], &countries)) {
+		_, length := dataset.GetLengthOfDataset()
+		assert.Len(t, countries, length)
+		assert.Equal(t, "ARE", countries[0]["code"])
+	}
+	assert.Equal(t, 4, invocations.Queued())
+
+	// Without a DB client, the webhooks fail alone.
+	_, response = post(`{ country(code: "NOR") { code webhooks { id } } }`)
+	assert.Len(t, response.Errors, 1)
+
+	refused := []struct {
+		name  string
+		query string
+	}{
+		{"too deep", `{ country(code: "NOR") { neighbours { neighbours { current { year } } } } }`},
+		{"too deep through fragments",
+			`{ country(code: "NOR") { ...near } } fragment near on Country { neighbours { neighbours { current { year } } } }`},
+		{"neighbours of every country", `{ countries { neighbours { code } } }`},
+		{"webhooks of every country through aliases",
+			`{ a: countries { webhooks { id } } b: countries { webhooks { id } } }`},
+		{"aliased lookups", `{ ` + aliasedLookups(20) + ` }`},
+		{"fragments spread exponentially", `{ countries { ...f0 } } ` + spreadFragments(30)},
+		{"fragments spread beyond overflow", `{ countries { ...f0 } } ` + spreadFragments(200)},
+		{"unknown field", `{ country(code: "NOR") { population } }`},
+		{"syntax error", `{ country(code: "NOR" { code } }`},
+	}
+	for _, test := range refused {
+		status, response = post(test.query)
+		assert.Equal(t, http.StatusOK, status, test.name)
+		assert.Nil(t, response.Data["country"], test.name)
+		assert.NotEmpty(t, response.Errors, test.name)
+	}
+
+	// Fields of every country of the dataset are allowed, as long as they are not looked up.
+	_, response = post(`{ countries { code name average current { year percentage } series { year percentage } } }`)
+	assert.Empty(t, response.Errors)
+
+	// Introspection fields are not counted towards the depth.
+	_, response = post(`{ __schema { queryType { fields { type { ofType { name } } } } } }`)
+	assert.Empty(t, response.Errors)
+
+	recorder := httptest.NewRecorder()
+	query := url.Values{"query": {`query Named($code: String!) { country(code: $code) { code } }`},
+		"variables": {`{"code": "SWE"}`}}
+	handler(recorder, httptest.NewRequest(http.MethodGet, consts.GraphQLPath+"?"+query.Encode(), nil))
+	if assert.Equal(t, http.StatusOK, recorder.Code) {
+		assert.JSONEq(t, `{"data": {"country": {"code": "SWE"}}}`, recorder.Body.String())
+	}
+
+	status, _ = post("")
+	assert.Equal(t, http.StatusBadRequest, status)
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodPost, consts.GraphQLPath, strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, util.ProblemContentType, recorder.Header().Get("content-type"))
+}
+
+func TestGraphQLFill(t *testing.T) {
+	var dataset util.CountryDataset
+	files := fstest.MapFS{"dataset.csv": {Data: []byte("Entity,Code,Year,Renewables\n" +
+		"Norway,NOR,2000,10\nNorway,NOR,2001,20\nNorway,NOR,2004,50\nNorway,NOR,2005,60\n")}}
+	if err := dataset.InitializeFS(files, "dataset.csv"); err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{}
+	config.InitializeWithDefaults()
+	handler, err := HandlerGraphQL(&config, make(chan caching.CacheRequest), &dataset, caching.NewInvocationRecorder(10))
+	if !assert.Nil(t, err) {
+		return
+	}
+	post := func(query string) graphQLResponse {
+		body, _ := json.Marshal(GraphQLRequest{Query: query})
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, consts.GraphQLPath, strings.NewReader(string(body))))
+		response := graphQLResponse{}
+		assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&response))
+		return response
+	}
+
+	response := post(`{ country(code: "NOR") { gaps series(begin: 2001, end: 2004) { year percentage filled } } }`)
+	assert.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"gaps": [2002, 2003], "series": [
+		{"year": 2001, "percentage": 20, "filled": false},
+		{"year": 2004, "percentage": 50, "filled": false}]}`, string(response.Data["country"]))
+
+	response = post(`{ country(code: "NOR") { gaps(end: 2002) series(begin: 2001, end: 2003, fill: "linear") { year percentage filled } } }`)
+	assert.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"gaps": [2002], "series": [
+		{"year": 2001, "percentage": 20, "filled": false},
+		{"year": 2002, "percentage": 30, "filled": true},
+		{"year": 2003, "percentage": 40, "filled": true}]}`, string(response.Data["country"]))
+
+	response = post(`{ country(code: "NOR") { series(fill: "next") { year } } }`)
+	assert.NotEmpty(t, response.Errors)
+}
+
+// aliasedLookups returns a selection of the webhooks of a country under each of n aliases.
+func aliasedLookups(n int) string {
+	selections := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		selections = append(selections, "c"+strconv.Itoa(i)+`: country(code: "NOR") { webhooks { id } }`)
+	}
+	return strings.Join(selections, " ")
+}
+
+// spreadFragments returns n fragments, each spreading the next twice, on top of a single field.
+func spreadFragments(n int) string {
+	fragments := make([]string, 0, n+1)
+	for i := 0; i < n; i++ {
+		next := "f" + strconv.Itoa(i+1)
+		fragments = append(fragments, "fragment f"+strconv.Itoa(i)+" on Country { ...", next, " ...", next, " } ")
+	}
+	return strings.Join(fragments, "") + "fragment f" + strconv.Itoa(n) + " on Country { code }"
+}
@@ -1,37 +1,75 @@
 package handlers
 
 import (
+	"Assignment2/caching"
 	"Assignment2/consts"
+	"Assignment2/events"
 	"Assignment2/fsutils"
+	"Assignment2/router"
+	"Assignment2/tracing"
 	"Assignment2/util"
+	"bytes"
+	"cloud.google.com/go/firestore"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"log"
+	"io"
 	"net/http"
 	"strings"
 	"time"
 )
 
-// NotificationHandler The handler for the notification endpoint
-func NotificationHandler(cfg *util.Config, countryDB *util.CountryDataset) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("content-type", "application/json")
-		client := &http.Client{Timeout: 10 * time.Second}
-		defer client.CloseIdleConnections()
-
-		ctx := &util.HandlerContext{Name: "Notification handler: ", Writer: &w, Client: client}
-
-		switch r.Method {
-		case http.MethodPost:
-			registerWebhook(ctx, cfg, r, countryDB)
-		case http.MethodGet:
-			viewWebhooks(ctx, cfg, r)
-		case http.MethodDelete:
-			deleteWebhook(ctx, cfg, r)
-		}
+// Actions available on a registered webhook
+const pauseAction = "pause"
+const resumeAction = "resume"
+
+// Webhook verification
+const challengeType = "verification"
+const challengeLength = 16            // bytes of randomness in a challenge token
+const maxChallengeResponseSize = 1024 // bytes read from a receiver's challenge response
+
+// paramWebhookID is the path parameter holding the ID of a webhook.
+const paramWebhookID = "id"
+
+// ErrInvalidWebhook is returned by RegisterWebhook for registrations with non-valid values.
+var ErrInvalidWebhook = errors.New("non-valid values in webhook registration")
+
+// ErrVerificationFailed is wrapped by errors of RegisterWebhook for receivers failing verification.
+var ErrVerificationFailed = errors.New("webhook receiver failed verification")
+
+// NotificationHandler Routes of the notification endpoint, for registering, viewing, deleting,
+// pausing and resuming webhooks, and for streaming the events published to the broker
+func NotificationHandler(cfg *util.Config, countryDB *util.CountryDataset, broker *events.Broker) *router.Router {
+	webhookPath := consts.NotificationPath + "{" + paramWebhookID + "}"
+	rt := router.New()
+	rt.HandleFunc(http.MethodPost, consts.NotificationPath, func(w http.ResponseWriter, r *http.Request) {
+		registerWebhook(w, r, cfg, countryDB)
+	})
+	rt.HandleFunc(http.MethodGet, consts.NotificationPath, func(w http.ResponseWriter, r *http.Request) {
+		viewWebhooks(w, r, cfg)
+	})
+	rt.HandleFunc(http.MethodGet, consts.NotificationPath+streamPath, func(w http.ResponseWriter, r *http.Request) {
+		streamEvents(w, r, countryDB, broker)
+	})
+	rt.HandleFunc(http.MethodGet, webhookPath, func(w http.ResponseWriter, r *http.Request) {
+		viewWebhook(w, r, cfg, router.Param(r, paramWebhookID))
+	})
+	rt.HandleFunc(http.MethodDelete, webhookPath, func(w http.ResponseWriter, r *http.Request) {
+		deleteWebhook(w, r, cfg, router.Param(r, paramWebhookID))
+	})
+	for _, action := range []string{pauseAction, resumeAction} {
+		action := action
+		rt.HandleFunc(http.MethodPost, webhookPath+"/"+action, func(w http.ResponseWriter, r *http.Request) {
+			changeWebhookState(w, r, cfg, router.Param(r, paramWebhookID), action)
+		})
 	}
+	return rt
 }
 
 // registerWebhook takes a request on the form
@@ -42,7 +80,10 @@ func NotificationHandler(cfg *util.Config, countryDB *util.CountryDataset) func(
 //	{
 //	   "url": "https://localhost:8080/client/",
 //	   "country": "NOR",
-//	   "calls": 5 <-- should trigger every five calls
+//	   "endpoint": "history", <-- optional, only counts calls to the given endpoint
+//	   "calls": 5, <-- should trigger every five calls
+//	   "expires": "2024-01-01T00:00:00Z", <-- optional, RFC 3339
+//	   "batch": true <-- optional, one message listing all thresholds passed per check
 //	}
 //
 // and provides a response upon a successful registration in the firebase DB:
@@ -50,105 +91,232 @@ func NotificationHandler(cfg *util.Config, countryDB *util.CountryDataset) func(
 //	{
 //	    "webhook_id": "<doc_ID_here>"
 //	}
-func registerWebhook(handler *util.HandlerContext, cfg *util.Config, r *http.Request, countryDB *util.CountryDataset) {
+func registerWebhook(w http.ResponseWriter, r *http.Request, cfg *util.Config, countryDB *util.CountryDataset) {
 	decoder := json.NewDecoder(r.Body)
-	webhook := WebhookRegistration{}
-	webhookIsValid := true
-	st := http.StatusOK
-	if err := decoder.Decode(&webhook); err != nil {
-		webhookIsValid = false
-		st = http.StatusBadRequest
-	} else {
-		webhook.Country = strings.ToUpper(webhook.Country)
-		countryValid := countryDB.HasCountryInRecords(webhook.Country)
-		if !countryValid {
-			cca3, err := countryDB.GetCountryByName(webhook.Country)
-			if err == nil {
-				webhook.Country = cca3
-				countryValid = true
-			}
-		}
-		webhookIsValid =
-			(countryValid || webhook.Country == "") &&
-				validateURL(webhook.URL) && webhook.Calls != 0
-		if !webhookIsValid {
-			st = http.StatusUnprocessableEntity
+	request := Webhook{}
+	if err := decoder.Decode(&request); err != nil {
+		writeRegistrationProblem(w, r, http.StatusBadRequest)
+		return
+	}
+	newWebhookID, err := RegisterWebhook(r.Context(), cfg, countryDB, request)
+	switch {
+	case errors.Is(err, ErrInvalidWebhook):
+		writeRegistrationProblem(w, r, http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrVerificationFailed):
+		util.WriteProblem(w, r, http.StatusUnprocessableEntity, util.ProblemVerificationFailed, err.Error())
+	case err != nil:
+		util.WriteProblem(w, r, http.StatusInternalServerError, util.ProblemInternalServerError,
+			"webhook is valid, but registration failed due to an unexpected error")
+	default:
+		w.Header().Set("content-type", "application/json")
+		util.EncodeAndWriteResponse(&w, WebhookRegResp{newWebhookID})
+	}
+}
+
+// writeRegistrationProblem responds to a malformed or non-valid webhook registration,
+// describing the expected body.
+func writeRegistrationProblem(w http.ResponseWriter, r *http.Request, status int) {
+	errorMsg :=
+		"Malformed request body or non-valid values.\n Expected json format is:\n\n" +
+			"{\n" +
+			"    \"url\": \"https://localhost:8080/client/\",\n" +
+			"    \"country\": \"NOR\",\n" +
+			"    \"endpoint\": \"history\",\n" +
+			"    \"calls\": 5,\n" +
+			"    \"expires\": \"2024-01-01T00:00:00Z\",\n" +
+			"    \"batch\": true\n" +
+			"}\n\n" +
+			"Zero value for calls is not permitted. Must be 1 and above.\n" +
+			"URL must be an absolute http or https url, and may not point to a private address.\n" +
+			"Country must either be a valid cca3 code, the full country name, or an empty string.\n" +
+			"An empty country field will cause any country invocation to count up calls.\n" +
+			"Endpoint is optional. If set to 'current', 'history' or 'forecast', only calls to that endpoint count up calls.\n" +
+			"Expires is optional, but must be a RFC 3339 timestamp in the future if present.\n" +
+			"Batch is optional. If true, all thresholds passed since the last check are sent in one message."
+	util.WriteProblem(w, r, status, util.ProblemInvalidWebhook, errorMsg)
+}
+
+// RegisterWebhook validates the webhook and stores it in the DB, after verifying its receiver
+// if required by config. Countries may be given by name, and are stored by their cca3 code.
+//
+// On success: ID of the registered webhook, nil
+// On failure: "", ErrInvalidWebhook, an error wrapping ErrVerificationFailed, or the error of the DB
+func RegisterWebhook(ctx context.Context, cfg *util.Config, countryDB *util.CountryDataset, request Webhook) (string, error) {
+	webhook := WebhookRegistration{
+		URL:      request.URL,
+		Country:  strings.ToUpper(request.Country),
+		Endpoint: strings.ToLower(request.Endpoint),
+		Calls:    request.Calls,
+		Expires:  request.Expires,
+		Batch:    request.Batch,
+	}
+	countryValid := countryDB.HasCountryInRecords(webhook.Country)
+	if !countryValid {
+		cca3, err := countryDB.GetCountryByName(webhook.Country)
+		if err == nil {
+			webhook.Country = cca3
+			countryValid = true
 		}
 	}
+	webhookIsValid :=
+		(countryValid || webhook.Country == "") &&
+			validateURL(cfg, webhook.URL) && webhook.Calls >= 1 &&
+			caching.IsValidEndpoint(webhook.Endpoint) &&
+			(webhook.Expires == nil || webhook.Expires.After(time.Now()))
+	if !webhookIsValid {
+		return "", ErrInvalidWebhook
+	}
 
-	if webhookIsValid {
-		newWebhookID, err := fsutils.AddDocument(cfg, cfg.WebhookCollection, &webhook)
-		if err != nil {
-			http.Error(*handler.Writer,
-				"Webhook is valid, but registration failed due to an unexpected error.",
-				http.StatusInternalServerError)
-			return
+	if cfg.WebhookVerification {
+		client := tracing.InstrumentClient(util.NewWebhookClient(cfg))
+		if err := verifyWebhookReceiver(ctx, client, webhook.URL); err != nil {
+			return "", fmt.Errorf("%w: %s", ErrVerificationFailed, err)
 		}
-		util.EncodeAndWriteResponse(handler.Writer, WebhookRegResp{newWebhookID})
-	} else {
-		errorMsg :=
-			"Malformed request body or non-valid values.\n Expected json format is:\n\n" +
-				"{\n" +
-				"    \"url\": \"https://localhost:8080/client/\",\n" +
-				"    \"country\": \"NOR\",\n" +
-				"    \"calls\": 5\n" +
-				"}\n\n" +
-				"Zero value for calls is not permitted. Must be 1 and above.\n" +
-				"Country must either be a valid cca3 code, the full country name, or an empty string.\n" +
-				"An empty country field will cause any country invocation to count up calls."
-		http.Error(*handler.Writer, errorMsg, st)
-		return
 	}
+	return fsutils.AddDocument(cfg, cfg.WebhookCollection, &webhook)
 }
 
 // validateURL validates the url of an incoming webhook registration.
-// currently it only checks that it's not an empty string.
-func validateURL(url string) bool {
-	return url != ""
+// See util.ValidateWebhookURL for the requirements placed on the url.
+func validateURL(cfg *util.Config, url string) bool {
+	return util.ValidateWebhookURL(url, cfg.AllowPrivateWebhooks) == nil
+}
+
+// verifyWebhookReceiver sends a challenge to the webhook url. The receiver must echo
+// the challenge in the body of a 2xx response for the registration to be accepted:
+//
+//	{
+//	    "challenge": "<challenge_here>"
+//	}
+//
+// On success: nil
+// On failure: error describing why the receiver failed verification
+func verifyWebhookReceiver(ctx context.Context, client *http.Client, url string) error {
+	token := make([]byte, challengeLength)
+	if _, err := rand.Read(token); err != nil {
+		return errors.New("unable to generate challenge")
+	}
+	challenge := WebhookChallenge{Type: challengeType, Challenge: hex.EncodeToString(token)}
+	payload, err := json.Marshal(challenge)
+	if err != nil {
+		return errors.New("unable to generate challenge")
+	}
+	// Details of connection errors are left out, as they could reveal internal network layout.
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return errors.New("unable to reach receiver")
+	}
+	request.Header.Set("content-type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return errors.New("unable to reach receiver")
+	}
+	defer response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return errors.New("receiver responded with " + response.Status)
+	}
+	echo := WebhookChallenge{}
+	decoder := json.NewDecoder(io.LimitReader(response.Body, maxChallengeResponseSize))
+	if err = decoder.Decode(&echo); err != nil || echo.Challenge != challenge.Challenge {
+		return errors.New("receiver did not echo the challenge")
+	}
+	return nil
 }
 
 // deleteWebhook takes a request on the form
 // Method: DELETE
 // Path: /energy/v1/notifications/{id},
 // and deletes a webhook if it is correctly identified.
-func deleteWebhook(handler *util.HandlerContext, cfg *util.Config, r *http.Request) {
-	segments := util.FragmentsFromPath(r.URL.Path, consts.NotificationPath)
-	if len(segments) != 1 {
-		http.Error(*handler.Writer,
-			"Not a valid path. For deletion, use /energy/v1/notifications/{id}",
-			http.StatusBadRequest,
-		)
+func deleteWebhook(w http.ResponseWriter, r *http.Request, cfg *util.Config, id string) {
+	if err := DeleteWebhook(cfg, id); err != nil {
+		writeWebhookProblem(w, r, err, id)
 		return
 	}
-	_, err := fsutils.ReadDocument(cfg, cfg.WebhookCollection, segments[0])
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteWebhook deletes the webhook from the DB.
+//
+// On success: nil
+// On failure: error with code NotFound if no webhook has the id, otherwise the error of the DB
+func DeleteWebhook(cfg *util.Config, id string) error {
+	if _, err := fsutils.ReadDocument(cfg, cfg.WebhookCollection, id); err != nil {
+		return err
+	}
+	// Error indicates a failure to communicate with DB. Document not existing returns no error.
+	return fsutils.DeleteDocument(cfg, cfg.WebhookCollection, id)
+}
+
+// changeWebhookState takes a request on the form
+// Method: POST
+// Path: /energy/v1/notifications/{id}/{pause|resume}
+// and pauses or resumes the identified webhook. Paused webhooks keep counting
+// invocations, but are not triggered. Resuming a webhook also resets its count
+// of failed deliveries. The updated webhook is returned in the response body.
+func changeWebhookState(w http.ResponseWriter, r *http.Request, cfg *util.Config, id string, action string) {
+	webhookEntry, err := SetWebhookPaused(cfg, id, action == pauseAction)
 	if err != nil {
-		if status.Code(err) == codes.NotFound {
-			http.Error(*handler.Writer,
-				"No webhook deleted",
-				http.StatusNotFound, // Document doesn't exist.
-			)
-		} else {
-			http.Error(*handler.Writer,
-				"Something went wrong...",
-				http.StatusInternalServerError, // Firestore interaction failed.
-			)
-		}
+		writeWebhookProblem(w, r, err, id)
 		return
 	}
-	if err := fsutils.DeleteDocument(cfg, cfg.WebhookCollection, segments[0]); err != nil {
-		http.Error(*handler.Writer,
-			"Something went wrong...",
-			http.StatusInternalServerError, // Error indicates a failure to communicate
-		) // with DB. Document not existing returns no error.
+	w.Header().Set("content-type", "application/json")
+	util.EncodeAndWriteResponse(&w, webhookEntry)
+}
+
+// SetWebhookPaused pauses or resumes the webhook. Resuming a webhook also resets its count
+// of failed deliveries.
+//
+// On success: the updated webhook, nil
+// On failure: error with code NotFound if no webhook has the id, otherwise the error of the DB
+func SetWebhookPaused(cfg *util.Config, id string, paused bool) (WebhookDisplay, error) {
+	updates := []firestore.Update{{Path: "paused", Value: paused}}
+	if !paused {
+		updates = append(updates, firestore.Update{Path: "failures", Value: 0})
+	}
+	if err := fsutils.UpdateDocument(cfg, cfg.WebhookCollection, id, updates); err != nil {
+		return WebhookDisplay{}, err
+	}
+	return ReadWebhook(cfg, id)
+}
+
+// viewWebhook takes a request on the form
+// Method: GET
+// Path: /energy/v1/notifications/{id}
+// with a response
+//
+//	{
+//	   "webhook_id": "OIdksUDwveiwe",
+//	   "url": "https://localhost:8080/client/",
+//	   "country": "NOR",
+//	   "calls": 5
+//	}
+func viewWebhook(w http.ResponseWriter, r *http.Request, cfg *util.Config, id string) {
+	webhookEntry, err := ReadWebhook(cfg, id)
+	if err != nil {
+		writeWebhookProblem(w, r, err, id)
 		return
 	}
-	http.Error(*handler.Writer, "", http.StatusOK)
+	w.Header().Set("content-type", "application/json")
+	util.EncodeAndWriteResponse(&w, webhookEntry)
+}
+
+// ReadWebhook reads the webhook from the DB.
+//
+// On success: the webhook, nil
+// On failure: error with code NotFound if no webhook has the id, otherwise the error of the DB
+func ReadWebhook(cfg *util.Config, id string) (WebhookDisplay, error) {
+	webhookEntry := WebhookDisplay{}
+	if err := fsutils.ReadDocumentGeneral(cfg, cfg.WebhookCollection, id, &webhookEntry); err != nil {
+		return WebhookDisplay{}, err
+	}
+	webhookEntry.WebhookId = id
+	return webhookEntry, nil
 }
 
 // viewWebhooks takes a request on the form
 // Method: GET
-// Path: /energy/v1/notifications/{id?}
-// with a response
+// Path: /energy/v1/notifications/
+// with a response listing every registered webhook
 // [
 //
 //	{
@@ -160,57 +328,57 @@ func deleteWebhook(handler *util.HandlerContext, cfg *util.Config, r *http.Reque
 //	...
 //
 // ]
-// in the case of a provided ID, only a single result will be shown.
-func viewWebhooks(handler *util.HandlerContext, cfg *util.Config, r *http.Request) {
-	segments := util.FragmentsFromPath(r.URL.Path, consts.NotificationPath)
-	if len(segments) == 1 {
-		id := segments[0]
-		webhookEntry := WebhookDisplay{}
-		err := fsutils.ReadDocumentGeneral(cfg, cfg.WebhookCollection, id, &webhookEntry)
-		if err != nil {
-			if status.Code(err) == codes.NotFound {
-				http.Error(*handler.Writer,
-					"",
-					http.StatusNotFound, // Document doesn't exist.
-				)
-			} else {
-				http.Error(*handler.Writer,
-					"Something went wrong...",
-					http.StatusInternalServerError, // Firestore interaction failed.
-				)
-			}
-			return
-		}
-		webhookEntry.WebhookId = id
-		util.EncodeAndWriteResponse(handler.Writer, webhookEntry)
+func viewWebhooks(w http.ResponseWriter, r *http.Request, cfg *util.Config) {
+	entries, err := ListWebhooks(r.Context(), cfg)
+	if err != nil {
+		writeWebhookProblem(w, r, err, "")
 		return
-	} else if len(segments) == 0 {
-		iter := cfg.FirestoreClient.Collection(cfg.WebhookCollection).Documents(*cfg.Ctx)
-		entries := make([]WebhookDisplay, 0)
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break
-			}
-			webhookEntry := WebhookDisplay{}
-			if err = doc.DataTo(&webhookEntry); err != nil {
-				log.Printf("Failed to unmarshal document %v: %v", doc.Ref.ID, err)
-				continue
-			}
-			webhookEntry.WebhookId = doc.Ref.ID
-			entries = append(entries, webhookEntry)
+	}
+	if len(entries) == 0 {
+		util.WriteProblem(w, r, http.StatusNotFound, util.ProblemWebhookNotFound, "no webhooks registered")
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	util.EncodeAndWriteResponse(&w, entries)
+}
+
+// ListWebhooks reads every registered webhook from the DB, skipping documents that can't be read
+// as webhooks.
+//
+// On success: the webhooks, nil
+// On failure: nil, the error of the DB
+func ListWebhooks(ctx context.Context, cfg *util.Config) ([]WebhookDisplay, error) {
+	iter := cfg.FirestoreClient.Collection(cfg.WebhookCollection).Documents(ctx)
+	defer iter.Stop()
+	entries := make([]WebhookDisplay, 0)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
 		}
-		if len(entries) == 0 {
-			http.Error(*handler.Writer,
-				"",
-				http.StatusNotFound, // Error indicates a failure to communicate
-			) // with DB. Document not existing returns no error.
+		webhookEntry := WebhookDisplay{}
+		if err = doc.DataTo(&webhookEntry); err != nil {
+			util.Logger(ctx).Warn("notification handler: failed to unmarshal document",
+				"webhook", doc.Ref.ID, "error", err)
+			continue
 		}
-		util.EncodeAndWriteResponse(handler.Writer, entries)
-	} else {
-		http.Error(*handler.Writer,
-			"Invalid path.",
-			http.StatusBadRequest, // Error indicates a failure to communicate
-		) // with DB. Document not existing returns no error.
+		webhookEntry.WebhookId = doc.Ref.ID
+		entries = append(entries, webhookEntry)
+	}
+	return entries, nil
+}
+
+// writeWebhookProblem responds with 404 Not Found if the error shows the webhook doesn't exist,
+// and with 500 Internal Server Error for any other failure of the firestore interaction.
+func writeWebhookProblem(w http.ResponseWriter, r *http.Request, err error, id string) {
+	if status.Code(err) == codes.NotFound {
+		util.WriteProblem(w, r, http.StatusNotFound, util.ProblemWebhookNotFound, "no webhook with id "+id)
+		return
 	}
+	util.Logger(r.Context()).Error("notification handler: firestore interaction failed", "webhook", id, "error", err)
+	util.WriteProblem(w, r, http.StatusInternalServerError, util.ProblemInternalServerError,
+		"something went wrong, try again later")
 }
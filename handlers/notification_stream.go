@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"Assignment2/events"
+	"Assignment2/util"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamPath is the path of the event stream, relative to the notification endpoint.
+const streamPath = "stream"
+
+// keepAliveInterval is the interval between comments sent on idle streams, keeping proxies
+// from closing the connection.
+const keepAliveInterval = 15 * time.Second
+
+// retryInterval is the delay clients are asked to wait before reconnecting a closed stream.
+const retryInterval = 5 * time.Second
+
+// lastEventIDHeader is sent by clients reconnecting a stream, holding the ID of the last
+// event received.
+const lastEventIDHeader = "Last-Event-ID"
+
+// streamEvents takes a request on the form
+// Method: GET
+// Path: /energy/v1/notifications/stream?country=NOR
+// and responds with a stream of server-sent events of the country, or of every country if
+// no country is given:
+//
+//	id: 12
+//	event: threshold
+//	data: {"webhook_id":"OIdksUDwveiwe","country":"NOR","endpoint":"current","calls":10,"thresholds":[10]}
+//
+//	id: 13
+//	event: dataset
+//	data: {"name":"Norway","isocode":"NOR","year":2021,"percentage":71.55,"average":68.01}
+//
+// Threshold events are sent whenever a webhook passes a multiple of its calls, as passed on
+// through the DB by the instance delivering webhook events, and dataset events whenever a
+// reload of the dataset changes the values of a country. Events of webhooks
+// registered to any country are only sent on streams of every country. Reconnecting clients
+// are sent the events they missed, as long as these are still kept by the broker.
+func streamEvents(w http.ResponseWriter, r *http.Request, countryDB *util.CountryDataset, broker *events.Broker) {
+	country := strings.ToUpper(r.URL.Query().Get("country"))
+	if len(country) > 3 {
+		var err error
+		if country, err = countryDB.GetCountryByName(country); err != nil {
+			util.WriteProblem(w, r, http.StatusNotFound, util.ProblemCountryNotFound,
+				"no country with that name in dataset")
+			return
+		}
+	}
+	if country != "" && !countryDB.HasCountryInRecords(country) {
+		util.WriteProblem(w, r, http.StatusNotFound, util.ProblemCountryNotFound,
+			"code misspelled or country not in dataset")
+		return
+	}
+	lastID, _ := strconv.ParseUint(r.Header.Get(lastEventIDHeader), 10, 64)
+
+	controller := http.NewResponseController(w)
+	stream, cancel := broker.Subscribe(country, lastID)
+	defer cancel()
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte("retry: " + strconv.FormatInt(retryInterval.Milliseconds(), 10) + "\n\n"))
+	if err == nil {
+		err = controller.Flush()
+	}
+	if err != nil {
+		util.Logger(r.Context()).Warn("notification stream: streaming is not supported", "error", err)
+		return
+	}
+	util.Logger(r.Context()).Debug("notification stream: subscribed", "country", country, "last_event_id", lastID)
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var message []byte
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			message = []byte(": keep-alive\n\n")
+		case event, ok := <-stream:
+			if !ok {
+				return // dropped by the broker, letting the client reconnect and catch up
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				util.Logger(r.Context()).Error("notification stream: failed to encode event", "error", err)
+				continue
+			}
+			message = []byte("id: " + strconv.FormatUint(event.ID, 10) + "\nevent: " + event.Type +
+				"\ndata: " + string(data) + "\n\n")
+		}
+		if _, err = w.Write(message); err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
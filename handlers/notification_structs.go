@@ -1,30 +1,56 @@
 package handlers
 
+import "time"
+
 // Webhook provides the json structure for the expected request
-// body of a webhook registration.
+// body of a webhook registration. Endpoint, Expires and Batch are optional.
 type Webhook struct {
-	URL     string `json:"url"`
-	Country string `json:"country"`
-	Calls   int32  `json:"calls"`
+	URL      string     `json:"url"`
+	Country  string     `json:"country"`
+	Endpoint string     `json:"endpoint,omitempty"`
+	Calls    int32      `json:"calls"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	Batch    bool       `json:"batch,omitempty"`
 }
 
+// WebhookDisplay provides the json structure of a registered webhook
+// as shown to users.
 type WebhookDisplay struct {
-	WebhookId string `json:"webhook_id"`
-	URL       string `json:"url"`
-	Country   string `json:"country"`
-	Calls     int32  `json:"calls"`
+	WebhookId string     `json:"webhook_id"`
+	URL       string     `json:"url"`
+	Country   string     `json:"country"`
+	Endpoint  string     `json:"endpoint,omitempty"`
+	Calls     int32      `json:"calls"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	Batch     bool       `json:"batch,omitempty"`
+	Paused    bool       `json:"paused"`
 }
 
 // WebhookRegistration provides the document structure of a
 // webhook registration. Count is the invocation
-// count for the country since the registration of the webhook.
+// count for the country since the registration of the webhook,
+// only counting invocations of Endpoint if it is set.
+// Failures is the number of consecutive failed deliveries, and
+// a webhook is Paused once it reaches the limit set in config.
+// Batch webhooks receive one message per check listing all
+// thresholds passed, rather than one message per threshold.
+//
+// DeliveredCount is the count up to which events have been delivered,
+// and Pending is set while Count exceeds it.
 //
-// WARNING: Count MUST be updated in DB on an invocation check.
+// WARNING: Count MUST only be updated in DB through atomic increments.
 type WebhookRegistration struct {
-	URL     string `firestore:"url"`
-	Country string `firestore:"country"`
-	Calls   int32  `firestore:"calls"`
-	Count   int32  `firestore:"call_count"`
+	URL            string     `firestore:"url"`
+	Country        string     `firestore:"country"`
+	Endpoint       string     `firestore:"endpoint"`
+	Calls          int32      `firestore:"calls"`
+	Count          int32      `firestore:"call_count"`
+	DeliveredCount int32      `firestore:"delivered_count"`
+	Pending        bool       `firestore:"pending"`
+	Expires        *time.Time `firestore:"expires,omitempty"`
+	Batch          bool       `firestore:"batch"`
+	Paused         bool       `firestore:"paused"`
+	Failures       int32      `firestore:"failures"`
 }
 
 // WebhookRegResp provides the json structure of the response body
@@ -32,3 +58,11 @@ type WebhookRegistration struct {
 type WebhookRegResp struct {
 	WebhookId string `json:"webhook_id"`
 }
+
+// WebhookChallenge provides the json structure of the verification message sent
+// to a webhook url upon registration, as well as the structure of the response
+// expected from the receiver.
+type WebhookChallenge struct {
+	Type      string `json:"type,omitempty"`
+	Challenge string `json:"challenge"`
+}
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"Assignment2/consts"
+	"Assignment2/events"
 	"Assignment2/fsutils"
 	"Assignment2/util"
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"io"
@@ -27,8 +30,8 @@ func TestNotificationHandler(t *testing.T) {
 		return
 	}
 
-	handler := NotificationHandler(&config, &countryDB)
-	server := httptest.NewServer(http.HandlerFunc(handler))
+	handler := NotificationHandler(&config, &countryDB, events.NewBroker())
+	server := httptest.NewServer(handler)
 	defer server.Close()
 	client := http.Client{}
 	defer client.CloseIdleConnections()
@@ -53,13 +56,21 @@ func TestNotificationHandler(t *testing.T) {
 		response.Status,
 	)
 
+	// Sending negative calls, which could never trigger the webhook
+	bytestream, _ := json.Marshal(Webhook{URL: "https://tullogtoys.crumb", Country: "NOR", Calls: -5})
+	response, err = doRequest(http.MethodPost, consts.NotificationPath, bytes.NewReader(bytestream))
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, util.StatusToString(http.StatusBadRequest), response.Status)
+
 	// Sending correct body
 	testWebhook := Webhook{
 		URL:     "https://tullogtoys.crumb",
 		Country: "NOR",
 		Calls:   5,
 	}
-	bytestream, err := json.Marshal(testWebhook)
+	bytestream, err = json.Marshal(testWebhook)
 	if err != nil {
 		t.Error(err)
 	}
@@ -108,13 +119,13 @@ func TestNotificationHandler(t *testing.T) {
 	}
 	assert.Equal(t, util.StatusToString(http.StatusNotFound), response.Status)
 
-	// Attempts to delete with non-valid path
-	// Attempts to delete again, should result in 404
+	// Attempts to delete with non-valid path, should result in 404
 	response, err = doRequest(http.MethodDelete, pathWithID+"/testpath", nil)
 	if err != nil {
 		t.Error(err)
 	}
-	assert.Equal(t, util.StatusToString(http.StatusBadRequest), response.Status)
+	assert.Equal(t, util.StatusToString(http.StatusNotFound), response.Status)
+	assert.Equal(t, util.ProblemContentType, response.Header.Get("content-type"))
 	// Attempts to show the now deleted webhook
 	response, err = doRequest(http.MethodGet, pathWithID, nil)
 	if err != nil {
@@ -130,12 +141,19 @@ func TestNotificationHandler(t *testing.T) {
 	}
 	assert.Equal(t, util.StatusToString(http.StatusNotFound), response.Status)
 
-	// Uses an invalid path, should result in 400 Bad Request
+	// Uses an invalid path, should result in 404 Not Found
 	response, err = doRequest(http.MethodGet, consts.NotificationPath+"/err/or", nil)
 	if err != nil {
 		t.Error(err)
 	}
-	assert.Equal(t, util.StatusToString(http.StatusBadRequest), response.Status)
+	assert.Equal(t, util.StatusToString(http.StatusNotFound), response.Status)
+
+	// Uses an unsupported method, should result in 405 Method Not Allowed
+	response, err = doRequest(http.MethodPut, consts.NotificationPath, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, util.StatusToString(http.StatusMethodNotAllowed), response.Status)
 
 	// returns all webhooks, should result in 200 OK
 	response, err = doRequest(http.MethodGet, consts.NotificationPath, nil)
@@ -157,3 +175,99 @@ func TestNotificationHandler(t *testing.T) {
 	assert.Equal(t, webhookCount, len(webhooks))
 
 }
+
+func TestRegisterWebhookCalls(t *testing.T) {
+	config := util.Config{AllowPrivateWebhooks: true}
+	var countryDB util.CountryDataset
+	url := "http://127.0.0.1/hook"
+	assert.Nil(t, util.ValidateWebhookURL(url, config.AllowPrivateWebhooks))
+	// Webhooks are rejected before being stored unless calls is 1 and above
+	for _, calls := range []int32{0, -1, -5} {
+		_, err := RegisterWebhook(context.Background(), &config, &countryDB, Webhook{URL: url, Calls: calls})
+		assert.ErrorIs(t, err, ErrInvalidWebhook, calls)
+	}
+}
+
+func TestVerifyWebhookReceiver(t *testing.T) {
+	client := util.NewWebhookClient(&util.Config{AllowPrivateWebhooks: true})
+
+	// Receiver echoing the challenge back
+	echoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		challenge := WebhookChallenge{}
+		if err := json.NewDecoder(r.Body).Decode(&challenge); err != nil {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		util.EncodeAndWriteResponse(&w, WebhookChallenge{Challenge: challenge.Challenge})
+	}))
+	defer echoServer.Close()
+	assert.Nil(t, verifyWebhookReceiver(context.Background(), client, echoServer.URL))
+
+	// Receiver accepting the request without echoing the challenge
+	silentServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer silentServer.Close()
+	assert.Error(t, verifyWebhookReceiver(context.Background(), client, silentServer.URL))
+
+	// Receiver refusing the request
+	refusingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", http.StatusNotFound)
+	}))
+	defer refusingServer.Close()
+	assert.Error(t, verifyWebhookReceiver(context.Background(), client, refusingServer.URL))
+
+	// Loopback receivers are refused unless private addresses are allowed
+	restrictedClient := util.NewWebhookClient(&util.Config{AllowPrivateWebhooks: false})
+	assert.Error(t, verifyWebhookReceiver(context.Background(), restrictedClient, echoServer.URL))
+}
+
+func TestStreamEvents(t *testing.T) {
+	var countryDB util.CountryDataset
+	if err := countryDB.Initialize("." + consts.DataSetPath); err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{}
+	config.InitializeWithDefaults()
+	broker := events.NewBroker()
+	server := httptest.NewServer(NotificationHandler(&config, &countryDB, broker))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + consts.NotificationPath + "stream?country=norway")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("content-type"))
+	reader := bufio.NewReader(response.Body)
+	retry, _ := reader.ReadString('\n')
+	blank, _ := reader.ReadString('\n')
+	assert.Equal(t, "retry: 5000\n\n", retry+blank)
+
+	// Only events of the subscribed country are streamed.
+	broker.Publish(events.TypeThreshold, "SWE", events.ThresholdData{Country: "SWE", Calls: 5})
+	broker.Publish(events.TypeThreshold, "NOR", events.ThresholdData{WebhookId: "id", Country: "NOR",
+		Endpoint: "current", Calls: 5, Thresholds: []int32{5}})
+	event := ""
+	for !strings.HasSuffix(event, "\n\n") {
+		line, err := reader.ReadString('\n')
+		if !assert.Nil(t, err) {
+			return
+		}
+		event += line
+	}
+	assert.Equal(t, "id: 2\nevent: threshold\n"+
+		`data: {"webhook_id":"id","country":"NOR","endpoint":"current","calls":5,"thresholds":[5]}`+"\n\n", event)
+
+	// Closing the broker ends the stream.
+	broker.Close()
+	_, err = io.ReadAll(reader)
+	assert.Nil(t, err)
+
+	response, err = http.Get(server.URL + consts.NotificationPath + "stream?country=XYZ")
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+		_ = response.Body.Close()
+	}
+}
@@ -3,56 +3,65 @@ package handlers
 import (
 	"Assignment2/caching"
 	"Assignment2/consts"
+	"Assignment2/httpcache"
+	"Assignment2/router"
 	"Assignment2/util"
 	"errors"
-	"log"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
-	//"time"
 )
 
 // Internal - paths
 const currentPath = "current"
 const historyPath = "history"
 
-// HandlerRenew Handler for the renewables endpoint: this checks if the request is GET, and calls the correct function
-// for current renewable percentage or historical renewable percentage
-func HandlerRenew(request chan caching.CacheRequest, dataset *util.CountryDataset, invocation chan []string) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method { // switch for easy expansion
-		case http.MethodGet:
-			path := util.FragmentsFromPath(r.URL.Path, consts.RenewablesPath)
+// paramCountry is the path parameter holding the cca3 code or name of a country.
+const paramCountry = "country"
 
-			// if path is empty after /renewables/, returns error message
-			if len(path) == 0 {
-				http.Error(w, "Not found, only /current/ and /history/ supported", http.StatusNotFound)
-			} else if len(path) < 2 { // if no cc3a-code is supplied with request,
-				path = append(path, "") // appends an empty string that tells handlers
-				// to find information about all countries
-			}
-			// checks if path contains /current/ or /history/, if not error message
-			switch path[0] {
-			case currentPath:
-				handlerCurrent(w, r, strings.ToUpper(path[1]), request, dataset, invocation)
-			case historyPath:
-				handlerHistorical(w, r, strings.ToUpper(path[1]), dataset, invocation)
-			default:
-				http.Error(w, "Not found, only /current/ and /history/ supported", http.StatusNotFound)
-				return
-			}
-		default:
-			http.Error(w, "Method not implemented, only GET requests are supported", http.StatusNotImplemented)
-			return
-		}
+// missingYearsHeader lists the years missing in the dataset within the history of a country,
+// whether left out or filled.
+const missingYearsHeader = "Missing-Years"
+
+// HandlerRenew Routes of the renewables endpoint: current renewable percentage or historical renewable
+// percentage, either for all countries or for the country given by its cca3 code or name, the
+// projected renewable percentage of a country, and the distribution of renewable percentages across
+// countries per year.
+// Responses carry validators derived from the dataset version, and are compressed if accepted.
+func HandlerRenew(request chan caching.CacheRequest, dataset *util.CountryDataset, invocation *caching.InvocationRecorder) *router.Router {
+	current := func(w http.ResponseWriter, r *http.Request) {
+		handlerCurrent(w, r, strings.ToUpper(router.Param(r, paramCountry)), request, dataset, invocation)
+	}
+	history := func(w http.ResponseWriter, r *http.Request) {
+		handlerHistorical(w, r, strings.ToUpper(router.Param(r, paramCountry)), dataset, invocation)
 	}
+	projection := func(w http.ResponseWriter, r *http.Request) {
+		handlerForecast(w, r, strings.ToUpper(router.Param(r, paramCountry)), dataset, invocation)
+	}
+	summary := func(w http.ResponseWriter, r *http.Request) {
+		handlerSummary(w, r, router.Param(r, paramYear), dataset)
+	}
+	cached := func(handler http.HandlerFunc) http.Handler {
+		return httpcache.Compress(httpcache.Conditional(dataset, handler))
+	}
+	// the empty string for country tells handlers to find information about all countries
+	rt := router.New()
+	rt.Handle(http.MethodGet, consts.RenewablesPath+currentPath, cached(current))
+	rt.Handle(http.MethodGet, consts.RenewablesPath+currentPath+"/{"+paramCountry+"}", cached(current))
+	rt.Handle(http.MethodGet, consts.RenewablesPath+historyPath, cached(history))
+	rt.Handle(http.MethodGet, consts.RenewablesPath+historyPath+"/{"+paramCountry+"}", cached(history))
+	rt.Handle(http.MethodGet, consts.RenewablesPath+forecastPath+"/{"+paramCountry+"}", cached(projection))
+	rt.Handle(http.MethodGet, consts.RenewablesPath+summaryPath, cached(summary))
+	rt.Handle(http.MethodGet, consts.RenewablesPath+summaryPath+"/{"+paramYear+"}", cached(summary))
+	return rt
 }
 
 // handlerCurrent handles requests for renewable energy percentage for the current year in one country,
 // with possibility for returning the same information for that country's neighbours
-func handlerCurrent(w http.ResponseWriter, r *http.Request, code string, request chan caching.CacheRequest, dataset *util.CountryDataset, invocation chan []string) {
+func handlerCurrent(w http.ResponseWriter, r *http.Request, code string, request chan caching.CacheRequest, dataset *util.CountryDataset, invocation *caching.InvocationRecorder) {
 	var stats []util.RenewableStatistics
+	ctx := r.Context()
 	// If the empty string is passed, all countries will be returned
 	// Otherwise, tries to find country matching code in dataset
 	if code == "" {
@@ -61,27 +70,30 @@ func handlerCurrent(w http.ResponseWriter, r *http.Request, code string, request
 		// if code is longer than 3 characters it is treated as a name
 		// if that name can be found in the dataset, the code variable is set to that country's cc3a code
 		if len(code) > 3 {
-			code = strings.ReplaceAll(code, "%20", " ")
 			var err error
 			code, err = dataset.GetCountryByName(code)
 			if err != nil {
-				http.Error(w, "404 not found", http.StatusNotFound)
+				util.WriteProblem(w, r, http.StatusNotFound, util.ProblemCountryNotFound,
+					"no country with that name in dataset")
 				return
 			}
 		}
 		statistic, err := dataset.GetStatistic(code)
 		if err != nil {
-			http.Error(w, "Code misspelled or country not in dataset", http.StatusNotFound)
+			util.WriteProblem(w, r, http.StatusNotFound, util.ProblemCountryNotFound,
+				"code misspelled or country not in dataset")
 			return
 		}
-		invocation <- []string{code}
+		invocation.Record([]caching.Invocation{
+			{Ctx: ctx, Endpoint: caching.EndpointCurrent, Country: code, QueryType: caching.QueryCountry},
+		})
 
 		stats = append(stats, statistic)
 
 		// if no match is found for passed code, or if results are otherwise failed to be found
 		// returns error
 		if len(stats) == 0 {
-			http.Error(w, "Not found", http.StatusNotFound)
+			util.WriteProblem(w, r, http.StatusNotFound, util.ProblemNotFound, "no statistics found")
 			return
 		}
 		// If a neighbours query has been found, attempts to parse into bool
@@ -89,17 +101,24 @@ func handlerCurrent(w http.ResponseWriter, r *http.Request, code string, request
 			query := r.URL.Query()
 			neighboursTrue, err := strconv.ParseBool(query.Get("neighbours"))
 			if err != nil {
-				http.Error(w, "Bad request, neighbours must equal true or false", http.StatusBadRequest)
+				util.WriteProblem(w, r, http.StatusBadRequest, util.ProblemInvalidParameter,
+					"neighbours must equal true or false")
 				return
 			}
 			if neighboursTrue {
 				// sends a request to the cache worker
 				ret := make(chan caching.CacheResponse)
-				request <- caching.CacheRequest{ChannelRef: ret, CountryRequest: []string{code}}
+				request <- caching.CacheRequest{Ctx: ctx, ChannelRef: ret, CountryRequest: []string{code}}
 				result := <-ret
 				// if the request doesn't return not found, it will find those neighbours
 				if result.Status != http.StatusNotFound {
-					invocation <- result.Neighbours[code]
+					neighbourInvocations := make([]caching.Invocation, 0, len(result.Neighbours[code]))
+					for _, neighbour := range result.Neighbours[code] {
+						neighbourInvocations = append(neighbourInvocations, caching.Invocation{
+							Ctx: ctx, Endpoint: caching.EndpointCurrent, Country: neighbour, QueryType: caching.QueryNeighbour,
+						})
+					}
+					invocation.Record(neighbourInvocations)
 					for _, neighbour := range result.Neighbours[code] {
 						statistic, err := dataset.GetStatistic(neighbour)
 						if err == nil {
@@ -113,7 +132,7 @@ func handlerCurrent(w http.ResponseWriter, r *http.Request, code string, request
 	// if no match is found for passed code, or if results have otherwise failed to be found
 	// returns error
 	if len(stats) == 0 {
-		http.Error(w, "Not", http.StatusNotFound)
+		util.WriteProblem(w, r, http.StatusNotFound, util.ProblemNotFound, "no statistics found")
 		return
 	}
 	http.Header.Add(w.Header(), "content-type", "application/json")
@@ -121,73 +140,84 @@ func handlerCurrent(w http.ResponseWriter, r *http.Request, code string, request
 }
 
 // handlerHistorical Handles requests for the history of renewable energy in one country,
-// on a yearly basis. Has functionality for setting starting and ending year of renewables history
-func handlerHistorical(w http.ResponseWriter, r *http.Request, code string, dataset *util.CountryDataset, invocation chan []string) {
+// on a yearly basis. Has functionality for setting starting and ending year of renewables history,
+// and for filling years missing in the dataset. Missing years are reported in the gaps of averages,
+// and in the Missing-Years header of the history of one country
+func handlerHistorical(w http.ResponseWriter, r *http.Request, code string, dataset *util.CountryDataset, invocation *caching.InvocationRecorder) {
 	var stats []util.RenewableStatistics
+	var averages []util.HistoricAverage
 	var begin, end int
 	var sortByValue bool
 	var err error
+	ctx := r.Context()
+	fill := util.FillNone
+	if r.URL.Query().Has("fill") {
+		fill = r.URL.Query().Get("fill")
+		if err = util.ValidateFill(fill); err != nil {
+			util.WriteProblem(w, r, http.StatusBadRequest, util.ProblemInvalidParameter, err.Error())
+			return
+		}
+	}
 	// if no code is provided, a list of every country's average renewable percentage is returned
 	if code == "" {
 		begin, end, sortByValue, err = parseHistoricQuery(r, dataset, code)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			util.WriteProblem(w, r, http.StatusBadRequest, util.ProblemInvalidParameter, err.Error())
 			return
 		}
-		stats = dataset.GetHistoricStatistics()
-		// if both begin and end queries have been specified, the averages for all countries are
-		// calculated only for that span
-		if begin != 0 || end != 0 {
-			for i := range stats {
-				percentage := 0.0
-				percentage, err = dataset.CalculatePercentage(stats[i].Isocode, begin, end)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusNotFound)
-					return
-				}
-				stats[i].Percentage = percentage
-			}
-		}
-		if sortByValue {
-			stats = SortStatistics(stats)
-		}
+		// if begin or end queries have been specified, the averages for all countries are
+		// calculated only for that span, otherwise for every year in records
+		averages = dataset.GetHistoricAverages(begin, end, fill, sortByValue)
 	} else { //if code is not empty
 		if len(code) > 3 {
 			// if code is longer than three characters, then it is treated as a country name
 			// tries to find country with that name
-			code = strings.ReplaceAll(code, "%20", " ")
 			code, err = dataset.GetCountryByName(code)
 			if err != nil {
-				http.Error(w, "404 not found", http.StatusNotFound)
+				util.WriteProblem(w, r, http.StatusNotFound, util.ProblemCountryNotFound,
+					"no country with that name in dataset")
 				return
 			}
 		}
 		if !dataset.HasCountryInRecords(code) {
-			http.Error(w, "Code mispelled or country not in dataset", http.StatusNotFound)
+			util.WriteProblem(w, r, http.StatusNotFound, util.ProblemCountryNotFound,
+				"code misspelled or country not in dataset")
 			return
 		}
 		// parses a query
 		begin, end, sortByValue, err = parseHistoricQuery(r, dataset, code)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			util.WriteProblem(w, r, http.StatusBadRequest, util.ProblemInvalidParameter, err.Error())
 			return
 		}
-		invocation <- []string{code}
-		log.Println(end)
-		log.Println(begin)
+		invocation.Record([]caching.Invocation{
+			{Ctx: ctx, Endpoint: caching.EndpointHistory, Country: code, QueryType: caching.QueryCountry},
+		})
+		util.Logger(ctx).Debug("history lookup", "country", code, "begin", begin, "end", end)
 		// Adds yearly percentages for span from begin to end
 		// if not set by user, it will be from the first to the last year in the dataset
-		stats = dataset.GetStatisticsRange(code, begin, end)
+		stats = dataset.GetFilledStatisticsRange(code, begin, end, fill)
+		if gaps := dataset.GetGaps(code, begin, end); len(gaps) != 0 {
+			years := make([]string, 0, len(gaps))
+			for _, year := range gaps {
+				years = append(years, strconv.Itoa(year))
+			}
+			w.Header().Set(missingYearsHeader, strings.Join(years, ", "))
+		}
 		if sortByValue {
 			stats = SortStatistics(stats)
 		}
 	}
-	if len(stats) == 0 { // if no results have been found
-		http.Error(w, "Not found", http.StatusNotFound)
+	if len(stats) == 0 && len(averages) == 0 { // if no results have been found
+		util.WriteProblem(w, r, http.StatusNotFound, util.ProblemNotFound, "no statistics found")
 		return
 	}
 	http.Header.Add(w.Header(), "content-type", "application/json")
-	util.EncodeAndWriteResponse(&w, stats)
+	if code == "" {
+		util.EncodeAndWriteResponse(&w, averages)
+	} else {
+		util.EncodeAndWriteResponse(&w, stats)
+	}
 }
 
 // parseHistoricQuery parses the URL query from a request to the historyRenewables-handler if any is present
@@ -204,14 +234,14 @@ func parseHistoricQuery(r *http.Request, dataset *util.CountryDataset, code stri
 		if _, ok := query["sortByValue"]; ok {
 			sortByValue, err = strconv.ParseBool(query.Get("sortByValue"))
 			if err != nil {
-				return 0, 0, false, errors.New("bad request, sortByValue must equal true or false")
+				return 0, 0, false, errors.New("sortByValue must equal true or false")
 			}
 		}
 		if _, ok := query["begin"]; ok {
 			// tries to find begin
 			begin, err = strconv.Atoi(query.Get("begin"))
 			if err != nil {
-				return 0, 0, false, errors.New("bad request, begin must be a whole number")
+				return 0, 0, false, errors.New("begin must be a whole number")
 			}
 			// checks if begin has been set lower than a country's first year in dataset
 			if code != "" {
@@ -226,7 +256,7 @@ func parseHistoricQuery(r *http.Request, dataset *util.CountryDataset, code stri
 		if _, ok := query["end"]; ok {
 			end, err = strconv.Atoi(query.Get("end"))
 			if err != nil {
-				return 0, 0, false, errors.New("bad request, end must be a whole number")
+				return 0, 0, false, errors.New("end must be a whole number")
 			}
 			// checks if end has been higher than a country's last year in dataset
 			if code != "" {
@@ -241,8 +271,7 @@ func parseHistoricQuery(r *http.Request, dataset *util.CountryDataset, code stri
 		if begin > end {
 			// if code is not empty
 			if end != 0 {
-				//http.Error(w, "Bad request, begin must be smaller than end", http.StatusBadRequest)
-				return 0, 0, sortByValue, errors.New("bad request, begin must be smaller than end" +
+				return 0, 0, sortByValue, errors.New("begin must be smaller than end" +
 					" or span of years indicated by begin/end is not in record")
 			}
 		} //if no errors have been found, the parsed values are returned
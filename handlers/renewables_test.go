@@ -3,6 +3,7 @@ package handlers
 import (
 	"Assignment2/caching"
 	"Assignment2/consts"
+	"Assignment2/internal/assets"
 	"Assignment2/internal/stubbing"
 	"Assignment2/util"
 	"encoding/json"
@@ -11,8 +12,10 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"sync"
 	"testing"
+	"testing/fstest"
 )
 
 // Internal paths
@@ -41,7 +44,7 @@ func TestRenewables(t *testing.T) {
 	stubStop := make(chan struct{})
 	cacheStop := make(chan struct{})
 	cacheDone := make(chan struct{})
-	invocations := make(chan []string, 10)
+	invocations := caching.NewInvocationRecorder(10)
 	invocationStop := make(chan struct{})
 	invocationDone := make(chan struct{})
 
@@ -55,7 +58,7 @@ func TestRenewables(t *testing.T) {
 	// Launch of worker threads
 	if config.DevelopmentMode {
 		wg.Add(1)
-		go stubbing.RunSTUBServer(&config, &wg, "../internal/assets/", consts.StubPort, stubStop)
+		go stubbing.RunSTUBServer(&config, &wg, assets.New(""), consts.StubPort, stubStop)
 	}
 	go caching.RunCacheWorker(&config, requests, cacheStop, cacheDone)
 	go caching.InvocationWorker(&config, invocationStop, invocationDone, &countryDataset, invocations)
@@ -70,7 +73,7 @@ func TestRenewables(t *testing.T) {
 			if routine {
 				t.Parallel()
 			}
-			server := httptest.NewServer(http.HandlerFunc(testHandler))
+			server := httptest.NewServer(testHandler)
 			query = server.URL + query
 			client := http.Client{}
 
@@ -264,7 +267,7 @@ func TestSortSlice(t *testing.T) {
 	runTest := func(stats []util.RenewableStatistics, firstExpected util.RenewableStatistics) func(t *testing.T) {
 		return func(t *testing.T) {
 			stats = SortStatistics(stats)
-			if stats[0] != firstExpected {
+			if !reflect.DeepEqual(stats[0], firstExpected) {
 				t.Errorf("Unexpected sorted result, got #{stats[0]} but expected #{firstExpected}")
 			}
 		}
@@ -277,27 +280,130 @@ func TestSortSlice(t *testing.T) {
 	}{
 		{name: "Test for 1960s Norway statiscs",
 			statistics: []util.RenewableStatistics{
-				{"Norway", "NOR", 1967, 60.32},
-				{"Norway", "NOR", 1968, 61.132},
-				{"Norway", "NOR", 1969, 62.31},
+				{Name: "Norway", Isocode: "NOR", Year: 1967, Percentage: 60.32},
+				{Name: "Norway", Isocode: "NOR", Year: 1968, Percentage: 61.132},
+				{Name: "Norway", Isocode: "NOR", Year: 1969, Percentage: 62.31},
 			},
-			firstExpected: util.RenewableStatistics{"Norway", "NOR", 1967, 60.32}},
+			firstExpected: util.RenewableStatistics{Name: "Norway", Isocode: "NOR", Year: 1967, Percentage: 60.32}},
 		{name: "Test for 1970s Norway statiscs",
 			statistics: []util.RenewableStatistics{
-				{"Norway", "NOR", 1972, 59.81},
-				{"Norway", "NOR", 1974, 58.82},
-				{"Norway", "NOR", 1978, 62.01},
+				{Name: "Norway", Isocode: "NOR", Year: 1972, Percentage: 59.81},
+				{Name: "Norway", Isocode: "NOR", Year: 1974, Percentage: 58.82},
+				{Name: "Norway", Isocode: "NOR", Year: 1978, Percentage: 62.01},
 			},
-			firstExpected: util.RenewableStatistics{"Norway", "NOR", 1974, 58.82}},
+			firstExpected: util.RenewableStatistics{Name: "Norway", Isocode: "NOR", Year: 1974, Percentage: 58.82}},
 		{name: "Test for 1990s Sweden statiscs",
 			statistics: []util.RenewableStatistics{
-				{"Sweden", "SWE", 1994, 48.15},
-				{"Sweden", "SWE", 1996, 50.12},
-				{"Sweden", "SWE", 1998, 47.01},
+				{Name: "Sweden", Isocode: "SWE", Year: 1994, Percentage: 48.15},
+				{Name: "Sweden", Isocode: "SWE", Year: 1996, Percentage: 50.12},
+				{Name: "Sweden", Isocode: "SWE", Year: 1998, Percentage: 47.01},
 			},
-			firstExpected: util.RenewableStatistics{"Sweden", "SWE", 1998, 47.01}},
+			firstExpected: util.RenewableStatistics{Name: "Sweden", Isocode: "SWE", Year: 1998, Percentage: 47.01}},
 	}
 	for _, test := range testCases {
 		t.Run(test.name, runTest(test.statistics, test.firstExpected))
 	}
 }
+
+// TestConditionalInvocations tests that requests answered with 304 Not Modified are still
+// counted as invocations
+func TestConditionalInvocations(t *testing.T) {
+	var dataset util.CountryDataset
+	if err := dataset.Initialize("." + consts.DataSetPath); err != nil {
+		t.Fatal(err)
+	}
+	invocations := caching.NewInvocationRecorder(10)
+	routes := HandlerRenew(make(chan caching.CacheRequest), &dataset, invocations)
+	for _, path := range []string{currentTestPath + "NOR", historyTestPath + "NOR"} {
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("If-None-Match", recorder.Header().Get("etag"))
+		recorder = httptest.NewRecorder()
+		routes.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusNotModified {
+			t.Errorf("expected %d for %s, got %d", http.StatusNotModified, path, recorder.Code)
+		}
+	}
+	if invocations.Queued() != 4 {
+		t.Errorf("expected every request to be counted, got %d", invocations.Queued())
+	}
+}
+
+// TestHistoryFill tests the reporting and filling of years missing in the history endpoint
+func TestHistoryFill(t *testing.T) {
+	var dataset util.CountryDataset
+	files := fstest.MapFS{"dataset.csv": {Data: []byte("Entity,Code,Year,Renewables\n" +
+		"Norway,NOR,2000,10\nNorway,NOR,2001,20\nNorway,NOR,2004,50\nNorway,NOR,2005,60\n" +
+		"Sweden,SWE,2000,40\nSweden,SWE,2001,50\n")}}
+	if err := dataset.InitializeFS(files, "dataset.csv"); err != nil {
+		t.Fatal(err)
+	}
+	routes := HandlerRenew(make(chan caching.CacheRequest), &dataset, caching.NewInvocationRecorder(10))
+	get := func(path string) (*httptest.ResponseRecorder, []util.RenewableStatistics) {
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, historyTestPath+path, nil))
+		stats := make([]util.RenewableStatistics, 0)
+		if recorder.Code == http.StatusOK {
+			if err := json.NewDecoder(recorder.Body).Decode(&stats); err != nil {
+				t.Error(err)
+			}
+		}
+		return recorder, stats
+	}
+
+	recorder, stats := get("NOR")
+	if len(stats) != 4 || recorder.Header().Get(missingYearsHeader) != "2002, 2003" {
+		t.Errorf("expected observed years and gaps to be reported, got %v and %q", stats,
+			recorder.Header().Get(missingYearsHeader))
+	}
+	recorder, stats = get("NOR?fill=linear")
+	if len(stats) != 6 || !stats[2].Filled || stats[2].Percentage != 30 || stats[1].Filled {
+		t.Errorf("expected missing years to be interpolated, got %v", stats)
+	}
+	recorder, _ = get("NOR?begin=2004")
+	if recorder.Header().Get(missingYearsHeader) != "" {
+		t.Error("expected no gaps to be reported for a span without gaps")
+	}
+
+	// averages of every country report their gaps, and are computed over observed or filled years
+	averagesOf := func(query string) (int, map[string]util.HistoricAverage) {
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, historyTestPath+query, nil))
+		averages := make([]util.HistoricAverage, 0)
+		if recorder.Code == http.StatusOK {
+			if err := json.NewDecoder(recorder.Body).Decode(&averages); err != nil {
+				t.Error(err)
+			}
+		}
+		countries := make(map[string]util.HistoricAverage)
+		for _, average := range averages {
+			countries[average.Isocode] = average
+		}
+		return recorder.Code, countries
+	}
+	for fill, average := range map[string]float64{"": 35, "?fill=none": 35, "?fill=previous": 30} {
+		_, averages := averagesOf(fill)
+		if norway := averages["NOR"]; norway.Percentage == nil || *norway.Percentage != average || len(norway.Gaps) != 2 {
+			t.Errorf("unexpected average for %q: %v", fill, norway)
+		}
+		if sweden := averages["SWE"]; sweden.Percentage == nil || sweden.Gaps != nil {
+			t.Errorf("expected an average without gaps, got %v", sweden)
+		}
+	}
+	// a span made up of one country's missing years lists that country with its gaps only,
+	// and leaves out countries without records in the span
+	status, averages := averagesOf("?begin=2002&end=2003")
+	if norway := averages["NOR"]; status != http.StatusOK || len(averages) != 1 ||
+		norway.Percentage != nil || !reflect.DeepEqual(norway.Gaps, []int{2002, 2003}) {
+		t.Errorf("expected only the gaps of Norway, got %d and %v", status, averages)
+	}
+	if _, averages = averagesOf("?begin=2002&end=2003&fill=linear"); averages["NOR"].Percentage == nil ||
+		*averages["NOR"].Percentage != 35 {
+		t.Errorf("expected the gaps of Norway to be filled, got %v", averages)
+	}
+	recorder, _ = get("?fill=next")
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request for invalid fill, got %d", recorder.Code)
+	}
+}
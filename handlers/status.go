@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"Assignment2/caching"
 	"Assignment2/consts"
 	"Assignment2/fsutils"
 	"Assignment2/util"
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,79 +18,245 @@ import (
 
 // ServiceStatus for storage of status data before encoding to json
 type ServiceStatus struct {
+	Status          string `json:"status"`
 	CountriesApi    string `json:"countries_api"`
 	NotificationsDb string `json:"notification_db"`
 	Webhooks        string `json:"webhooks"`
 	Version         string `json:"version"`
 	Uptime          int    `json:"uptime"`
+
+	Dependencies    map[string]DependencyStatus     `json:"dependencies"`
+	PendingWebhooks *int64                          `json:"pending_webhooks,omitempty"`
+	Cache           caching.CacheStatus             `json:"cache"`
+	Workers         map[string]caching.WorkerStatus `json:"workers"`
+	Dataset         DatasetStatus                   `json:"dataset"`
+	Config          util.EffectiveConfig            `json:"config"`
+}
+
+// DependencyStatus reports the outcome and latency of a probe of a service dependency.
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	Healthy   bool    `json:"healthy"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// DatasetStatus reports the size and load time of the renewables dataset.
+type DatasetStatus struct {
+	Countries int       `json:"countries"`
+	LoadedAt  time.Time `json:"loaded_at"`
+}
+
+// ProbeStatus is the body of responses from the liveness and readiness endpoints.
+type ProbeStatus struct {
+	Status string   `json:"status"`
+	Failed []string `json:"failed,omitempty"`
 }
 
+// Overall service statuses.
+const (
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+)
+
+// Names of the dependencies reported by the status endpoint.
+const (
+	dependencyCountries     = "countries_api"
+	dependencyNotifications = "notification_db"
+)
+
+// Names of the checks reported by the liveness and readiness endpoints.
+const (
+	checkWorkers       = "workers"
+	checkDataset       = "dataset"
+	checkNotifications = dependencyNotifications
+)
+
+// statusProbeTimeout limits the time spent probing each dependency.
+const statusProbeTimeout = 2 * time.Second
+
+// unableToCount is reported in place of a count the DB could not provide.
+const unableToCount = "Unable to count"
+
 // Collection with one document, to check if db is available:
 const dbProbeCollection = "dbProbeCollection"
 const dbProbeDocument = "dbProbeDocument"
 
-// TODO remove when everything works
-//const dbProbeValue = http.StatusOK
+// HandlerStatus Handler for the status endpoint. Failing dependencies are reported as
+// part of the status, marking the service as degraded rather than failing the request.
+func HandlerStatus(cfg *util.Config, startTime time.Time, dataset *util.CountryDataset) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		ctx := r.Context()
+		countries := probeCountriesAPI(ctx, cfg)
+		notifications := probeNotificationDB(ctx, cfg)
+
+		webhooks := unableToCount
+		var pendingWebhooks *int64
+		if notifications.Healthy {
+			webhooks = countWebhooks(ctx, cfg)
+			pendingWebhooks = countPendingWebhooks(ctx, cfg)
+		}
+		now := time.Now()
+		serviceStatus := ServiceStatus{
+			Status:          statusOK,
+			CountriesApi:    countries.Status,
+			NotificationsDb: notifications.Status,
+			Webhooks:        webhooks,
+			Version:         consts.Version,
+			Uptime:          int(time.Since(startTime).Seconds()),
+			Dependencies: map[string]DependencyStatus{
+				dependencyCountries:     countries,
+				dependencyNotifications: notifications,
+			},
+			PendingWebhooks: pendingWebhooks,
+			Cache:           caching.GetCacheStatus(),
+			Workers:         caching.GetWorkerStatus(now),
+			Dataset:         getDatasetStatus(dataset),
+			Config:          cfg.Effective(),
+		}
+		if !countries.Healthy || !notifications.Healthy || !caching.WorkersAlive(now) {
+			serviceStatus.Status = statusDegraded
+		}
+		// json response to user:
+		util.EncodeAndWriteResponse(&w, serviceStatus)
+	}
+}
+
+// HandlerHealth Handler for the liveness endpoint. The service is alive as long as it is
+// serving requests and its workers keep reporting heartbeats.
+func HandlerHealth() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		failed := make([]string, 0)
+		if !caching.WorkersAlive(time.Now()) {
+			failed = append(failed, checkWorkers)
+		}
+		writeProbeStatus(w, failed)
+	}
+}
 
-// HandlerStatus Handler for the status endpoint
-func HandlerStatus(cfg *util.Config, startTime time.Time) func(http.ResponseWriter, *http.Request) {
+// HandlerReady Handler for the readiness endpoint. The service is ready when the dataset is
+// loaded, the notification DB is reachable and the workers are alive. The countries API is
+// left out, as cached data can still be served while it is unavailable.
+func HandlerReady(cfg *util.Config, dataset *util.CountryDataset) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			w.Header().Set("content-type", "application/json")
-
-			var countryService string
-			if cfg.DevelopmentMode {
-				countryService = consts.StubDomain
-			} else {
-				countryService = consts.CountryDomain
-			}
-
-			countriesStatus, err := util.GetDomainStatus(countryService +
-				consts.CountryCodePath + "?codes=NOR")
-			if err != nil {
-				log.Println("handler status: Failed to close body of get request.")
-			}
-
-			// Read back document with stored status code:
-			notificationStatusCode := make(map[string]int)
-			err = fsutils.ReadDocumentGeneral(cfg, dbProbeCollection, dbProbeDocument, &notificationStatusCode)
-			if err != nil {
-				http.Error(w, "Error while handling request.", http.StatusInternalServerError)
-				return
-			}
-			notificationStatus := fmt.Sprint(notificationStatusCode["status code"]) + " " + http.StatusText(notificationStatusCode["status code"])
-
-			webhookCount, err := countWebhooks(cfg)
-			var webhooks string
-			if err != nil {
-				webhooks = "Unable to count"
-			} else {
-				webhooks = strconv.Itoa(webhookCount)
-			}
-			upTime := int(time.Since(startTime).Seconds())
-			serviceStatus := ServiceStatus{
-				CountriesApi:    countriesStatus,
-				NotificationsDb: notificationStatus,
-				Webhooks:        webhooks,
-				Version:         consts.Version,
-				Uptime:          upTime,
-			}
-			// json response to user:
-			util.EncodeAndWriteResponse(&w, serviceStatus)
-
-		default:
-			http.Error(w, "http method not supported.", http.StatusMethodNotAllowed)
+		failed := make([]string, 0)
+		if getDatasetStatus(dataset).Countries == 0 {
+			failed = append(failed, checkDataset)
+		}
+		if !probeNotificationDB(r.Context(), cfg).Healthy {
+			failed = append(failed, checkNotifications)
+		}
+		if !caching.WorkersAlive(time.Now()) {
+			failed = append(failed, checkWorkers)
 		}
+		writeProbeStatus(w, failed)
 	}
 }
 
-// countWebhooks returns number of stored webhooks in Firebase
-func countWebhooks(cfg *util.Config) (int, error) {
-	count, err := fsutils.CountDocuments(cfg, cfg.WebhookCollection)
+// writeProbeStatus responds with 200 if no checks failed, and 503 listing the failed checks otherwise.
+func writeProbeStatus(w http.ResponseWriter, failed []string) {
+	w.Header().Set("content-type", "application/json")
+	if len(failed) == 0 {
+		util.EncodeAndWriteResponse(&w, ProbeStatus{Status: statusOK})
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if err := json.NewEncoder(w).Encode(ProbeStatus{Status: statusDegraded, Failed: failed}); err != nil {
+		slog.Error("handler status: failed to encode probe status", "error", err)
+	}
+}
+
+// probeCountriesAPI sends a basic get request to the countries API, or the stub in development
+// mode, reporting the response status and latency.
+func probeCountriesAPI(ctx context.Context, cfg *util.Config) DependencyStatus {
+	countryService := cfg.CountriesURL()
+	ctx, cancel := context.WithTimeout(ctx, statusProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		countryService+consts.CountryCodePath+"?codes=NOR", nil)
+	if err != nil {
+		return DependencyStatus{Status: util.StatusToString(http.StatusInternalServerError)}
+	}
+	response, err := http.DefaultClient.Do(request)
+	latency := milliseconds(time.Since(start))
+	if err != nil {
+		code := http.StatusServiceUnavailable
+		if ctx.Err() == context.DeadlineExceeded {
+			code = http.StatusRequestTimeout
+		}
+		return DependencyStatus{Status: util.StatusToString(code), LatencyMs: latency}
+	}
+	_ = response.Body.Close()
+	return DependencyStatus{
+		Status:    response.Status,
+		Healthy:   response.StatusCode == http.StatusOK,
+		LatencyMs: latency,
+	}
+}
+
+// probeNotificationDB reads back the probe document holding a stored status code, reporting
+// the stored status and latency. A missing probe document still shows the DB to be reachable.
+func probeNotificationDB(ctx context.Context, cfg *util.Config) DependencyStatus {
+	if cfg.FirestoreClient == nil {
+		return DependencyStatus{Status: util.StatusToString(http.StatusServiceUnavailable)}
+	}
+	ctx, cancel := context.WithTimeout(ctx, statusProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	snapshot, err := cfg.FirestoreClient.Collection(dbProbeCollection).Doc(dbProbeDocument).Get(ctx)
+	latency := milliseconds(time.Since(start))
+	switch {
+	case status.Code(err) == codes.NotFound:
+		return DependencyStatus{Status: util.StatusToString(http.StatusOK), Healthy: true, LatencyMs: latency}
+	case status.Code(err) == codes.DeadlineExceeded:
+		return DependencyStatus{Status: util.StatusToString(http.StatusRequestTimeout), LatencyMs: latency}
+	case err != nil:
+		return DependencyStatus{Status: util.StatusToString(http.StatusServiceUnavailable), LatencyMs: latency}
+	}
+	notificationStatusCode := make(map[string]int)
+	if err = snapshot.DataTo(&notificationStatusCode); err != nil || notificationStatusCode["status code"] == 0 {
+		return DependencyStatus{Status: util.StatusToString(http.StatusOK), Healthy: true, LatencyMs: latency}
+	}
+	code := notificationStatusCode["status code"]
+	return DependencyStatus{
+		Status:    fmt.Sprint(code) + " " + http.StatusText(code),
+		Healthy:   true,
+		LatencyMs: latency,
+	}
+}
+
+// countWebhooks returns number of stored webhooks in Firebase, or a message if unable to count.
+func countWebhooks(ctx context.Context, cfg *util.Config) string {
+	count, err := fsutils.CountQuery(ctx, cfg.FirestoreClient.Collection(cfg.WebhookCollection).Query)
+	if err != nil {
+		util.Logger(ctx).Error("handler status: could not get webhooks count", "error", err)
+		return unableToCount
+	}
+	return strconv.FormatInt(count, 10)
+}
+
+// countPendingWebhooks returns the number of webhooks with invocations awaiting a trigger
+// check and delivery, or nil if unable to count.
+func countPendingWebhooks(ctx context.Context, cfg *util.Config) *int64 {
+	query := cfg.FirestoreClient.Collection(cfg.WebhookCollection).Where("pending", "==", true)
+	count, err := fsutils.CountQuery(ctx, query)
 	if err != nil {
-		log.Println("could not get webhooks count")
-		return 0, err
+		util.Logger(ctx).Error("handler status: could not get pending webhooks count", "error", err)
+		return nil
 	}
-	return count, nil
+	return &count
+}
+
+// getDatasetStatus returns the size and load time of the dataset.
+func getDatasetStatus(dataset *util.CountryDataset) DatasetStatus {
+	_, countries := dataset.GetLengthOfDataset()
+	return DatasetStatus{Countries: countries, LoadedAt: dataset.LoadedAt()}
+}
+
+// milliseconds converts a duration to fractional milliseconds.
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
 }
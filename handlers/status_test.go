@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"Assignment2/consts"
+	"Assignment2/internal/assets"
 	"Assignment2/internal/stubbing"
+	"Assignment2/router"
 	"Assignment2/util"
 	"encoding/json"
+	"github.com/stretchr/testify/assert"
 	"io"
 	"log"
 	"net/http"
@@ -18,7 +21,8 @@ func TestHandlerStats(t *testing.T) {
 	config, _ := util.SetUpServiceConfig(consts.ConfigPath, "../cmd/sha.json")
 	startTime := time.Now()
 	time.Sleep(1 * time.Second)
-	handler := HandlerStatus(&config, startTime)
+	var dataset util.CountryDataset
+	handler := HandlerStatus(&config, startTime, &dataset)
 	server := httptest.NewServer(http.HandlerFunc(handler))
 	defer server.Close()
 
@@ -62,7 +66,7 @@ func TestHandlerStats(t *testing.T) {
 	wg := sync.WaitGroup{}
 	stop := make(chan struct{})
 	wg.Add(1)
-	go stubbing.RunSTUBServer(&config, &wg, "../internal/assets/", consts.StubPort, stop)
+	go stubbing.RunSTUBServer(&config, &wg, assets.New(""), consts.StubPort, stop)
 	time.Sleep(time.Second)
 	expected := ServiceStatus{
 		CountriesApi:    "200 OK",
@@ -75,3 +79,69 @@ func TestHandlerStats(t *testing.T) {
 	stop <- struct{}{}
 	wg.Wait()
 }
+
+func TestHandlerStatusDegraded(t *testing.T) {
+	// Without a DB client or loaded dataset, the status is still reported rather than failing.
+	config := util.Config{}
+	config.InitializeWithDefaults()
+	var dataset util.CountryDataset
+	handler := HandlerStatus(&config, time.Now(), &dataset)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, consts.StatusPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	status := ServiceStatus{}
+	if assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&status)) {
+		assert.Equal(t, statusDegraded, status.Status)
+		assert.Equal(t, unableToCount, status.Webhooks)
+		assert.Nil(t, status.PendingWebhooks)
+		assert.False(t, status.Dependencies[dependencyNotifications].Healthy)
+		assert.Equal(t, 0, status.Dataset.Countries)
+		assert.Equal(t, config.Effective(), status.Config)
+	}
+
+	// Methods are enforced by the router, which serves HEAD requests by GET routes.
+	routes := router.New()
+	routes.HandleFunc(http.MethodGet, consts.StatusPath, handler)
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, consts.StatusPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, consts.StatusPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestHandlerReady(t *testing.T) {
+	config := util.Config{}
+	config.InitializeWithDefaults()
+	var dataset util.CountryDataset
+	handler := HandlerReady(&config, &dataset)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, consts.ReadyPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	probe := ProbeStatus{}
+	if assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&probe)) {
+		assert.ElementsMatch(t, []string{checkDataset, checkNotifications, checkWorkers}, probe.Failed)
+	}
+
+	routes := router.New()
+	routes.HandleFunc(http.MethodGet, consts.ReadyPath, handler)
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, consts.ReadyPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, consts.ReadyPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestHandlerHealth(t *testing.T) {
+	// No workers are running in the test, so no heartbeats have been reported.
+	recorder := httptest.NewRecorder()
+	HandlerHealth()(recorder, httptest.NewRequest(http.MethodGet, consts.HealthPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	probe := ProbeStatus{}
+	if assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&probe)) {
+		assert.Equal(t, []string{checkWorkers}, probe.Failed)
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"Assignment2/util"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Internal - paths
+const summaryPath = "summary"
+
+// paramYear is the path parameter holding a year.
+const paramYear = "year"
+
+// handlerSummary handles requests for the distribution of renewable energy shares across
+// countries, either for the year given in the path or for every year on record. Every year
+// may be narrowed down to a span of years by the begin and end queries
+func handlerSummary(w http.ResponseWriter, r *http.Request, year string, dataset *util.CountryDataset) {
+	if year != "" {
+		parsed, err := strconv.Atoi(year)
+		if err != nil {
+			util.WriteProblem(w, r, http.StatusBadRequest, util.ProblemInvalidParameter, "year must be a whole number")
+			return
+		}
+		summary, err := dataset.GetYearSummary(parsed)
+		if err != nil {
+			util.WriteProblem(w, r, http.StatusNotFound, util.ProblemNotFound, "no country reports that year in dataset")
+			return
+		}
+		http.Header.Add(w.Header(), "content-type", "application/json")
+		util.EncodeAndWriteResponse(&w, summary)
+		return
+	}
+	begin, end, err := parseSummaryQuery(r)
+	if err != nil {
+		util.WriteProblem(w, r, http.StatusBadRequest, util.ProblemInvalidParameter, err.Error())
+		return
+	}
+	summaries := make([]util.YearSummary, 0)
+	for _, summary := range dataset.GetYearSummaries() {
+		if (begin == 0 || summary.Year >= begin) && (end == 0 || summary.Year <= end) {
+			summaries = append(summaries, summary)
+		}
+	}
+	http.Header.Add(w.Header(), "content-type", "application/json")
+	util.EncodeAndWriteResponse(&w, summaries)
+}
+
+// parseSummaryQuery parses the begin and end queries of a request to the summary handler,
+// returning 0 for those not present
+func parseSummaryQuery(r *http.Request) (int, int, error) {
+	query := r.URL.Query()
+	var begin, end int
+	var err error
+	if _, ok := query["begin"]; ok {
+		if begin, err = strconv.Atoi(query.Get("begin")); err != nil {
+			return 0, 0, errors.New("begin must be a whole number")
+		}
+	}
+	if _, ok := query["end"]; ok {
+		if end, err = strconv.Atoi(query.Get("end")); err != nil {
+			return 0, 0, errors.New("end must be a whole number")
+		}
+	}
+	if end != 0 && begin > end {
+		return 0, 0, errors.New("begin must be smaller than end")
+	}
+	return begin, end, nil
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"Assignment2/caching"
+	"Assignment2/consts"
+	"Assignment2/util"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerSummary(t *testing.T) {
+	var dataset util.CountryDataset
+	if err := dataset.Initialize("." + consts.DataSetPath); err != nil {
+		t.Fatal(err)
+	}
+	routes := HandlerRenew(make(chan caching.CacheRequest), &dataset, caching.NewInvocationRecorder(10))
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, consts.RenewablesPath+summaryPath+path, nil))
+		return recorder
+	}
+
+	recorder := get("/2021")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	summary := util.YearSummary{}
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&summary))
+	assert.Equal(t, 2021, summary.Year)
+	assert.Greater(t, summary.Count, 0)
+	assert.LessOrEqual(t, summary.Minimum.Percentage, summary.LowerQuartile)
+	assert.LessOrEqual(t, summary.LowerQuartile, summary.Median)
+	assert.LessOrEqual(t, summary.Median, summary.UpperQuartile)
+	assert.LessOrEqual(t, summary.UpperQuartile, summary.Maximum.Percentage)
+
+	recorder = get("?begin=2000&end=2009")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	summaries := make([]util.YearSummary, 0)
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&summaries))
+	if assert.Len(t, summaries, 10) {
+		assert.Equal(t, 2000, summaries[0].Year)
+		assert.Equal(t, 2009, summaries[9].Year)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, get("/twenty").Code)
+	assert.Equal(t, http.StatusBadRequest, get("?begin=first").Code)
+	assert.Equal(t, http.StatusNotFound, get("/1800").Code)
+}
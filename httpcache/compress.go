@@ -0,0 +1,117 @@
+package httpcache
+
+import (
+	"Assignment2/util"
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content codings supported for responses, in order of preference.
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// minCompressSize is the size of the smallest response body worth compressing.
+const minCompressSize = 1024
+
+// bufferedWriter holds back the status and body of a response, so the response can be
+// compressed once its size is known.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// Compress compresses successful responses of handler with brotli or gzip, as accepted by the
+// client. Responses smaller than minCompressSize are sent as they are, since compressing them
+// saves little.
+func Compress(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("accept-encoding"))
+		if encoding == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		buffered := &bufferedWriter{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(buffered, r)
+
+		body := buffered.body.Bytes()
+		if buffered.status != http.StatusOK || len(body) < minCompressSize ||
+			w.Header().Get("content-encoding") != "" {
+			w.WriteHeader(buffered.status)
+			writeBody(w, r, body)
+			return
+		}
+		var compressed bytes.Buffer
+		if err := compress(&compressed, encoding, body); err != nil {
+			util.Logger(r.Context()).Error("httpcache: failed to compress response", "error", err)
+			w.WriteHeader(buffered.status)
+			writeBody(w, r, body)
+			return
+		}
+		w.Header().Set("content-encoding", encoding)
+		w.Header().Set("content-length", strconv.Itoa(compressed.Len()))
+		w.WriteHeader(buffered.status)
+		writeBody(w, r, compressed.Bytes())
+	})
+}
+
+// WriteHeader holds back the status until the body is complete.
+func (w *bufferedWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+// Write holds back the body until it is complete.
+func (w *bufferedWriter) Write(body []byte) (int, error) {
+	return w.body.Write(body)
+}
+
+// negotiateEncoding returns the preferred content coding accepted by the Accept-Encoding
+// header, or the empty string if none is accepted. Codings given a quality of 0 are refused.
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, entry := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		quality, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if found {
+			if value, err := strconv.ParseFloat(quality, 64); err != nil || value == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(coding))] = true
+	}
+	for _, encoding := range []string{encodingBrotli, encodingGzip} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compress writes the body compressed with the content coding to w.
+func compress(w io.Writer, encoding string, body []byte) error {
+	var writer io.WriteCloser
+	if encoding == encodingBrotli {
+		writer = brotli.NewWriter(w)
+	} else {
+		writer = gzip.NewWriter(w)
+	}
+	if _, err := writer.Write(body); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// writeBody writes the body of the response, logging any failure.
+func writeBody(w http.ResponseWriter, r *http.Request, body []byte) {
+	if _, err := w.Write(body); err != nil {
+		util.Logger(r.Context()).Error("httpcache: failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,131 @@
+// Package httpcache lets clients and caches reuse responses derived from the renewables dataset,
+// through validators, conditional requests and compression.
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cacheControl is the Cache-Control header of successful responses. Caches may store responses,
+// but must revalidate them on every use, so that every request reaches the handlers and is
+// counted as an invocation by webhooks.
+const cacheControl = "no-cache"
+
+// etagLength is the number of hex digits of the hash kept in entity tags.
+const etagLength = 32
+
+// Dataset is the source of the responses, identified by a version changing with its content.
+type Dataset interface {
+	Version() string
+	LoadedAt() time.Time
+}
+
+// validatedWriter sets the validators and caching directives on successful responses only,
+// keeping problem documents from being cached. Successful responses to requests for an
+// unchanged response are sent as 304 Not Modified, discarding the body.
+type validatedWriter struct {
+	http.ResponseWriter
+	etag         string
+	lastModified string
+	notModified  bool
+	wroteHeader  bool
+}
+
+// Conditional sets ETag, Last-Modified and Cache-Control headers on successful responses of
+// handler, and answers conditional requests for an unchanged response with 304 Not Modified.
+// The entity tag is derived from the version of the dataset along with the path and query of
+// the request, so it changes whenever the dataset is reloaded with new content.
+//
+// Conditional requests still reach the handler, so they are counted as invocations by webhooks
+// and get the same problem documents as other requests. Only the body of the response is saved.
+func Conditional(dataset Dataset, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := dataset.Version()
+		if version == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		etag := ETag(version, r)
+		lastModified := dataset.LoadedAt().UTC().Truncate(time.Second)
+		handler.ServeHTTP(&validatedWriter{
+			ResponseWriter: w,
+			etag:           etag,
+			lastModified:   lastModified.Format(http.TimeFormat),
+			notModified:    notModified(r, etag, lastModified),
+		}, r)
+	})
+}
+
+// ETag returns the weak entity tag of the response to the request for the dataset version.
+// Weak tags are used as the representation varies with the content coding.
+func ETag(version string, r *http.Request) string {
+	hash := sha256.Sum256([]byte(version + "\n" + r.URL.Path + "?" + r.URL.Query().Encode()))
+	return `W/"` + hex.EncodeToString(hash[:])[:etagLength] + `"`
+}
+
+// WriteHeader sets the validators if the response is successful, before writing the header.
+func (w *validatedWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status == http.StatusOK {
+		header := w.Header()
+		header.Set("etag", w.etag)
+		header.Set("last-modified", w.lastModified)
+		header.Set("cache-control", cacheControl)
+		if w.notModified {
+			header.Del("content-type")
+			status = http.StatusNotModified
+		}
+	}
+	w.notModified = w.notModified && status == http.StatusNotModified
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the header as successful if not already written, as done by http.ResponseWriter.
+// The body of 304 Not Modified responses is discarded.
+func (w *validatedWriter) Write(body []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(body), nil
+	}
+	return w.ResponseWriter.Write(body)
+}
+
+// Unwrap returns the wrapped writer, for use by http.ResponseController.
+func (w *validatedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// notModified returns true if the conditional headers of the request show the client to hold
+// the current response. If-Modified-Since is only considered without If-None-Match, as
+// required by RFC 9110.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("if-none-match"); match != "" {
+		return etagMatches(match, etag)
+	}
+	if since := r.Header.Get("if-modified-since"); since != "" {
+		modifiedSince, err := http.ParseTime(since)
+		return err == nil && !lastModified.After(modifiedSince)
+	}
+	return false
+}
+
+// etagMatches returns true if the If-None-Match header lists the entity tag or is "*", using
+// the weak comparison of entity tags.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dataset is a stand-in for the renewables dataset with a settable version.
+type dataset struct {
+	version  string
+	loadedAt time.Time
+}
+
+func (d *dataset) Version() string     { return d.version }
+func (d *dataset) LoadedAt() time.Time { return d.loadedAt }
+
+func TestConditional(t *testing.T) {
+	source := &dataset{version: "v1", loadedAt: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}
+	calls := 0
+	handler := Conditional(source, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if strings.HasSuffix(r.URL.Path, "missing") {
+			http.Error(w, "missing", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		_, _ = w.Write([]byte("[]"))
+	}))
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := serve("/history/NOR?begin=2000", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	etag := response.Header().Get("etag")
+	assert.True(t, strings.HasPrefix(etag, `W/"`))
+	assert.Equal(t, "Mon, 01 May 2023 12:00:00 GMT", response.Header().Get("last-modified"))
+	assert.Equal(t, "no-cache", response.Header().Get("cache-control"))
+
+	// The tag depends on the query, but not on the order of its parameters.
+	assert.NotEqual(t, etag, serve("/history/NOR?begin=2001", nil).Header().Get("etag"))
+	assert.Equal(t, etag, serve("/history/NOR?begin=2000&", nil).Header().Get("etag"))
+
+	calls = 0
+	response = serve("/history/NOR?begin=2000", http.Header{"If-None-Match": {`W/"other", ` + etag}})
+	assert.Equal(t, http.StatusNotModified, response.Code)
+	assert.Equal(t, etag, response.Header().Get("etag"))
+	assert.Empty(t, response.Body.Bytes())
+	assert.Empty(t, response.Header().Get("content-type"))
+	// The handler is still called, so the request is counted as an invocation.
+	assert.Equal(t, 1, calls)
+
+	// If-Modified-Since is ignored in favour of If-None-Match.
+	response = serve("/history/NOR?begin=2000", http.Header{
+		"If-None-Match":     {`W/"other"`},
+		"If-Modified-Since": {"Mon, 01 May 2023 12:00:00 GMT"},
+	})
+	assert.Equal(t, http.StatusOK, response.Code)
+	response = serve("/history/NOR?begin=2000", http.Header{"If-Modified-Since": {"Mon, 01 May 2023 12:00:00 GMT"}})
+	assert.Equal(t, http.StatusNotModified, response.Code)
+	response = serve("/history/NOR?begin=2000", http.Header{"If-Modified-Since": {"Mon, 01 May 2023 11:59:59 GMT"}})
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// Failed responses carry no validators.
+	response = serve("/history/missing", nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Empty(t, response.Header().Get("etag"))
+	assert.Empty(t, response.Header().Get("cache-control"))
+	response = serve("/history/missing", http.Header{"If-None-Match": {"*"}})
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.NotEmpty(t, response.Body.Bytes())
+
+	// A reload with new content invalidates the tags held by clients.
+	source.version = "v2"
+	response = serve("/history/NOR?begin=2000", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.NotEqual(t, etag, response.Header().Get("etag"))
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"name":"Norway","isocode":"NOR","percentage":71.5},`, 100)
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		switch r.URL.Path {
+		case "/small":
+			_, _ = w.Write([]byte("[]"))
+		case "/missing":
+			http.Error(w, large, http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte(large))
+		}
+	}))
+	serve := func(path string, acceptEncoding string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("accept-encoding", acceptEncoding)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := serve("/large", "gzip, deflate, br")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, encodingBrotli, response.Header().Get("content-encoding"))
+	assert.Equal(t, "Accept-Encoding", response.Header().Get("vary"))
+	body, err := io.ReadAll(brotli.NewReader(response.Body))
+	if assert.Nil(t, err) {
+		assert.Equal(t, large, string(body))
+	}
+
+	response = serve("/large", "gzip;q=0.5, br;q=0")
+	assert.Equal(t, encodingGzip, response.Header().Get("content-encoding"))
+	reader, err := gzip.NewReader(response.Body)
+	if assert.Nil(t, err) {
+		body, err = io.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Equal(t, large, string(body))
+	}
+
+	uncompressed := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		status         int
+		body           string
+	}{
+		{"not accepted", "/large", "", http.StatusOK, large},
+		{"unsupported coding", "/large", "deflate", http.StatusOK, large},
+		{"small body", "/small", "gzip", http.StatusOK, "[]"},
+		{"failed response", "/missing", "gzip", http.StatusNotFound, large + "\n"},
+	}
+	for _, test := range uncompressed {
+		response = serve(test.path, test.acceptEncoding)
+		assert.Equal(t, test.status, response.Code, test.name)
+		assert.Empty(t, response.Header().Get("content-encoding"), test.name)
+		assert.True(t, bytes.Equal([]byte(test.body), response.Body.Bytes()), test.name)
+	}
+}
@@ -0,0 +1,45 @@
+// Package assets embeds the static files of the service into the binary: the manual of the
+// endpoints, the renewables dataset and the responses of the countries API stub.
+package assets
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// Names of the assets.
+const (
+	Manual  = "HandlersManual.html"
+	Dataset = "renewable-share-energy.csv"
+)
+
+//go:embed HandlersManual.html renewable-share-energy.csv codes=*.json
+var embedded embed.FS
+
+// overlay serves files from override, falling back to fallback for files missing in override.
+type overlay struct {
+	override fs.FS
+	fallback fs.FS
+}
+
+// New returns the assets of the service. Files in dir replace the embedded assets of the same
+// name, while assets missing in dir are served from the binary. An empty dir returns the
+// embedded assets alone.
+func New(dir string) fs.FS {
+	if dir == "" {
+		return embedded
+	}
+	return overlay{override: os.DirFS(dir), fallback: embedded}
+}
+
+// Open opens the named file of the override directory if it exists, and the embedded file
+// otherwise.
+func (o overlay) Open(name string) (fs.File, error) {
+	file, err := o.override.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.fallback.Open(name)
+	}
+	return file, err
+}
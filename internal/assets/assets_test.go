@@ -0,0 +1,33 @@
+package assets
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	embeddedManual, err := fs.ReadFile(New(""), Manual)
+	if assert.Nil(t, err) {
+		assert.NotEmpty(t, embeddedManual)
+	}
+
+	dir := t.TempDir()
+	if err = os.WriteFile(filepath.Join(dir, Manual), []byte("<p>override</p>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	files := New(dir)
+	manual, err := fs.ReadFile(files, Manual)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "<p>override</p>", string(manual))
+	}
+	// Assets missing in the override dir are served from the binary.
+	_, err = fs.Stat(files, Dataset)
+	assert.Nil(t, err)
+	_, err = fs.Stat(files, "codes=NOR.json")
+	assert.Nil(t, err)
+	_, err = fs.Stat(files, "missing.json")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -7,25 +7,31 @@ import (
 	"Assignment2/util"
 
 	//"Assignment2/util"
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 const codesPrefix = "codes="
 
+// stubShutdownTimeout limits the time spent draining requests to the stub on shutdown.
+const stubShutdownTimeout = 5 * time.Second
+
 // For future reference https://www.iban.com/country-codes
 
-// parseFile parses a file specified by filename
+// parseFile parses the named file of the assets
 //
 // On failure: Calls log.Fatal detailing the error.
 // On success: Returns the read file as a byte slice.
-func parseFile(filePath string) []byte {
-	file, e := os.ReadFile(filePath)
+func parseFile(files fs.FS, name string) []byte {
+	file, e := fs.ReadFile(files, name)
 	if e != nil {
 		log.Fatalf("File error: %v\n", e)
 	}
@@ -36,17 +42,19 @@ func parseFile(filePath string) []byte {
 // json bodies based on input requests. Currently only simulates appropriate behaviour for the /alpha/
 // endpoint using a ?codes=xxx,xxx,xxx query.
 //
-// debug == true: Extra information is provided in log when handler is called.
+// Calls to the handler are logged at debug level.
 //
 // Example:
 // http://localhost:8888/v3.1/alpha/?codes=NOR,KOR
 // Returns json file containing data for Norway and South Korea
-func StubHandler(cfg *util.Config, filePath string) func(http.ResponseWriter, *http.Request) {
+//
+// The json bodies are read from files, such as the assets of the service.
+func StubHandler(cfg *util.Config, files fs.FS) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("content-type", "application/json")
 		path := r.URL.Path
 
-		util.LogOnDebug(cfg, "stub debug: stub handler called with path "+r.URL.Path)
+		slog.Debug("stub: handler called", "path", r.URL.Path)
 
 		switch path { // Uses switch for easy expansion
 		case consts.CountryCodePath:
@@ -54,18 +62,18 @@ func StubHandler(cfg *util.Config, filePath string) func(http.ResponseWriter, *h
 				r.URL.Query().Get("codes"),
 				func(c rune) bool { return c == ',' },
 			)
-			util.LogOnDebug(cfg, "stub debug: cca3 queries prior to filtering: ", codes)
+			slog.Debug("stub: cca3 queries prior to filtering", "codes", codes)
 
 			codes = filterCountryCodes(codes)
 			if len(codes) == 0 { // Indicates no codes of valid length [2, 3]
 				response := "{\"status\":400,\"message\":\"Bad Request\"}"
 				if _, err := fmt.Fprint(w, response); err != nil {
-					log.Println("stub handler failed to return response body to client.")
+					slog.Error("stub: handler failed to return response body to client", "error", err)
 				}
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			response, err := getJsonByCountryCode(codes, filePath)
+			response, err := getJsonByCountryCode(codes, files)
 			if err != nil {
 				response = "{\"status\":404,\"message\":\"Not Found\"}"
 				w.WriteHeader(http.StatusNotFound)
@@ -77,7 +85,7 @@ func StubHandler(cfg *util.Config, filePath string) func(http.ResponseWriter, *h
 			}
 			return
 		default:
-			util.LogOnDebug(cfg, "stub: path "+r.URL.Path+" not currently supported by stubbing service.")
+			slog.Debug("stub: path not currently supported by stubbing service", "path", r.URL.Path)
 			http.Error(w, "Not a recognized path for stubbing", http.StatusNotImplemented)
 		}
 	}
@@ -85,13 +93,14 @@ func StubHandler(cfg *util.Config, filePath string) func(http.ResponseWriter, *h
 
 // getJsonByCountryCode takes a slice of country codes, returning all results
 // for those country codes.
-// WARNING: For any simulated response there must be a .json file in the /internal/assets directory.
+// WARNING: For any simulated response there must be a .json file in the assets, see the
+// internal/assets directory.
 // For the simulation of invalid requests, use an empty .json file, such as codes=INV.json
 // Attempting to read a non-existing file will intentionally crash the application.
-func getJsonByCountryCode(countryCodes []string, filePath string) (string, error) {
+func getJsonByCountryCode(countryCodes []string, files fs.FS) (string, error) {
 	countryData := make([]string, 0)
 	for _, code := range countryCodes {
-		data := string(parseFile(filePath + codesPrefix + code + ".json"))
+		data := string(parseFile(files, codesPrefix+code+".json"))
 		if len(data) >= 2 {
 			data = strings.TrimPrefix(strings.TrimSuffix(data, "]"), "[")
 			countryData = append(countryData, data)
@@ -118,24 +127,25 @@ func filterCountryCodes(countryCodes []string) []string {
 
 // RunSTUBServer runs a stubbing service using the net/http module.
 // See StubHandler for closer detail on what stubbing is provided by the service.
-func RunSTUBServer(cfg *util.Config, group *sync.WaitGroup, path string, port string, stop chan struct{}) {
+func RunSTUBServer(cfg *util.Config, group *sync.WaitGroup, files fs.FS, port string, stop chan struct{}) {
 	defer group.Done()
 
-	log.Println("stub: service running on port", port)
+	slog.Info("stub: service running", "port", port)
 
 	server := http.Server{
 		Addr:    ":" + port,
-		Handler: http.HandlerFunc(StubHandler(cfg, path)),
+		Handler: http.HandlerFunc(StubHandler(cfg, files)),
 	}
 
 	go func() {
 		err := server.ListenAndServe()
-		log.Println("stub: service shut down: ", err)
+		slog.Info("stub: service shut down", "reason", err)
 	}()
 
 	<-stop // waits on stop signal to shut down the stub server
-	if err := server.Shutdown(nil); err != nil {
-		log.Println("stub: failed to properly shut down stubbing service")
+	ctx, cancel := context.WithTimeout(context.Background(), stubShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("stub: failed to properly shut down stubbing service", "error", err)
 	}
-
 }
@@ -2,6 +2,7 @@ package stubbing
 
 import (
 	"Assignment2/consts"
+	"Assignment2/internal/assets"
 	"Assignment2/util"
 	"context"
 	"encoding/json"
@@ -58,7 +59,7 @@ func TestHttpStubbing(t *testing.T) {
 		PrimaryCache:      "TestData",
 	}
 
-	handler := StubHandler(&config, "../assets/")
+	handler := StubHandler(&config, assets.New(""))
 	log.Println(os.Getwd())
 	server := httptest.NewServer(http.HandlerFunc(handler))
 	defer server.Close()
@@ -134,7 +135,7 @@ func TestRunSTUBServer(t *testing.T) {
 	stubStop := make(chan struct{})
 	if config.DevelopmentMode {
 		wg.Add(1)
-		go RunSTUBServer(&config, &wg, "./internal/assets/", consts.StubPort, stubStop)
+		go RunSTUBServer(&config, &wg, assets.New(""), consts.StubPort, stubStop)
 	}
 	stubStop <- struct{}{}
 }
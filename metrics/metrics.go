@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// namespace prefixes the name of every metric exposed by the service.
+const namespace = "energy"
+
+// Labels used by the metrics of the service.
+const (
+	labelHandler  = "handler"
+	labelCode     = "code"
+	labelMethod   = "method"
+	labelResult   = "result"
+	labelUpstream = "upstream"
+	labelOutcome  = "outcome"
+)
+
+// Cache lookup results.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// Webhook delivery outcomes.
+const (
+	DeliverySuccess = "success"
+	DeliveryFailure = "failure"
+)
+
+// Outcomes of invocations reported by the endpoint handlers.
+const (
+	ReportQueued   = "queued"
+	ReportOverflow = "overflow"
+	ReportDropped  = "dropped"
+)
+
+// Upstream services called by the service.
+const (
+	UpstreamCountries = "countries"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of http requests handled, by handler, method and status code.",
+	}, []string{labelHandler, labelMethod, labelCode})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http requests, by handler, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{labelHandler, labelMethod, labelCode})
+
+	// CacheLookups counts lookups of country codes in the local cache by result.
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Number of country codes looked up in the local cache, by result.",
+	}, []string{labelResult})
+
+	// CacheSize is the number of entries in the local cache.
+	CacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_entries",
+		Help:      "Number of entries in the local cache.",
+	})
+
+	// UpstreamRequestDuration observes the latency of requests made to upstream services.
+	UpstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of requests to upstream services, by upstream.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{labelUpstream})
+
+	// UpstreamErrors counts failed requests to upstream services.
+	UpstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Number of failed requests to upstream services, by upstream.",
+	}, []string{labelUpstream})
+
+	// WebhookDeliveries counts messages posted to webhook receivers by outcome.
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Number of messages posted to webhook receivers, by outcome.",
+	}, []string{labelOutcome})
+
+	// RateLimitedRequests counts requests rejected for exceeding the rate limit of the client.
+	RateLimitedRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of requests rejected for exceeding the rate limit of the client.",
+	})
+
+	// InvocationReports counts invocations reported to the invocation worker by outcome.
+	InvocationReports = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "invocation_reports_total",
+		Help:      "Number of invocations reported to the invocation worker, by outcome.",
+	}, []string{labelOutcome})
+
+	// DatasetCountries is the number of countries in the renewables dataset.
+	DatasetCountries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dataset_countries",
+		Help:      "Number of countries in the renewables dataset.",
+	})
+)
+
+// Handler returns the http handler exposing all registered metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// InstrumentHandler wraps the handler, counting requests and observing their latency
+// labeled with the name of the handler, the http method and the response status code.
+func InstrumentHandler(name string, handler http.HandlerFunc) http.HandlerFunc {
+	labels := prometheus.Labels{labelHandler: name}
+	return promhttp.InstrumentHandlerDuration(httpRequestDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels), handler))
+}
+
+// RegisterChannelDepth exposes the number of queued elements of a channel as a gauge,
+// evaluated through the supplied function whenever metrics are collected.
+func RegisterChannelDepth(name string, help string, depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, func() float64 { return float64(depth()) })
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	handler := InstrumentHandler("test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	for i := 0; i < 2; i++ {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+	}
+	requests := httpRequests.WithLabelValues("test", "get", "418")
+	assert.Equal(t, float64(2), testutil.ToFloat64(requests))
+	assert.Equal(t, 1, testutil.CollectAndCount(httpRequestDuration))
+}
+
+func TestHandler(t *testing.T) {
+	DatasetCountries.Set(3)
+	RegisterChannelDepth("test_channel_depth", "Test channel.", func() int { return 7 })
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	assert.True(t, strings.Contains(body, "energy_dataset_countries 3"))
+	assert.True(t, strings.Contains(body, "energy_test_channel_depth 7"))
+}
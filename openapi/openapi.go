@@ -0,0 +1,53 @@
+// Package openapi serves the OpenAPI specification of the service, along with an interactive
+// documentation page rendering it.
+package openapi
+
+import (
+	"Assignment2/consts"
+	"Assignment2/util"
+	"bytes"
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+//go:embed docs.html
+var docsTemplate string
+
+// docsPage is the documentation page, fetching the specification from consts.OpenAPIPath.
+var docsPage = renderDocs()
+
+// Spec returns the OpenAPI specification of the service, encoded as JSON.
+func Spec() []byte {
+	return bytes.Clone(spec)
+}
+
+// SpecHandler responds with the OpenAPI specification of the service.
+func SpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	if _, err := w.Write(spec); err != nil {
+		util.Logger(r.Context()).Error("openapi: failed to write specification", "error", err)
+	}
+}
+
+// DocsHandler responds with an interactive documentation page rendering the specification.
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	if _, err := w.Write(docsPage); err != nil {
+		util.Logger(r.Context()).Error("openapi: failed to write documentation page", "error", err)
+	}
+}
+
+// renderDocs renders the documentation page. The template is embedded, so failing to render
+// it is a programming error.
+func renderDocs() []byte {
+	page := template.Must(template.New("docs").Parse(docsTemplate))
+	var buffer bytes.Buffer
+	if err := page.Execute(&buffer, struct{ SpecPath string }{consts.OpenAPIPath}); err != nil {
+		panic(err)
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,33 @@
+package openapi
+
+import (
+	"Assignment2/consts"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpecHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	SpecHandler(recorder, httptest.NewRequest(http.MethodGet, consts.OpenAPIPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("content-type"))
+	document := struct {
+		OpenAPI string         `json:"openapi"`
+		Paths   map[string]any `json:"paths"`
+	}{}
+	if assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&document)) {
+		assert.Equal(t, "3.0.3", document.OpenAPI)
+		assert.Contains(t, document.Paths, consts.RenewablesPath+"current/{country}")
+	}
+}
+
+func TestDocsHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	DocsHandler(recorder, httptest.NewRequest(http.MethodGet, consts.DocsPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	// The path is escaped as a JavaScript string by the template.
+	assert.Contains(t, recorder.Body.String(), `\/energy\/v1\/openapi.json`)
+}
@@ -0,0 +1,161 @@
+// Package ratelimit limits the rate of requests made by each client of the service.
+package ratelimit
+
+import (
+	"Assignment2/metrics"
+	"Assignment2/util"
+	"golang.org/x/time/rate"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// APIKeyHeader is the header identifying the client by an API key.
+const APIKeyHeader = "X-API-Key"
+
+// Headers informing clients of their limits, following the IETF RateLimit header fields draft.
+const (
+	headerLimit      = "RateLimit-Limit"
+	headerRemaining  = "RateLimit-Remaining"
+	headerReset      = "RateLimit-Reset"
+	headerRetryAfter = "Retry-After"
+)
+
+// Prefixes of client keys, keeping API keys and addresses apart.
+const (
+	prefixAPIKey  = "key:"
+	prefixAddress = "ip:"
+)
+
+// idleTimeout is the time after which the bucket of an idle client is discarded. By then
+// the bucket has long been refilled, so the client starts off the same with a new bucket.
+const idleTimeout = 10 * time.Minute
+
+// client holds the token bucket of a client, along with the time it was last seen.
+type client struct {
+	bucket   *rate.Limiter
+	lastSeen time.Time
+}
+
+// quota is the outcome of a request of a client, in whole requests and seconds.
+type quota struct {
+	allowed    bool
+	remaining  int // requests the client may make right away
+	reset      int // seconds until the bucket of the client is refilled
+	retryAfter int // seconds until the client may make another request
+}
+
+// Limiter limits the rate of requests of each client with a token bucket per client.
+type Limiter struct {
+	mutex     sync.Mutex
+	limit     rate.Limit
+	burst     int
+	keys      map[string]bool
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+// NewLimiter returns a limiter applying the rate limits set in the config, or nil if rate
+// limiting is disabled. A nil limiter lets every request through.
+func NewLimiter(cfg *util.Config) *Limiter {
+	if cfg.RateLimitPerMinute <= 0 {
+		return nil
+	}
+	keys := make(map[string]bool, len(cfg.RateLimitKeys))
+	for _, key := range cfg.RateLimitKeys {
+		keys[key] = true
+	}
+	return &Limiter{
+		limit:   rate.Limit(float64(cfg.RateLimitPerMinute) / time.Minute.Seconds()),
+		burst:   cfg.RateLimitBurst,
+		keys:    keys,
+		clients: make(map[string]*client),
+	}
+}
+
+// Limit wraps the handler, responding with 429 Too Many Requests to clients exceeding their
+// rate limit. Every response carries the limit, the remaining requests and the seconds until
+// the bucket of the client is refilled.
+func (l *Limiter) Limit(handler http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := l.take(l.clientKey(r), time.Now())
+		w.Header().Set(headerLimit, strconv.Itoa(l.burst))
+		w.Header().Set(headerRemaining, strconv.Itoa(q.remaining))
+		w.Header().Set(headerReset, strconv.Itoa(q.reset))
+		if !q.allowed {
+			metrics.RateLimitedRequests.Inc()
+			w.Header().Set(headerRetryAfter, strconv.Itoa(q.retryAfter))
+			util.Logger(r.Context()).Info("rate limit: request rejected", "retry_after", q.retryAfter)
+			util.WriteProblem(w, r, http.StatusTooManyRequests, util.ProblemRateLimited,
+				"rate limit exceeded, retry after "+strconv.Itoa(q.retryAfter)+" seconds")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// clientKey identifies the client making the request by its API key if the key is known,
+// or by its address otherwise. Unknown keys are ignored, as clients could otherwise escape
+// their limit by sending a new key with every request.
+func (l *Limiter) clientKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" && l.keys[key] {
+		return prefixAPIKey + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return prefixAddress + host
+}
+
+// take takes a token from the bucket of the client if one is available, returning the
+// resulting quota of the client.
+func (l *Limiter) take(key string, now time.Time) quota {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sweep(now)
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{bucket: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	allowed := c.bucket.AllowN(now, 1)
+	tokens := math.Max(c.bucket.TokensAt(now), 0)
+	return quota{
+		allowed:    allowed,
+		remaining:  int(tokens),
+		reset:      l.secondsUntil(float64(l.burst) - tokens),
+		retryAfter: l.secondsUntil(1 - tokens),
+	}
+}
+
+// secondsUntil returns the whole seconds until the given number of tokens have been added
+// to a bucket.
+func (l *Limiter) secondsUntil(tokens float64) int {
+	if tokens <= 0 {
+		return 0
+	}
+	return int(math.Ceil(tokens / float64(l.limit)))
+}
+
+// sweep discards the buckets of clients idle for longer than idleTimeout. The buckets are
+// swept at most once every idleTimeout.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTimeout {
+		return
+	}
+	for key, c := range l.clients {
+		if now.Sub(c.lastSeen) >= idleTimeout {
+			delete(l.clients, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"Assignment2/util"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter allowing 60 requests per minute in bursts of 2, with key as
+// its only API key.
+func newTestLimiter(key string) *Limiter {
+	cfg := util.Config{}
+	cfg.InitializeWithDefaults()
+	cfg.RateLimitPerMinute = 60
+	cfg.RateLimitBurst = 2
+	cfg.RateLimitKeys = []string{key}
+	return NewLimiter(&cfg)
+}
+
+func TestNewLimiter(t *testing.T) {
+	cfg := util.Config{}
+	cfg.InitializeWithDefaults()
+	cfg.RateLimitPerMinute = 0
+	limiter := NewLimiter(&cfg)
+	assert.Nil(t, limiter)
+
+	// a nil limiter lets every request through
+	handler := limiter.Limit(func(w http.ResponseWriter, r *http.Request) {})
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get(headerLimit))
+}
+
+func TestLimit(t *testing.T) {
+	handler := newTestLimiter("known").Limit(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(address string, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/energy/v1/renewables/current", nil)
+		r.RemoteAddr = address
+		if key != "" {
+			r.Header.Set(APIKeyHeader, key)
+		}
+		recorder := httptest.NewRecorder()
+		handler(recorder, r)
+		return recorder
+	}
+
+	first := request("10.0.0.1:1234", "")
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "2", first.Header().Get(headerLimit))
+	assert.Equal(t, "1", first.Header().Get(headerRemaining))
+	assert.Equal(t, "1", first.Header().Get(headerReset))
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1235", "").Code)
+
+	limited := request("10.0.0.1:1236", "")
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, "0", limited.Header().Get(headerRemaining))
+	assert.Equal(t, "1", limited.Header().Get(headerRetryAfter))
+
+	// unknown keys are limited by address, while known keys have buckets of their own
+	assert.Equal(t, http.StatusTooManyRequests, request("10.0.0.1:1237", "unknown").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1238", "known").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:1234", "").Code)
+}
+
+func TestTake(t *testing.T) {
+	limiter := newTestLimiter("")
+	now := time.Now()
+	assert.True(t, limiter.take("client", now).allowed)
+	assert.True(t, limiter.take("client", now).allowed)
+	q := limiter.take("client", now)
+	assert.False(t, q.allowed)
+	assert.Equal(t, 2, q.reset)
+
+	// a token is added every second
+	q = limiter.take("client", now.Add(time.Second))
+	assert.True(t, q.allowed)
+	assert.Equal(t, 0, q.remaining)
+
+	// idle clients are swept
+	limiter.take("other", now.Add(idleTimeout+time.Second))
+	assert.Len(t, limiter.clients, 1)
+}
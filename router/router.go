@@ -0,0 +1,181 @@
+// Package router routes requests to handlers by method and path, passing path parameters
+// to the handlers through the request context.
+package router
+
+import (
+	"Assignment2/consts"
+	"Assignment2/util"
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Delimiters of path parameters in route patterns, as in /notifications/{id}.
+const (
+	paramPrefix = "{"
+	paramSuffix = "}"
+)
+
+// paramsKey is the context key of the path parameters of a request.
+type paramsKey struct{}
+
+// route is a handler registered for a method and a path pattern split into segments.
+type route struct {
+	method   string
+	segments []string
+	handler  http.Handler
+}
+
+// Router is an http handler routing requests to the handler registered for the method and
+// path of the request. Requests for paths without a route are answered with 404 Not Found,
+// while requests for a path with routes for other methods only are answered with 405 Method
+// Not Allowed. Both are answered with problem documents.
+type Router struct {
+	routes    []route
+	unmatched http.Handler
+}
+
+// New returns a router without any routes.
+func New() *Router {
+	rt := &Router{routes: make([]route, 0)}
+	rt.unmatched = http.HandlerFunc(rt.serveUnmatched)
+	return rt
+}
+
+// Handle registers the handler for requests with the method and a path matching the pattern.
+// Segments of the pattern enclosed in braces are path parameters, matching any single segment
+// of the path. Trailing slashes and empty segments are ignored, and routes for GET also serve
+// HEAD requests.
+func (rt *Router) Handle(method string, pattern string, handler http.Handler) {
+	rt.routes = append(rt.routes, route{method: method, segments: splitPath(pattern), handler: handler})
+}
+
+// HandleFunc registers the handler function for requests with the method and a path matching
+// the pattern, see Handle.
+func (rt *Router) HandleFunc(method string, pattern string, handler http.HandlerFunc) {
+	rt.Handle(method, pattern, handler)
+}
+
+// Merge registers every route of other with this router, wrapping their handlers with wrap.
+func (rt *Router) Merge(other *Router, wrap func(http.Handler) http.Handler) {
+	for _, r := range other.routes {
+		rt.routes = append(rt.routes, route{method: r.method, segments: r.segments, handler: wrap(r.handler)})
+	}
+}
+
+// WrapUnmatched wraps the handler answering requests without a matching route with wrap.
+func (rt *Router) WrapUnmatched(wrap func(http.Handler) http.Handler) {
+	rt.unmatched = wrap(http.HandlerFunc(rt.serveUnmatched))
+}
+
+// ServeHTTP routes the request to the handler of the most specific route matching its method
+// and path, being the route with the most literal segments.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+	var matched *route
+	var matchedParams map[string]string
+	for i := range rt.routes {
+		candidate := &rt.routes[i]
+		if !candidate.serves(r.Method) {
+			continue
+		}
+		params, ok := candidate.match(segments)
+		if ok && (matched == nil || literals(candidate.segments) > literals(matched.segments)) {
+			matched, matchedParams = candidate, params
+		}
+	}
+	if matched == nil {
+		rt.unmatched.ServeHTTP(w, r)
+		return
+	}
+	ctx := context.WithValue(r.Context(), paramsKey{}, matchedParams)
+	matched.handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// serveUnmatched answers requests without a matching route, with 405 Method Not Allowed
+// listing the allowed methods if the path has routes for other methods, and with 404 Not
+// Found otherwise.
+func (rt *Router) serveUnmatched(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+	allowed := make(map[string]bool)
+	for _, candidate := range rt.routes {
+		if _, ok := candidate.match(segments); ok {
+			allowed[candidate.method] = true
+			if candidate.method == http.MethodGet {
+				allowed[http.MethodHead] = true
+			}
+		}
+	}
+	if len(allowed) == 0 {
+		util.WriteProblem(w, r, http.StatusNotFound, util.ProblemNotFound,
+			"no endpoint at "+r.URL.Path+", see "+consts.UsagePath+" for the available endpoints")
+		return
+	}
+	methods := make([]string, 0, len(allowed))
+	for method := range allowed {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	w.Header().Set("allow", strings.Join(methods, ", "))
+	util.WriteProblem(w, r, http.StatusMethodNotAllowed, util.ProblemMethodNotAllowed,
+		"method "+r.Method+" is not supported, use one of "+strings.Join(methods, ", "))
+}
+
+// Param returns the value of the named path parameter of the request, or the empty string
+// if the route of the request has no such parameter.
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+// WithParams returns a copy of the request carrying the path parameters, as set by the router.
+// Intended for calling handlers directly, such as in tests.
+func WithParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+}
+
+// match returns the path parameters of the route if the path segments match its pattern.
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, segment := range rt.segments {
+		if name, ok := paramName(segment); ok {
+			params[name] = segments[i]
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// serves returns true if the route serves requests with the method.
+func (rt *route) serves(method string) bool {
+	return rt.method == method || (method == http.MethodHead && rt.method == http.MethodGet)
+}
+
+// paramName returns the name of the path parameter if the pattern segment is a parameter.
+func paramName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, paramPrefix) && strings.HasSuffix(segment, paramSuffix) {
+		return strings.TrimSuffix(strings.TrimPrefix(segment, paramPrefix), paramSuffix), true
+	}
+	return "", false
+}
+
+// literals returns the number of segments of a pattern that are not path parameters.
+func literals(segments []string) int {
+	count := 0
+	for _, segment := range segments {
+		if _, ok := paramName(segment); !ok {
+			count++
+		}
+	}
+	return count
+}
+
+// splitPath splits a path into its non-empty segments.
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(c rune) bool { return c == '/' })
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"Assignment2/util"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestRouter returns a router with routes responding with the name of the route
+// followed by the id path parameter.
+func newTestRouter() *Router {
+	respond := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name + Param(r, "id")))
+		}
+	}
+	rt := New()
+	rt.HandleFunc(http.MethodGet, "/webhooks/", respond("list"))
+	rt.HandleFunc(http.MethodGet, "/webhooks/{id}", respond("view "))
+	rt.HandleFunc(http.MethodDelete, "/webhooks/{id}", respond("delete "))
+	rt.HandleFunc(http.MethodGet, "/webhooks/latest", respond("latest"))
+	rt.HandleFunc(http.MethodPost, "/webhooks/{id}/pause", respond("pause "))
+	return rt
+}
+
+func TestRouter(t *testing.T) {
+	rt := newTestRouter()
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{http.MethodGet, "/webhooks", http.StatusOK, "list"},
+		{http.MethodGet, "/webhooks//", http.StatusOK, "list"},
+		{http.MethodGet, "/webhooks/abc", http.StatusOK, "view abc"},
+		{http.MethodGet, "/webhooks/south%20korea/", http.StatusOK, "view south korea"},
+		{http.MethodHead, "/webhooks/abc", http.StatusOK, ""},
+		{http.MethodDelete, "/webhooks/abc", http.StatusOK, "delete abc"},
+		{http.MethodGet, "/webhooks/latest", http.StatusOK, "latest"},
+		{http.MethodPost, "/webhooks/abc/pause", http.StatusOK, "pause abc"},
+		{http.MethodPost, "/webhooks/abc/resume", http.StatusNotFound, ""},
+		{http.MethodGet, "/unknown", http.StatusNotFound, ""},
+		{http.MethodPut, "/webhooks/abc", http.StatusMethodNotAllowed, ""},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		rt.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+		assert.Equal(t, test.code, recorder.Code, test.method+" "+test.path)
+		if test.code == http.StatusOK {
+			if test.method != http.MethodHead {
+				assert.Equal(t, test.body, recorder.Body.String())
+			}
+			continue
+		}
+		assert.Equal(t, util.ProblemContentType, recorder.Header().Get("content-type"))
+		problem := util.Problem{}
+		if assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&problem)) {
+			assert.Equal(t, test.code, problem.Status)
+			assert.NotEmpty(t, problem.Code)
+		}
+		if test.code == http.StatusMethodNotAllowed {
+			assert.Equal(t, "DELETE, GET, HEAD", recorder.Header().Get("allow"))
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	wrapped := 0
+	rt := New()
+	rt.Merge(newTestRouter(), func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrapped++
+			handler.ServeHTTP(w, r)
+		})
+	})
+	rt.WrapUnmatched(func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrapped += 10
+			handler.ServeHTTP(w, r)
+		})
+	})
+
+	recorder := httptest.NewRecorder()
+	rt.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhooks/abc", nil))
+	assert.Equal(t, "view abc", recorder.Body.String())
+	recorder = httptest.NewRecorder()
+	rt.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, 11, wrapped)
+}
+
+func TestWithParams(t *testing.T) {
+	r := WithParams(httptest.NewRequest(http.MethodGet, "/", nil), map[string]string{"id": "abc"})
+	assert.Equal(t, "abc", Param(r, "id"))
+	assert.Equal(t, "", Param(httptest.NewRequest(http.MethodGet, "/", nil), "id"))
+}
@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"Assignment2/consts"
+	"Assignment2/util"
+	"context"
+	"errors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+)
+
+// serviceName identifies the service in exported traces.
+const serviceName = "energy"
+
+// tracerName is the name of the tracer creating the spans of the service.
+const tracerName = "Assignment2"
+
+// SetUpTracing installs a global tracer provider exporting spans as set up in the config,
+// along with W3C trace context propagation. Output of the stdout exporter is written to w.
+// The returned function flushes any buffered spans and must be called on shutdown.
+//
+// On success: shutdown function, nil
+// On failure: nil, error
+func SetUpTracing(ctx context.Context, cfg *util.Config, w io.Writer) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.TraceExporter {
+	case util.TraceExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case util.TraceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case util.TraceExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx,
+			otlptracehttp.WithEndpoint(cfg.TraceEndpoint),
+			otlptracehttp.WithInsecure())
+	default:
+		return nil, errors.New("tracing: unknown exporter " + cfg.TraceExporter)
+	}
+	if err != nil {
+		return nil, errors.New("tracing: failed to create exporter: " + err.Error())
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(consts.Version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the service.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// StartSpan starts a span as a child of any span carried by ctx. A nil ctx, as carried by
+// requests not originating from a handler, starts a new trace.
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// EndSpan records err on the span if not nil, marking the span as failed, before ending it.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InstrumentHandler wraps the handler, starting a server span named after the handler for
+// every request. Trace context supplied by the client is continued.
+func InstrumentHandler(name string, handler http.HandlerFunc) http.Handler {
+	return otelhttp.NewHandler(handler, name)
+}
+
+// InstrumentClient sets up the client to start a span for every outbound request,
+// propagating the trace context to the receiver.
+func InstrumentClient(client *http.Client) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = otelhttp.NewTransport(transport)
+	return client
+}
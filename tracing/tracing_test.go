@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"Assignment2/util"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetUpTracing(t *testing.T) {
+	cfg := util.Config{}
+	cfg.InitializeWithDefaults()
+	shutdown, err := SetUpTracing(context.Background(), &cfg, nil)
+	if assert.Nil(t, err) {
+		assert.Nil(t, shutdown(context.Background()))
+	}
+
+	cfg.TraceExporter = "zipkin"
+	_, err = SetUpTracing(context.Background(), &cfg, nil)
+	assert.Error(t, err)
+
+	var output bytes.Buffer
+	cfg.TraceExporter = util.TraceExporterStdout
+	shutdown, err = SetUpTracing(context.Background(), &cfg, &output)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, span := StartSpan(nil, "test span")
+	EndSpan(span, errors.New("test failure"))
+	assert.Nil(t, shutdown(context.Background()))
+	assert.True(t, strings.Contains(output.String(), "test span"))
+	assert.True(t, strings.Contains(output.String(), "test failure"))
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	var output bytes.Buffer
+	cfg := util.Config{}
+	cfg.InitializeWithDefaults()
+	cfg.TraceExporter = util.TraceExporterStdout
+	shutdown, err := SetUpTracing(context.Background(), &cfg, &output)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the receiver sees the trace context propagated from the client span
+	var traceParent string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+	}))
+	defer receiver.Close()
+	client := InstrumentClient(&http.Client{})
+
+	handler := InstrumentHandler("test", func(w http.ResponseWriter, r *http.Request) {
+		request, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, receiver.URL, nil)
+		if response, err := client.Do(request); err == nil {
+			_ = response.Body.Close()
+		}
+	})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+	assert.NotEmpty(t, traceParent)
+
+	assert.Nil(t, shutdown(context.Background()))
+	assert.True(t, strings.Contains(output.String(), "\"Name\":\"test\""))
+}
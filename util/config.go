@@ -1,11 +1,15 @@
 package util
 
 import (
+	"Assignment2/consts"
 	"bytes"
 	"cloud.google.com/go/firestore"
 	"context"
 	"errors"
+	"fmt"
 	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
 	"os"
 	"time"
 )
@@ -13,11 +17,40 @@ import (
 const SettingsCachePushRate = 5 * time.Second
 const SettingsCacheTimeLimit = 1 * time.Hour
 const SettingsWebhookEventRate = 10 * time.Second
+const SettingsShutdownTimeout = 15 * time.Second
 const SettingsDebugMode = true
 const SettingsDevelopmentMode = true
 const SettingsCachingCollection = "Caches"
 const SettingsPrimaryCache = "TestData"
 const SettingsWebhookCollection = "Webhooks"
+const SettingsLeaseCollection = "Leases"
+const SettingsEventCollection = "Events"
+const SettingsWebhookVerification = false
+const SettingsAllowPrivateWebhooks = false
+const SettingsWebhookMaxFailures = 5
+const SettingsLogLevel = slog.LevelInfo
+const SettingsLogFormat = LogFormatJSON
+const SettingsTraceExporter = TraceExporterNone
+const SettingsTraceEndpoint = "localhost:4318"
+const SettingsTraceSampleRatio = 1.0
+const SettingsPort = consts.DefaultPort
+const SettingsStubPort = consts.StubPort
+const SettingsGRPCPort = consts.DefaultGRPCPort
+const SettingsCountriesDomain = consts.CountryDomain
+const SettingsDatasetPath = "" // empty loads the dataset from the assets
+const SettingsAssetsDir = ""   // empty serves the embedded assets alone
+const SettingsCredentialsPath = consts.CredentialsPath
+const SettingsRateLimitPerMinute = 120
+const SettingsRateLimitBurst = 20
+const SettingsGraphQLMaxDepth = 5
+const SettingsGraphQLMaxCost = 1000
+
+// Exporters of trace spans.
+const (
+	TraceExporterNone   = "none"
+	TraceExporterStdout = "stdout"
+	TraceExporterOTLP   = "otlp"
+)
 
 const minimumWebhookInterval = 10
 
@@ -26,6 +59,7 @@ type Config struct {
 	CachePushRate     time.Duration // Cache is pushed to external DB with CachePushRate as its interval
 	CacheTimeLimit    time.Duration // Cache entries older than CacheTimeLimit are purged upon loading
 	WebhookEventRate  time.Duration // How often registered webhooks should be checked for event triggers
+	ShutdownTimeout   time.Duration // Time allowed for in-flight requests to complete on shutdown
 	DebugMode         bool          // toggles any extra debug features such as extra logging of events
 	DevelopmentMode   bool          // Sets the service to use stubbing of external APIs
 	Ctx               *context.Context
@@ -33,6 +67,35 @@ type Config struct {
 	CachingCollection string
 	PrimaryCache      string
 	WebhookCollection string
+	LeaseCollection   string // Holds the lease deciding which service instance delivers webhook events
+	EventCollection   string // Holds threshold events, watched by every service instance
+
+	WebhookVerification  bool  // Requires webhook receivers to echo a challenge upon registration
+	AllowPrivateWebhooks bool  // Permits webhook urls resolving to private, loopback or link-local addresses
+	WebhookMaxFailures   int32 // Consecutive failed deliveries before a webhook is paused
+
+	LogLevel  slog.Level // Minimum level of logged events, lowered to debug by DebugMode
+	LogFormat string     // Output format of logged events, json or text
+
+	TraceExporter    string  // Exporter of trace spans, none, stdout or otlp
+	TraceEndpoint    string  // host:port of the OTLP collector receiving spans over http
+	TraceSampleRatio float64 // Share of new traces recorded, from 0 to 1
+
+	Port            string // Port the service listens on
+	StubPort        string // Port the stub of the countries API listens on in development mode
+	GRPCPort        string // Port the gRPC services listen on
+	CountriesDomain string // Base url of the countries API used outside of development mode
+	DatasetPath     string // Path of the csv file holding the renewables dataset, read from the assets if empty
+	AssetsDir       string // Directory of files replacing the embedded assets of the same name
+	CredentialsPath string // Path of the firebase service account credentials
+	ConfigPath      string // Path of the config file the settings were loaded from, set by LoadConfig
+
+	RateLimitPerMinute int      // Requests each client may make per minute to the API, 0 disables limiting
+	RateLimitBurst     int      // Requests each client may make in a burst before being limited
+	RateLimitKeys      []string // API keys limited on their own, rather than by the address of the client
+
+	GraphQLMaxDepth int // Deepest nesting of fields allowed in GraphQL queries
+	GraphQLMaxCost  int // Highest estimated cost of the fields of GraphQL queries
 }
 
 // configYAML is used to decode the settings from the project config.yaml file.
@@ -41,18 +104,140 @@ type configYAML struct {
 		CachePushRate    int `yaml:"cache-push-rate"`
 		CacheTimeLimit   int `yaml:"cache-time-limit"`
 		WebhookEventRate int `yaml:"webhook-event-rate"`
+		ShutdownTimeout  int `yaml:"shutdown-timeout"`
 	} `yaml:"time-intervals"`
 
 	Deployment struct {
-		DebugMode       bool `yaml:"debug-mode"`
-		DevelopmentMode bool `yaml:"development-mode"`
+		DebugMode       *bool `yaml:"debug-mode"`
+		DevelopmentMode *bool `yaml:"development-mode"`
 	} `yaml:"deployment-variables"`
 
 	Firebase struct {
 		CachingCollectionName    string `yaml:"caching-collection-name"`
 		PrimaryCacheDocumentName string `yaml:"primary-cache-document-name"`
 		WebhookCollectionName    string `yaml:"webhook-collection-name"`
+		LeaseCollectionName      string `yaml:"lease-collection-name"`
+		EventCollectionName      string `yaml:"event-collection-name"`
 	} `yaml:"firebase-variables"`
+
+	Webhooks struct {
+		VerifyOnRegistration  *bool `yaml:"verify-on-registration"`
+		AllowPrivateAddresses *bool `yaml:"allow-private-addresses"`
+		MaxFailures           int32 `yaml:"max-failures"`
+	} `yaml:"webhook-variables"`
+
+	Logging struct {
+		Level  string `yaml:"level"`
+		Format string `yaml:"format"`
+	} `yaml:"logging-variables"`
+
+	Tracing struct {
+		Exporter    string   `yaml:"exporter"`
+		Endpoint    string   `yaml:"otlp-endpoint"`
+		SampleRatio *float64 `yaml:"sample-ratio"`
+	} `yaml:"tracing-variables"`
+
+	Service struct {
+		Port            string `yaml:"port"`
+		StubPort        string `yaml:"stub-port"`
+		GRPCPort        string `yaml:"grpc-port"`
+		CountriesDomain string `yaml:"countries-domain"`
+		DatasetPath     string `yaml:"dataset-path"`
+		AssetsDir       string `yaml:"assets-dir"`
+		CredentialsPath string `yaml:"credentials-path"`
+	} `yaml:"service-variables"`
+
+	RateLimits struct {
+		RequestsPerMinute *int     `yaml:"requests-per-minute"`
+		Burst             int      `yaml:"burst"`
+		APIKeys           []string `yaml:"api-keys"`
+	} `yaml:"rate-limit-variables"`
+
+	GraphQL struct {
+		MaxDepth int `yaml:"max-depth"`
+		MaxCost  int `yaml:"max-cost"`
+	} `yaml:"graphql-variables"`
+}
+
+// EffectiveConfig is the json representation of the settings in use by the service.
+type EffectiveConfig struct {
+	CachePushRate        string  `json:"cache_push_rate"`
+	CacheTimeLimit       string  `json:"cache_time_limit"`
+	WebhookEventRate     string  `json:"webhook_event_rate"`
+	ShutdownTimeout      string  `json:"shutdown_timeout"`
+	DebugMode            bool    `json:"debug_mode"`
+	DevelopmentMode      bool    `json:"development_mode"`
+	CachingCollection    string  `json:"caching_collection"`
+	PrimaryCache         string  `json:"primary_cache"`
+	WebhookCollection    string  `json:"webhook_collection"`
+	LeaseCollection      string  `json:"lease_collection"`
+	EventCollection      string  `json:"event_collection"`
+	WebhookVerification  bool    `json:"webhook_verification"`
+	AllowPrivateWebhooks bool    `json:"allow_private_webhooks"`
+	WebhookMaxFailures   int32   `json:"webhook_max_failures"`
+	LogLevel             string  `json:"log_level"`
+	LogFormat            string  `json:"log_format"`
+	TraceExporter        string  `json:"trace_exporter"`
+	TraceEndpoint        string  `json:"trace_endpoint"`
+	TraceSampleRatio     float64 `json:"trace_sample_ratio"`
+	Port                 string  `json:"port"`
+	StubPort             string  `json:"stub_port"`
+	GRPCPort             string  `json:"grpc_port"`
+	CountriesDomain      string  `json:"countries_domain"`
+	DatasetPath          string  `json:"dataset_path"`
+	AssetsDir            string  `json:"assets_dir"`
+	CredentialsPath      string  `json:"credentials_path"`
+	RateLimitPerMinute   int     `json:"rate_limit_per_minute"`
+	RateLimitBurst       int     `json:"rate_limit_burst"`
+	RateLimitKeys        int     `json:"rate_limit_keys"`
+	GraphQLMaxDepth      int     `json:"graphql_max_depth"`
+	GraphQLMaxCost       int     `json:"graphql_max_cost"`
+}
+
+// Effective returns the settings of the config, leaving out the DB client and context.
+func (c *Config) Effective() EffectiveConfig {
+	reloadable := c.Reloadable()
+	return EffectiveConfig{
+		CachePushRate:        reloadable.CachePushRate.String(),
+		CacheTimeLimit:       c.CacheTimeLimit.String(),
+		WebhookEventRate:     reloadable.WebhookEventRate.String(),
+		ShutdownTimeout:      c.ShutdownTimeout.String(),
+		DebugMode:            reloadable.DebugMode,
+		DevelopmentMode:      c.DevelopmentMode,
+		CachingCollection:    c.CachingCollection,
+		PrimaryCache:         c.PrimaryCache,
+		WebhookCollection:    c.WebhookCollection,
+		LeaseCollection:      c.LeaseCollection,
+		EventCollection:      c.EventCollection,
+		WebhookVerification:  c.WebhookVerification,
+		AllowPrivateWebhooks: c.AllowPrivateWebhooks,
+		WebhookMaxFailures:   c.WebhookMaxFailures,
+		LogLevel:             reloadable.LogLevel.String(),
+		LogFormat:            c.LogFormat,
+		TraceExporter:        c.TraceExporter,
+		TraceEndpoint:        c.TraceEndpoint,
+		TraceSampleRatio:     c.TraceSampleRatio,
+		Port:                 c.Port,
+		StubPort:             c.StubPort,
+		GRPCPort:             c.GRPCPort,
+		CountriesDomain:      c.CountriesDomain,
+		DatasetPath:          c.DatasetPath,
+		AssetsDir:            c.AssetsDir,
+		CredentialsPath:      c.CredentialsPath,
+		RateLimitPerMinute:   c.RateLimitPerMinute,
+		RateLimitBurst:       c.RateLimitBurst,
+		RateLimitKeys:        len(c.RateLimitKeys), // keys are secrets, only their number is shown
+		GraphQLMaxDepth:      c.GraphQLMaxDepth,
+		GraphQLMaxCost:       c.GraphQLMaxCost,
+	}
+}
+
+// CountriesURL returns the base url of the countries API, or of its stub in development mode.
+func (c *Config) CountriesURL() string {
+	if c.DevelopmentMode {
+		return "http://localhost:" + c.StubPort
+	}
+	return c.CountriesDomain
 }
 
 // InitializeWithDefaults sets config settings to their defaults.
@@ -64,13 +249,38 @@ func (c *Config) InitializeWithDefaults() {
 	c.CachingCollection = SettingsCachingCollection
 	c.PrimaryCache = SettingsPrimaryCache
 	c.WebhookCollection = SettingsWebhookCollection
+	c.LeaseCollection = SettingsLeaseCollection
+	c.EventCollection = SettingsEventCollection
 	c.WebhookEventRate = SettingsWebhookEventRate
+	c.ShutdownTimeout = SettingsShutdownTimeout
+	c.WebhookVerification = SettingsWebhookVerification
+	c.AllowPrivateWebhooks = SettingsAllowPrivateWebhooks
+	c.WebhookMaxFailures = SettingsWebhookMaxFailures
+	c.LogLevel = SettingsLogLevel
+	c.LogFormat = SettingsLogFormat
+	c.TraceExporter = SettingsTraceExporter
+	c.TraceEndpoint = SettingsTraceEndpoint
+	c.TraceSampleRatio = SettingsTraceSampleRatio
+	c.Port = SettingsPort
+	c.StubPort = SettingsStubPort
+	c.GRPCPort = SettingsGRPCPort
+	c.CountriesDomain = SettingsCountriesDomain
+	c.DatasetPath = SettingsDatasetPath
+	c.AssetsDir = SettingsAssetsDir
+	c.CredentialsPath = SettingsCredentialsPath
+	c.RateLimitPerMinute = SettingsRateLimitPerMinute
+	c.RateLimitBurst = SettingsRateLimitBurst
+	c.GraphQLMaxDepth = SettingsGraphQLMaxDepth
+	c.GraphQLMaxCost = SettingsGraphQLMaxCost
+	c.RateLimitKeys = nil
 }
 
 // Initialize resets config settings to their defaults by calling InitializeWithDefaults
-// before attempting to parse settings from the project config file.
+// before attempting to parse settings from the project config file. Settings left out of
+// the file keep their defaults, while unknown keys, negative values and webhook event rates
+// below the minimum are rejected.
 //
-// On failure: All values set to defaults
+// On failure: All values set to defaults, or the values read before the failing setting
 // ON success: All present values from config set in struct
 func (c *Config) Initialize(path string) error {
 	// Values reset to defaults
@@ -78,16 +288,36 @@ func (c *Config) Initialize(path string) error {
 
 	configData, err := os.ReadFile(path)
 	if err != nil {
-		return errors.New("config init: " + err.Error())
+		return fmt.Errorf("config init: %w", err)
 	}
 	reader := bytes.NewReader(configData)
 	decoder := yaml.NewDecoder(reader)
+	decoder.KnownFields(true)
 
 	temp := configYAML{}
-	if err := decoder.Decode(&temp); err != nil {
+	if err := decoder.Decode(&temp); err != nil && err != io.EOF { // an empty file holds no settings
 		return errors.New("config init: " + err.Error())
 	}
 
+	if temp.Intervals.CachePushRate < 0 || temp.Intervals.CacheTimeLimit < 0 ||
+		temp.Intervals.WebhookEventRate < 0 || temp.Intervals.ShutdownTimeout < 0 {
+		return errors.New("config init: time intervals cannot be negative")
+	}
+	if temp.Intervals.WebhookEventRate != 0 && temp.Intervals.WebhookEventRate < minimumWebhookInterval {
+		return fmt.Errorf("config init: webhook event rate must be at least %d seconds", minimumWebhookInterval)
+	}
+	if temp.Webhooks.MaxFailures < 0 {
+		return errors.New("config init: max failures cannot be negative")
+	}
+	if temp.RateLimits.Burst < 0 || (temp.RateLimits.RequestsPerMinute != nil && *temp.RateLimits.RequestsPerMinute < 0) {
+		return errors.New("config init: rate limits cannot be negative")
+	}
+	if temp.GraphQL.MaxDepth < 0 {
+		return errors.New("config init: graphql max depth cannot be negative")
+	}
+	if temp.GraphQL.MaxCost < 0 {
+		return errors.New("config init: graphql max cost cannot be negative")
+	}
 	// Sets non-default time intervals only if non-zero or above set limitations.
 	if temp.Intervals.CachePushRate != 0 {
 		c.CachePushRate = time.Duration(temp.Intervals.CachePushRate) * time.Second
@@ -95,15 +325,87 @@ func (c *Config) Initialize(path string) error {
 	if temp.Intervals.CacheTimeLimit != 0 {
 		c.CacheTimeLimit = time.Duration(temp.Intervals.CacheTimeLimit) * time.Minute
 	}
-	if temp.Intervals.WebhookEventRate >= minimumWebhookInterval {
+	if temp.Intervals.WebhookEventRate != 0 {
 		c.WebhookEventRate = time.Duration(temp.Intervals.WebhookEventRate) * time.Second
 	}
-	// copy of remaining fields.
-	c.DebugMode = temp.Deployment.DebugMode
-	c.DevelopmentMode = temp.Deployment.DevelopmentMode
-	c.CachingCollection = temp.Firebase.CachingCollectionName
-	c.PrimaryCache = temp.Firebase.PrimaryCacheDocumentName
-	c.WebhookCollection = temp.Firebase.WebhookCollectionName
+	if temp.Intervals.ShutdownTimeout != 0 {
+		c.ShutdownTimeout = time.Duration(temp.Intervals.ShutdownTimeout) * time.Second
+	}
+	if temp.Webhooks.MaxFailures > 0 {
+		c.WebhookMaxFailures = temp.Webhooks.MaxFailures
+	}
+	// copy of remaining fields present in the file.
+	copyIfSet(&c.DebugMode, temp.Deployment.DebugMode)
+	copyIfSet(&c.DevelopmentMode, temp.Deployment.DevelopmentMode)
+	copyIfNotEmpty(&c.CachingCollection, temp.Firebase.CachingCollectionName)
+	copyIfNotEmpty(&c.PrimaryCache, temp.Firebase.PrimaryCacheDocumentName)
+	copyIfNotEmpty(&c.WebhookCollection, temp.Firebase.WebhookCollectionName)
+	copyIfNotEmpty(&c.LeaseCollection, temp.Firebase.LeaseCollectionName)
+	copyIfNotEmpty(&c.EventCollection, temp.Firebase.EventCollectionName)
+	copyIfSet(&c.WebhookVerification, temp.Webhooks.VerifyOnRegistration)
+	copyIfSet(&c.AllowPrivateWebhooks, temp.Webhooks.AllowPrivateAddresses)
+	if temp.Logging.Level != "" {
+		if c.LogLevel, err = ParseLogLevel(temp.Logging.Level); err != nil {
+			return errors.New("config init: " + err.Error())
+		}
+	}
+	if temp.Logging.Format != "" {
+		if temp.Logging.Format != LogFormatJSON && temp.Logging.Format != LogFormatText {
+			return errors.New("config init: unknown log format " + temp.Logging.Format)
+		}
+		c.LogFormat = temp.Logging.Format
+	}
+	if temp.Tracing.Exporter != "" {
+		switch temp.Tracing.Exporter {
+		case TraceExporterNone, TraceExporterStdout, TraceExporterOTLP:
+			c.TraceExporter = temp.Tracing.Exporter
+		default:
+			return errors.New("config init: unknown trace exporter " + temp.Tracing.Exporter)
+		}
+	}
+	copyIfNotEmpty(&c.TraceEndpoint, temp.Tracing.Endpoint)
+	if ratio := temp.Tracing.SampleRatio; ratio != nil {
+		if *ratio < 0 || *ratio > 1 {
+			return errors.New("config init: trace sample ratio must be between 0 and 1")
+		}
+		c.TraceSampleRatio = *ratio
+	}
+	copyIfNotEmpty(&c.Port, temp.Service.Port)
+	copyIfNotEmpty(&c.StubPort, temp.Service.StubPort)
+	copyIfNotEmpty(&c.GRPCPort, temp.Service.GRPCPort)
+	copyIfNotEmpty(&c.CountriesDomain, temp.Service.CountriesDomain)
+	copyIfNotEmpty(&c.DatasetPath, temp.Service.DatasetPath)
+	copyIfNotEmpty(&c.AssetsDir, temp.Service.AssetsDir)
+	copyIfNotEmpty(&c.CredentialsPath, temp.Service.CredentialsPath)
+	if temp.RateLimits.RequestsPerMinute != nil { // 0 is kept, disabling rate limiting
+		c.RateLimitPerMinute = *temp.RateLimits.RequestsPerMinute
+	}
+	if temp.RateLimits.Burst != 0 {
+		c.RateLimitBurst = temp.RateLimits.Burst
+	}
+	if len(temp.RateLimits.APIKeys) != 0 {
+		c.RateLimitKeys = temp.RateLimits.APIKeys
+	}
+	if temp.GraphQL.MaxDepth > 0 {
+		c.GraphQLMaxDepth = temp.GraphQL.MaxDepth
+	}
+	if temp.GraphQL.MaxCost > 0 {
+		c.GraphQLMaxCost = temp.GraphQL.MaxCost
+	}
 
 	return nil
 }
+
+// copyIfSet copies value to setting if the value was present in the config file.
+func copyIfSet(setting *bool, value *bool) {
+	if value != nil {
+		*setting = *value
+	}
+}
+
+// copyIfNotEmpty copies value to setting if the value was present in the config file.
+func copyIfNotEmpty(setting *string, value string) {
+	if value != "" {
+		*setting = value
+	}
+}
@@ -0,0 +1,157 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// configWatchInterval is the interval between checks of the config file for changes.
+const configWatchInterval = 2 * time.Second
+
+// reloadMutex guards the reloadable settings of configs, which are read by the workers
+// and handlers while the config is reloaded.
+var reloadMutex sync.RWMutex
+
+// reloadableSettings holds the json names of the settings taking effect without a restart.
+var reloadableSettings = map[string]bool{
+	"cache_push_rate":    true,
+	"webhook_event_rate": true,
+	"debug_mode":         true,
+	"log_level":          true,
+}
+
+// ReloadableSettings are the settings of a config that can change at runtime.
+type ReloadableSettings struct {
+	CachePushRate    time.Duration
+	WebhookEventRate time.Duration
+	DebugMode        bool
+	LogLevel         slog.Level
+}
+
+// Reloadable returns the current reloadable settings of the config. Goroutines running
+// alongside a reload must read these settings through Reloadable.
+func (c *Config) Reloadable() ReloadableSettings {
+	reloadMutex.RLock()
+	defer reloadMutex.RUnlock()
+	return ReloadableSettings{
+		CachePushRate:    c.CachePushRate,
+		WebhookEventRate: c.WebhookEventRate,
+		DebugMode:        c.DebugMode,
+		LogLevel:         c.LogLevel,
+	}
+}
+
+// ApplyReload copies the reloadable settings of fresh to the config and updates the log level
+// to match. The changes are returned as "name: old -> new", split into the changes applied and
+// the changes ignored, as they need a restart to take effect.
+func (c *Config) ApplyReload(fresh *Config) (applied []string, ignored []string) {
+	before := effectiveSettings(c)
+	after := effectiveSettings(fresh)
+
+	reloadMutex.Lock()
+	c.CachePushRate = fresh.CachePushRate
+	c.WebhookEventRate = fresh.WebhookEventRate
+	c.DebugMode = fresh.DebugMode
+	c.LogLevel = fresh.LogLevel
+	reloadMutex.Unlock()
+	setLogLevel(c)
+
+	names := make([]string, 0, len(before))
+	for name := range before {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if before[name] == after[name] {
+			continue
+		}
+		change := fmt.Sprintf("%s: %s -> %s", name, before[name], after[name])
+		if reloadableSettings[name] {
+			applied = append(applied, change)
+		} else {
+			ignored = append(ignored, change)
+		}
+	}
+	return applied, ignored
+}
+
+// effectiveSettings returns the effective settings of the config as a map from the json
+// name of each setting to its json encoded value.
+func effectiveSettings(cfg *Config) map[string]string {
+	encoded, _ := json.Marshal(cfg.Effective())
+	fields := make(map[string]json.RawMessage)
+	_ = json.Unmarshal(encoded, &fields)
+	settings := make(map[string]string, len(fields))
+	for name, value := range fields {
+		settings[name] = string(value)
+	}
+	return settings
+}
+
+// ReloadConfig loads the config again from the same layers as LoadConfig, applying any
+// change of the reloadable settings to cfg and logging what changed.
+//
+// On success: nil, reloadable settings of cfg updated
+// On failure: error, cfg unchanged
+func ReloadConfig(cfg *Config, args []string, lookupEnv func(string) (string, bool)) error {
+	fresh, _, err := LoadConfig(args, lookupEnv)
+	if err != nil {
+		return err
+	}
+	applied, ignored := cfg.ApplyReload(&fresh)
+	if len(applied) == 0 && len(ignored) == 0 {
+		slog.Info("service config: reloaded, no changes")
+	}
+	if len(applied) != 0 {
+		slog.Info("service config: reloaded", "changes", applied)
+	}
+	if len(ignored) != 0 {
+		slog.Warn("service config: changes need a restart to take effect", "changes", ignored)
+	}
+	return nil
+}
+
+// WatchConfig reloads the config on every signal received on hangup, and whenever the config
+// file of cfg is changed, until ctx is done. A failed reload is logged, keeping the current
+// settings in place.
+func WatchConfig(ctx context.Context, cfg *Config, args []string, lookupEnv func(string) (string, bool),
+	hangup <-chan os.Signal) {
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+	version := configFileVersion(cfg.ConfigPath)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			slog.Info("service config: reloading on hangup signal")
+		case <-ticker.C:
+			latest := configFileVersion(cfg.ConfigPath)
+			if latest == version {
+				continue
+			}
+			slog.Info("service config: reloading on change of config file", "path", cfg.ConfigPath)
+		}
+		version = configFileVersion(cfg.ConfigPath)
+		if err := ReloadConfig(cfg, args, lookupEnv); err != nil {
+			slog.Error("service config: reload failed, keeping current settings", "error", err)
+		}
+	}
+}
+
+// configFileVersion returns the modification time and size of the config file, identifying
+// a version of the file. A missing file is given the empty string.
+func configFileVersion(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprint(info.ModTime().UnixNano(), "/", info.Size())
+}
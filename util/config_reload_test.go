@@ -0,0 +1,65 @@
+package util
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestApplyReload(t *testing.T) {
+	var config, fresh Config
+	config.InitializeWithDefaults()
+	fresh.InitializeWithDefaults()
+	fresh.CachePushRate = 20 * time.Second
+	fresh.DebugMode = !config.DebugMode
+	fresh.Port = "9000"
+
+	applied, ignored := config.ApplyReload(&fresh)
+	assert.Equal(t, []string{"cache_push_rate: \"5s\" -> \"20s\"",
+		"debug_mode: true -> false"}, applied)
+	assert.Equal(t, []string{"port: \"10000\" -> \"9000\""}, ignored)
+	assert.Equal(t, 20*time.Second, config.Reloadable().CachePushRate)
+	assert.False(t, config.Reloadable().DebugMode)
+	assert.Equal(t, SettingsPort, config.Port)
+	assert.Equal(t, SettingsLogLevel, logLevel.Level())
+
+	applied, ignored = config.ApplyReload(&fresh)
+	assert.Empty(t, applied)
+	assert.Len(t, ignored, 1)
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := writeConfig(t, "time-intervals:\n  cache-push-rate: 5\n")
+	args := []string{"--config", path}
+	config, _, err := LoadConfig(args, envFrom(nil))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hangup := make(chan os.Signal)
+	stopped := make(chan struct{})
+	go func() {
+		WatchConfig(ctx, &config, args, envFrom(nil), hangup)
+		close(stopped)
+	}()
+
+	// a hangup reloads the config, picking up the changed interval
+	assert.Nil(t, os.WriteFile(path, []byte("time-intervals:\n  cache-push-rate: 30\n"), 0600))
+	hangup <- syscall.SIGHUP
+	assert.Eventually(t, func() bool {
+		return config.Reloadable().CachePushRate == 30*time.Second
+	}, time.Second, 10*time.Millisecond)
+
+	// an invalid config file leaves the settings unchanged
+	assert.Nil(t, os.WriteFile(path, []byte("time-intervals:\n  cache-push-rate: -1\n"), 0600))
+	hangup <- syscall.SIGHUP
+	hangup <- syscall.SIGHUP // received once the failed reload has been handled
+	assert.Equal(t, 30*time.Second, config.Reloadable().CachePushRate)
+
+	cancel()
+	<-stopped
+}
@@ -0,0 +1,353 @@
+package util
+
+import (
+	"Assignment2/consts"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix prefixes the names of environment variables overriding config settings,
+// e.g. ENERGY_CACHE_PUSH_RATE overrides the cache-push-rate setting.
+const EnvPrefix = "ENERGY_"
+
+// portEnvAlias is honoured in place of ENERGY_PORT, as set by most hosting platforms.
+const portEnvAlias = "PORT"
+
+// Flags that are not settings of the config.
+const (
+	configFlag      = "config"
+	printConfigFlag = "print-config"
+)
+
+// minimumPort and maximumPort bound the ports the service may listen on.
+const (
+	minimumPort = 1
+	maximumPort = 65535
+)
+
+// setting is a config setting that can be overridden by an environment variable or a flag.
+type setting struct {
+	name   string
+	usage  string
+	isBool bool
+	apply  func(c *Config, value string) error
+}
+
+// settings lists every setting that can be overridden by environment variables and flags.
+var settings = []setting{
+	stringSetting("port", "port the service listens on",
+		func(c *Config) *string { return &c.Port }),
+	stringSetting("stub-port", "port the stub of the countries API listens on in development mode",
+		func(c *Config) *string { return &c.StubPort }),
+	stringSetting("grpc-port", "port the gRPC services listen on",
+		func(c *Config) *string { return &c.GRPCPort }),
+	stringSetting("countries-domain", "base url of the countries API",
+		func(c *Config) *string { return &c.CountriesDomain }),
+	stringSetting("dataset-path", "path of the renewables dataset csv file, read from the assets if empty",
+		func(c *Config) *string { return &c.DatasetPath }),
+	stringSetting("assets-dir", "directory of files replacing the embedded assets of the same name",
+		func(c *Config) *string { return &c.AssetsDir }),
+	stringSetting("credentials-path", "path of the firebase service account credentials",
+		func(c *Config) *string { return &c.CredentialsPath }),
+	durationSetting("cache-push-rate", "interval between pushes of the cache to the DB, e.g. 5s",
+		func(c *Config) *time.Duration { return &c.CachePushRate }),
+	durationSetting("cache-time-limit", "age at which cache entries are discarded, e.g. 1h",
+		func(c *Config) *time.Duration { return &c.CacheTimeLimit }),
+	durationSetting("webhook-event-rate", "interval between checks of webhook triggers, at least 10s",
+		func(c *Config) *time.Duration { return &c.WebhookEventRate }),
+	durationSetting("shutdown-timeout", "time given to in-flight requests on shutdown, e.g. 15s",
+		func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	boolSetting("debug-mode", "log events at debug level",
+		func(c *Config) *bool { return &c.DebugMode }),
+	boolSetting("development-mode", "use the stub in place of the countries API",
+		func(c *Config) *bool { return &c.DevelopmentMode }),
+	stringSetting("caching-collection", "name of the caching collection in the DB",
+		func(c *Config) *string { return &c.CachingCollection }),
+	stringSetting("primary-cache", "name of the main cache document in the DB",
+		func(c *Config) *string { return &c.PrimaryCache }),
+	stringSetting("webhook-collection", "name of the webhook collection in the DB",
+		func(c *Config) *string { return &c.WebhookCollection }),
+	stringSetting("lease-collection", "name of the webhook delivery lease collection in the DB",
+		func(c *Config) *string { return &c.LeaseCollection }),
+	stringSetting("event-collection", "name of the threshold event collection in the DB",
+		func(c *Config) *string { return &c.EventCollection }),
+	boolSetting("webhook-verification", "require webhook receivers to echo a challenge on registration",
+		func(c *Config) *bool { return &c.WebhookVerification }),
+	boolSetting("allow-private-webhooks", "permit webhook urls resolving to private addresses",
+		func(c *Config) *bool { return &c.AllowPrivateWebhooks }),
+	{
+		name:  "webhook-max-failures",
+		usage: "consecutive failed deliveries before a webhook is paused",
+		apply: func(c *Config, value string) error {
+			failures, err := strconv.ParseInt(value, 10, 32)
+			c.WebhookMaxFailures = int32(failures)
+			return err
+		},
+	},
+	{
+		name:  "log-level",
+		usage: "minimum level of logged events: debug, info, warn or error",
+		apply: func(c *Config, value string) (err error) {
+			c.LogLevel, err = ParseLogLevel(value)
+			return err
+		},
+	},
+	stringSetting("log-format", "output format of logged events: json or text",
+		func(c *Config) *string { return &c.LogFormat }),
+	stringSetting("trace-exporter", "exporter of trace spans: none, stdout or otlp",
+		func(c *Config) *string { return &c.TraceExporter }),
+	stringSetting("trace-endpoint", "host:port of the OTLP collector",
+		func(c *Config) *string { return &c.TraceEndpoint }),
+	intSetting("rate-limit-per-minute", "requests each client may make per minute, 0 disables limiting",
+		func(c *Config) *int { return &c.RateLimitPerMinute }),
+	intSetting("rate-limit-burst", "requests each client may make in a burst before being limited",
+		func(c *Config) *int { return &c.RateLimitBurst }),
+	intSetting("graphql-max-depth", "deepest nesting of fields allowed in GraphQL queries",
+		func(c *Config) *int { return &c.GraphQLMaxDepth }),
+	intSetting("graphql-max-cost", "highest estimated cost of the fields of GraphQL queries",
+		func(c *Config) *int { return &c.GraphQLMaxCost }),
+	{
+		name:  "rate-limit-api-keys",
+		usage: "comma separated API keys limited on their own rather than by client address",
+		apply: func(c *Config, value string) error {
+			c.RateLimitKeys = strings.FieldsFunc(value, func(r rune) bool { return r == ',' })
+			return nil
+		},
+	},
+	{
+		name:  "trace-sample-ratio",
+		usage: "share of new traces that are recorded, from 0 to 1",
+		apply: func(c *Config, value string) (err error) {
+			c.TraceSampleRatio, err = strconv.ParseFloat(value, 64)
+			return err
+		},
+	},
+}
+
+// stringSetting returns a setting copying its value to the field returned by field.
+func stringSetting(name string, usage string, field func(c *Config) *string) setting {
+	return setting{name: name, usage: usage, apply: func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+// intSetting returns a setting parsing its value as an integer.
+func intSetting(name string, usage string, field func(c *Config) *int) setting {
+	return setting{name: name, usage: usage, apply: func(c *Config, value string) (err error) {
+		*field(c), err = strconv.Atoi(value)
+		return err
+	}}
+}
+
+// durationSetting returns a setting parsing its value as a duration, such as 30s or 1h.
+func durationSetting(name string, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{name: name, usage: usage, apply: func(c *Config, value string) (err error) {
+		*field(c), err = time.ParseDuration(value)
+		return err
+	}}
+}
+
+// boolSetting returns a setting parsing its value as a bool. As a flag it may be given
+// without a value to set it true.
+func boolSetting(name string, usage string, field func(c *Config) *bool) setting {
+	return setting{name: name, usage: usage, isBool: true, apply: func(c *Config, value string) (err error) {
+		*field(c), err = strconv.ParseBool(value)
+		return err
+	}}
+}
+
+// envName returns the name of the environment variable overriding the named setting.
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// LoadConfig layers the config settings, with defaults overridden by the YAML config file,
+// which is overridden by environment variables, which are in turn overridden by flags.
+// The config file is given by --config or ENERGY_CONFIG. A missing config file is logged,
+// leaving the settings it would hold to the other layers. Empty environment variables are
+// treated as unset. The firestore client is not set up, see ConnectFirestore.
+//
+// On success: Validated config, whether --print-config was given, nil
+// On failure: Empty config, false, error. Returns flag.ErrHelp if -h or --help was given.
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (Config, bool, error) {
+	getEnv := func(name string) (string, bool) {
+		value, ok := lookupEnv(name)
+		return value, ok && value != ""
+	}
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := consts.ConfigPath
+	if value, ok := getEnv(envName(configFlag)); ok {
+		configPath = value
+	}
+	flags.StringVar(&configPath, configFlag, configPath, "path of the YAML config file")
+	printConfig := flags.Bool(printConfigFlag, false, "print the effective settings as json and exit")
+
+	// Flags are recorded while parsing, and applied once the lower layers are in place.
+	flagValues := make([]func(c *Config) error, 0)
+	for _, s := range settings {
+		s := s
+		record := func(value string) error {
+			flagValues = append(flagValues, func(c *Config) error {
+				if err := s.apply(c, value); err != nil {
+					return fmt.Errorf("config: invalid value %q for flag --%s: %w", value, s.name, err)
+				}
+				return nil
+			})
+			return nil
+		}
+		usage := s.usage + " (env " + envName(s.name) + ")"
+		if s.isBool {
+			flags.BoolFunc(s.name, usage, record)
+		} else {
+			flags.Func(s.name, usage, record)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+	if flags.NArg() > 0 {
+		return Config{}, false, errors.New("config: unexpected arguments " + strings.Join(flags.Args(), " "))
+	}
+
+	var config Config
+	if err := config.Initialize(configPath); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return Config{}, false, fmt.Errorf("config: %s: %w", configPath, err)
+		}
+		slog.Warn("service config: config file not found, using defaults", "path", configPath)
+	}
+
+	config.ConfigPath = configPath
+
+	if value, ok := getEnv(portEnvAlias); ok {
+		config.Port = value
+	}
+	for _, s := range settings {
+		if value, ok := getEnv(envName(s.name)); ok {
+			if err := s.apply(&config, value); err != nil {
+				return Config{}, false, fmt.Errorf("config: invalid value %q for %s: %w", value, envName(s.name), err)
+			}
+		}
+	}
+	for _, apply := range flagValues {
+		if err := apply(&config); err != nil {
+			return Config{}, false, err
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, false, err
+	}
+	return config, *printConfig, nil
+}
+
+// Validate checks the settings of the config, reporting every invalid setting.
+//
+// On success: nil
+// On failure: error listing the invalid settings
+func (c *Config) Validate() error {
+	errs := make([]error, 0)
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("config: "+format, args...))
+	}
+
+	intervals := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"cache-push-rate", c.CachePushRate},
+		{"cache-time-limit", c.CacheTimeLimit},
+		{"shutdown-timeout", c.ShutdownTimeout},
+	}
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			invalid("%s must be positive, got %s", interval.name, interval.value)
+		}
+	}
+	if c.WebhookEventRate < minimumWebhookInterval*time.Second {
+		invalid("webhook-event-rate must be at least %ds, got %s", minimumWebhookInterval, c.WebhookEventRate)
+	}
+	if c.RateLimitPerMinute < 0 {
+		invalid("rate-limit-per-minute cannot be negative, got %d", c.RateLimitPerMinute)
+	}
+	if c.RateLimitPerMinute > 0 && c.RateLimitBurst <= 0 {
+		invalid("rate-limit-burst must be positive, got %d", c.RateLimitBurst)
+	}
+	if c.GraphQLMaxDepth <= 0 {
+		invalid("graphql-max-depth must be positive, got %d", c.GraphQLMaxDepth)
+	}
+	if c.GraphQLMaxCost <= 0 {
+		invalid("graphql-max-cost must be positive, got %d", c.GraphQLMaxCost)
+	}
+	if c.WebhookMaxFailures <= 0 {
+		invalid("webhook-max-failures must be positive, got %d", c.WebhookMaxFailures)
+	}
+	ports := []struct{ name, value string }{{"port", c.Port}, {"stub-port", c.StubPort}, {"grpc-port", c.GRPCPort}}
+	for _, port := range ports {
+		if number, err := strconv.Atoi(port.value); err != nil || number < minimumPort || number > maximumPort {
+			invalid("%s must be a number from %d to %d, got %q", port.name, minimumPort, maximumPort, port.value)
+		}
+	}
+	if c.DevelopmentMode && c.Port == c.StubPort {
+		invalid("port and stub-port must differ in development mode, both are %s", c.Port)
+	}
+	if c.GRPCPort == c.Port || (c.DevelopmentMode && c.GRPCPort == c.StubPort) {
+		invalid("grpc-port must differ from the ports of the other servers, got %s", c.GRPCPort)
+	}
+	if domain, err := url.Parse(c.CountriesDomain); err != nil || domain.Host == "" ||
+		(domain.Scheme != "http" && domain.Scheme != "https") {
+		invalid("countries-domain must be an absolute http url, got %q", c.CountriesDomain)
+	}
+	if c.AssetsDir != "" {
+		if info, err := os.Stat(c.AssetsDir); err != nil || !info.IsDir() {
+			invalid("assets-dir must be an existing directory, got %q", c.AssetsDir)
+		}
+	}
+	required := []struct{ name, value string }{
+		{"credentials-path", c.CredentialsPath},
+		{"caching-collection", c.CachingCollection},
+		{"primary-cache", c.PrimaryCache},
+		{"webhook-collection", c.WebhookCollection},
+		{"lease-collection", c.LeaseCollection},
+		{"event-collection", c.EventCollection},
+	}
+	for _, setting := range required {
+		if setting.value == "" {
+			invalid("%s cannot be empty", setting.name)
+		}
+	}
+	if c.LogFormat != LogFormatJSON && c.LogFormat != LogFormatText {
+		invalid("unknown log format %q", c.LogFormat)
+	}
+	switch c.TraceExporter {
+	case TraceExporterNone, TraceExporterStdout, TraceExporterOTLP:
+	default:
+		invalid("unknown trace exporter %q", c.TraceExporter)
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		invalid("trace sample ratio must be between 0 and 1, got %v", c.TraceSampleRatio)
+	}
+	return errors.Join(errs...)
+}
+
+// PrintConfig writes the effective settings of the config to w as indented json.
+func PrintConfig(w io.Writer, cfg *Config) error {
+	encoded, err := json.MarshalIndent(cfg.Effective(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(encoded))
+	return err
+}
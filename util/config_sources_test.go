@@ -0,0 +1,121 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// envFrom returns a lookup of environment variables from env.
+func envFrom(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+// writeConfig writes contents to a config file in a temporary directory, returning its path.
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "time-intervals:\n  cache-push-rate: 7\nservice-variables:\n  port: \"9000\"\n")
+
+	// defaults < YAML
+	config, printConfig, err := LoadConfig([]string{"--config", path}, envFrom(nil))
+	if assert.Nil(t, err) {
+		assert.False(t, printConfig)
+		assert.Equal(t, 7*time.Second, config.CachePushRate)
+		assert.Equal(t, "9000", config.Port)
+		assert.Equal(t, SettingsCacheTimeLimit, config.CacheTimeLimit)
+		assert.Equal(t, SettingsDebugMode, config.DebugMode)
+	}
+
+	// YAML < env < flags, with the config file given by the environment
+	env := envFrom(map[string]string{
+		"ENERGY_CONFIG":          path,
+		"PORT":                   "9001",
+		"ENERGY_PORT":            "9002",
+		"ENERGY_CACHE_PUSH_RATE": "8s",
+		"ENERGY_DEBUG_MODE":      "false",
+		"ENERGY_LOG_FORMAT":      "",
+	})
+	config, printConfig, err = LoadConfig([]string{"--port", "9003", "--debug-mode", "--print-config"}, env)
+	if assert.Nil(t, err) {
+		assert.True(t, printConfig)
+		assert.Equal(t, "9003", config.Port)
+		assert.Equal(t, 8*time.Second, config.CachePushRate)
+		assert.True(t, config.DebugMode)
+		assert.Equal(t, SettingsLogFormat, config.LogFormat)
+	}
+	config, _, err = LoadConfig(nil, env)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "9002", config.Port)
+		assert.False(t, config.DebugMode)
+	}
+
+	// a missing config file leaves the defaults in place
+	config, _, err = LoadConfig([]string{"--config", "/invalid/path"}, envFrom(nil))
+	if assert.Nil(t, err) {
+		assert.Equal(t, SettingsPort, config.Port)
+	}
+
+	_, _, err = LoadConfig([]string{"-h"}, envFrom(nil))
+	assert.ErrorIs(t, err, flag.ErrHelp)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	missing := "/invalid/path"
+	invalid := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"unknown flag", []string{"--unknown"}, nil},
+		{"unexpected argument", []string{"serve"}, nil},
+		{"unknown key", []string{"--config", writeConfig(t, "time-intervals:\n  cache-rate: 5\n")}, nil},
+		{"negative yaml interval", []string{"--config", writeConfig(t, "time-intervals:\n  cache-push-rate: -5\n")}, nil},
+		{"negative duration", []string{"--config", missing, "--shutdown-timeout", "-1s"}, nil},
+		{"short webhook rate", []string{"--config", missing}, map[string]string{"ENERGY_WEBHOOK_EVENT_RATE": "5s"}},
+		{"short yaml webhook rate", []string{"--config", writeConfig(t, "time-intervals:\n  webhook-event-rate: 5\n")}, nil},
+		{"malformed duration", []string{"--config", missing}, map[string]string{"ENERGY_CACHE_PUSH_RATE": "5"}},
+		{"malformed bool", []string{"--config", missing, "--debug-mode=maybe"}, nil},
+		{"port out of range", []string{"--config", missing, "--port", "70000"}, nil},
+		{"same ports", []string{"--config", missing, "--port", SettingsStubPort}, nil},
+		{"same grpc port", []string{"--config", missing, "--grpc-port", SettingsPort}, nil},
+		{"relative domain", []string{"--config", missing, "--countries-domain", "countries"}, nil},
+		{"empty collection", []string{"--config", missing, "--webhook-collection", ""}, nil},
+		{"sample ratio", []string{"--config", missing, "--trace-sample-ratio", "2"}, nil},
+		{"negative rate limit", []string{"--config", missing}, map[string]string{"ENERGY_RATE_LIMIT_PER_MINUTE": "-1"}},
+		{"no burst", []string{"--config", missing, "--rate-limit-burst", "0"}, nil},
+		{"no graphql depth", []string{"--config", missing, "--graphql-max-depth", "0"}, nil},
+		{"no graphql cost", []string{"--config", missing, "--graphql-max-cost", "0"}, nil},
+		{"missing assets dir", []string{"--config", missing, "--assets-dir", missing}, nil},
+	}
+	for _, test := range invalid {
+		_, _, err := LoadConfig(test.args, envFrom(test.env))
+		assert.Error(t, err, test.name)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	var config Config
+	config.InitializeWithDefaults()
+	var output bytes.Buffer
+	assert.Nil(t, PrintConfig(&output, &config))
+
+	var printed EffectiveConfig
+	if assert.Nil(t, json.Unmarshal(output.Bytes(), &printed)) {
+		assert.Equal(t, config.Effective(), printed)
+	}
+}
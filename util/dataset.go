@@ -1,13 +1,19 @@
 package util
 
 import (
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"errors"
 	"io"
+	"io/fs"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type YearAndPercentage struct {
@@ -16,51 +22,90 @@ type YearAndPercentage struct {
 }
 
 type CountryDataset struct {
-	mutex sync.RWMutex
-	data  map[string]Country
+	mutex    sync.RWMutex
+	data     map[string]Country
+	loadedAt time.Time
+	version  string // hash of the csv source, changing whenever the dataset does
 }
 
+// Initialize loads the dataset from the csv file at path.
 func (c *CountryDataset) Initialize(path string) error {
-	c.data = make(map[string]Country, 0)
-	c.mutex.Lock()
 	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	nr := csv.NewReader(file)
+	defer file.Close()
+	_, err = c.load(file)
+	return err
+}
+
+// Reload loads the dataset again from the csv file at path, keeping the current records if
+// the file can't be read.
+//
+// On success: cca3 codes of the countries added, removed or with changed values, ordered, nil
+// On failure: nil, error
+func (c *CountryDataset) Reload(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return c.load(file)
+}
+
+// InitializeFS loads the dataset from the named csv file of fsys, such as the embedded assets.
+func (c *CountryDataset) InitializeFS(fsys fs.FS, name string) error {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = c.load(file)
+	return err
+}
+
+// load replaces the dataset with the records read from the csv source. The dataset is left
+// unchanged if the source can't be read.
+//
+// On success: cca3 codes of the countries added, removed or with changed values, ordered, nil
+// On failure: nil, error
+func (c *CountryDataset) load(source io.Reader) ([]string, error) {
+	data := make(map[string]Country, 0)
+	hash := sha256.New()
+	nr := csv.NewReader(io.TeeReader(source, hash))
 	for {
 		record, err := nr.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 		countryName := record[0]
 		cca3 := record[1]
 		if len(cca3) == 3 {
 			year, err := strconv.Atoi(record[2])
 			if err != nil {
-				return err
+				return nil, err
 			}
 			percentage, err := strconv.ParseFloat(record[3], 32)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			if _, ok := c.data[cca3]; !ok {
-				c.data[cca3] = Country{Name: countryName, YearlyPercentages: make(map[int]float64)}
+			if _, ok := data[cca3]; !ok {
+				data[cca3] = Country{Name: countryName, YearlyPercentages: make(map[int]float64)}
 			}
 
-			c.data[cca3].YearlyPercentages[year] = percentage
+			data[cca3].YearlyPercentages[year] = percentage
 		}
 	}
 	// Calculation of averages
-	for cca3, data := range c.data {
+	for cca3, country := range data {
 		var percentage float64
 		startYear := 3000
 		endYear := 0
 
-		for year, p := range data.YearlyPercentages {
+		for year, p := range country.YearlyPercentages {
 			if year < startYear {
 				startYear = year
 			}
@@ -69,14 +114,53 @@ func (c *CountryDataset) Initialize(path string) error {
 			}
 			percentage += p
 		}
-		temp := c.data[cca3]
-		temp.AveragePercentage = percentage / float64(len(data.YearlyPercentages))
+		temp := data[cca3]
+		temp.AveragePercentage = percentage / float64(len(country.YearlyPercentages))
 		temp.StartYear = startYear
 		temp.EndYear = endYear
-		c.data[cca3] = temp
+		data[cca3] = temp
 	}
-	c.mutex.Unlock()
-	return nil
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	changed := changedCountries(c.data, data)
+	c.data = data
+	c.loadedAt = time.Now()
+	c.version = hex.EncodeToString(hash.Sum(nil))
+	return changed, nil
+}
+
+// changedCountries returns the ordered cca3 codes of the countries added, removed or with
+// changed values going from the previous to the next records.
+func changedCountries(previous map[string]Country, next map[string]Country) []string {
+	changed := make([]string, 0)
+	for cca3, country := range next {
+		if old, ok := previous[cca3]; !ok || old.Name != country.Name ||
+			!reflect.DeepEqual(old.YearlyPercentages, country.YearlyPercentages) {
+			changed = append(changed, cca3)
+		}
+	}
+	for cca3 := range previous {
+		if _, ok := next[cca3]; !ok {
+			changed = append(changed, cca3)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Version returns a hash identifying the content of the dataset, or the empty string if it
+// has not been loaded.
+func (c *CountryDataset) Version() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.version
+}
+
+// LoadedAt returns the time the dataset was loaded, or the zero time if it has not been loaded.
+func (c *CountryDataset) LoadedAt() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.loadedAt
 }
 
 // GetStatisticsRange returns a list of YearAndPercentage from 'year' to 'lastYear'.
@@ -204,6 +288,8 @@ func (c *CountryDataset) GetLastYear(country string) int {
 
 // GetAverage returns the average for a given country
 func (c *CountryDataset) GetAverage(country string) (error, float64) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	data, ok := c.data[country]
 	if ok {
 		return nil, data.AveragePercentage
@@ -224,6 +310,8 @@ func (c *CountryDataset) GetPercentage(country string, year int) (error, float64
 }
 
 func (c *CountryDataset) GetLengthOfDataset() (error, int) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	if len(c.data) > 0 {
 		return nil, len(c.data)
 	} else {
@@ -231,17 +319,20 @@ func (c *CountryDataset) GetLengthOfDataset() (error, int) {
 	}
 }
 
-// CalculatePercentage calculates percentage for a given span of years for a specific country
-func (c *CountryDataset) CalculatePercentage(code string, startYear int, endYear int) (float64, error) {
+// CalculatePercentage calculates percentage for a given span of years for a specific country,
+// along with the years missing in the span. Missing years are filled as set by fill, or left out
+// of the average for FillNone
+func (c *CountryDataset) CalculatePercentage(code string, startYear int, endYear int, fill string) (float64, []int, error) {
 	c.mutex.RLock()
 	if data, ok := c.data[code]; ok {
 		var percentage float64
 		var yearSpan float64
+		gaps := make([]int, 0)
 		// if start year is lower than that country's first year in records it is set to the first year
 		startYear = Max(startYear, data.StartYear)
 		// if end year has not been specified then it is se to the last year in records
 		if endYear == 0 {
-			endYear = c.GetLastYear(code)
+			endYear = data.EndYear
 		} else { //if endYear has been set higher than the last year it is set to the last year
 			endYear = Min(endYear, data.EndYear)
 		}
@@ -249,17 +340,27 @@ func (c *CountryDataset) CalculatePercentage(code string, startYear int, endYear
 		// end year is set before the country has records in the dataset or because begin year has
 		// been set after the last year in records, then an error is returned
 		if endYear < startYear {
-			return 0, errors.New("data not in record for specified years")
+			c.mutex.RUnlock()
+			return 0, nil, errors.New("data not in record for specified years")
 		}
-		// calculates average for span of years
+		// calculates average for span of years, counting only observed or filled years
 		for i := startYear; i <= endYear; i++ {
-			percentage += data.YearlyPercentages[i]
-			yearSpan++
+			yearPercentage, observed, ok := data.percentageOf(i, fill)
+			if !observed {
+				gaps = append(gaps, i)
+			}
+			if ok {
+				percentage += yearPercentage
+				yearSpan++
+			}
 		}
-		percentage /= yearSpan
 		c.mutex.RUnlock()
-		return percentage, nil
+		// every year of the span may be missing and left out
+		if yearSpan == 0 {
+			return 0, gaps, errors.New("data not in record for specified years")
+		}
+		return percentage / yearSpan, gaps, nil
 	}
 	c.mutex.RUnlock()
-	return 0.0, errors.New("country not on record")
+	return 0.0, nil, errors.New("country not on record")
 }
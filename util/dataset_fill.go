@@ -0,0 +1,133 @@
+package util
+
+import (
+	"errors"
+	"sort"
+)
+
+// Ways of filling years missing between the first and last year of a country in the dataset.
+const (
+	FillNone     = "none"     // missing years are left out
+	FillPrevious = "previous" // missing years take the share of the latest year before them
+	FillLinear   = "linear"   // missing years are interpolated linearly between the closest years
+)
+
+// ErrInvalidFill is returned for fills other than those listed above.
+var ErrInvalidFill = errors.New("fill must equal none, previous or linear")
+
+// ValidateFill returns ErrInvalidFill if fill is not one of the ways of filling missing years.
+func ValidateFill(fill string) error {
+	if fill != FillNone && fill != FillPrevious && fill != FillLinear {
+		return ErrInvalidFill
+	}
+	return nil
+}
+
+// GetFilledStatisticsRange returns the statistics of a country from 'year' to 'lastYear' as
+// GetStatisticsRange does, but with the years missing between the first and last year of the
+// country filled as set by fill. Filled statistics are marked as such.
+func (c *CountryDataset) GetFilledStatisticsRange(country string, year int, lastYear int, fill string) []RenewableStatistics {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	data, ok := c.data[country]
+	if !ok {
+		return nil
+	}
+	var years []RenewableStatistics
+	for year = Max(year, data.StartYear); year <= Min(lastYear, data.EndYear); year++ {
+		percentage, observed, ok := data.percentageOf(year, fill)
+		if ok {
+			years = append(years, RenewableStatistics{
+				Name:       data.Name,
+				Isocode:    country,
+				Year:       year,
+				Percentage: percentage,
+				Filled:     !observed,
+			})
+		}
+	}
+	return years
+}
+
+// HistoricAverage is the mean share of a country over a span of years, along with the years of
+// the span missing in the dataset. Percentage is nil if every year of the span is missing and
+// left unfilled.
+type HistoricAverage struct {
+	Name       string   `json:"name"`
+	Isocode    string   `json:"isocode"`
+	Percentage *float64 `json:"percentage,omitempty"`
+	Gaps       []int    `json:"gaps,omitempty"`
+}
+
+// GetHistoricAverages returns the mean shares of every country with records from 'year' to
+// 'lastYear', with zero for an unbounded last year, and missing years filled as set by fill.
+// Countries whose every year in the span is missing are listed after the others, without a
+// mean. The others are ordered by ascending mean if sortByValue is set.
+func (c *CountryDataset) GetHistoricAverages(year int, lastYear int, fill string, sortByValue bool) []HistoricAverage {
+	averages := make([]HistoricAverage, 0)
+	unobserved := make([]HistoricAverage, 0)
+	for _, statistic := range c.GetHistoricStatistics() {
+		average := HistoricAverage{Name: statistic.Name, Isocode: statistic.Isocode}
+		percentage, gaps, err := c.CalculatePercentage(statistic.Isocode, year, lastYear, fill)
+		if len(gaps) != 0 {
+			average.Gaps = gaps
+		}
+		switch {
+		case err == nil:
+			average.Percentage = &percentage
+			averages = append(averages, average)
+		case len(gaps) != 0: // every year of the span is missing
+			unobserved = append(unobserved, average)
+		} // countries without records in the span are left out
+	}
+	if sortByValue {
+		sort.Slice(averages, func(i, j int) bool {
+			return *averages[i].Percentage < *averages[j].Percentage
+		})
+	}
+	return append(averages, unobserved...)
+}
+
+// GetGaps returns the years from 'year' to 'lastYear' missing between the first and last year
+// of a country in the dataset, in order.
+func (c *CountryDataset) GetGaps(country string, year int, lastYear int) []int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	data := c.data[country]
+	gaps := make([]int, 0)
+	for year = Max(year, data.StartYear); year <= Min(lastYear, data.EndYear); year++ {
+		if _, ok := data.YearlyPercentages[year]; !ok {
+			gaps = append(gaps, year)
+		}
+	}
+	return gaps
+}
+
+// percentageOf returns the share of the year, whether it was observed, and false if the year is
+// missing and left out by fill. The year must lie between the first and last year of the
+// country, so that missing years always have observed years on both sides.
+func (c Country) percentageOf(year int, fill string) (float64, bool, bool) {
+	if percentage, ok := c.YearlyPercentages[year]; ok {
+		return percentage, true, true
+	}
+	if fill == FillNone {
+		return 0, false, false
+	}
+	previous := year - 1
+	for ; previous > c.StartYear; previous-- {
+		if _, ok := c.YearlyPercentages[previous]; ok {
+			break
+		}
+	}
+	if fill == FillPrevious {
+		return c.YearlyPercentages[previous], false, true
+	}
+	next := year + 1
+	for ; next < c.EndYear; next++ {
+		if _, ok := c.YearlyPercentages[next]; ok {
+			break
+		}
+	}
+	from, to := c.YearlyPercentages[previous], c.YearlyPercentages[next]
+	return from + (to-from)*float64(year-previous)/float64(next-previous), false, true
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+// gappedDataset returns a dataset of Norway, missing the years 2002 and 2003.
+func gappedDataset(t *testing.T) *CountryDataset {
+	var dataset CountryDataset
+	files := fstest.MapFS{"dataset.csv": {Data: []byte("Entity,Code,Year,Renewables\n" +
+		"Norway,NOR,2000,10\nNorway,NOR,2001,20\nNorway,NOR,2004,50\nNorway,NOR,2005,60\n")}}
+	if err := dataset.InitializeFS(files, "dataset.csv"); err != nil {
+		t.Fatal(err)
+	}
+	return &dataset
+}
+
+func TestCountryDataset_GetFilledStatisticsRange(t *testing.T) {
+	dataset := gappedDataset(t)
+	percentages := func(fill string) []float64 {
+		shares := make([]float64, 0)
+		for _, statistic := range dataset.GetFilledStatisticsRange("NOR", 1990, 2010, fill) {
+			assert.Equal(t, statistic.Year == 2002 || statistic.Year == 2003, statistic.Filled)
+			shares = append(shares, statistic.Percentage)
+		}
+		return shares
+	}
+	assert.Equal(t, []float64{10, 20, 50, 60}, percentages(FillNone))
+	assert.Equal(t, []float64{10, 20, 20, 20, 50, 60}, percentages(FillPrevious))
+	assert.Equal(t, []float64{10, 20, 30, 40, 50, 60}, percentages(FillLinear))
+	assert.Len(t, dataset.GetFilledStatisticsRange("NOR", 2003, 2004, FillLinear), 2)
+	assert.Nil(t, dataset.GetFilledStatisticsRange("XYZ", 2000, 2005, FillLinear))
+}
+
+func TestCountryDataset_GetGaps(t *testing.T) {
+	dataset := gappedDataset(t)
+	assert.Equal(t, []int{2002, 2003}, dataset.GetGaps("NOR", 0, 3000))
+	assert.Equal(t, []int{2003}, dataset.GetGaps("NOR", 2003, 2005))
+	assert.Empty(t, dataset.GetGaps("NOR", 2004, 2005))
+	assert.Empty(t, dataset.GetGaps("XYZ", 2000, 2005))
+}
+
+func TestCountryDataset_CalculatePercentageFill(t *testing.T) {
+	dataset := gappedDataset(t)
+	// missing years are left out of the average, rather than counted as 0%
+	percentage, gaps, err := dataset.CalculatePercentage("NOR", 0, 0, FillNone)
+	assert.Nil(t, err)
+	assert.InDelta(t, 35, percentage, 1e-9)
+	assert.Equal(t, []int{2002, 2003}, gaps)
+
+	percentage, _, err = dataset.CalculatePercentage("NOR", 0, 0, FillPrevious)
+	assert.Nil(t, err)
+	assert.InDelta(t, 30, percentage, 1e-9)
+	percentage, _, err = dataset.CalculatePercentage("NOR", 2001, 2004, FillLinear)
+	assert.Nil(t, err)
+	assert.InDelta(t, 35, percentage, 1e-9)
+
+	// a span of missing years only has no average unless filled
+	_, gaps, err = dataset.CalculatePercentage("NOR", 2002, 2003, FillNone)
+	assert.Error(t, err)
+	assert.Equal(t, []int{2002, 2003}, gaps)
+	percentage, _, err = dataset.CalculatePercentage("NOR", 2002, 2003, FillLinear)
+	assert.Nil(t, err)
+	assert.InDelta(t, 35, percentage, 1e-9)
+}
+
+func TestValidateFill(t *testing.T) {
+	for _, fill := range []string{FillNone, FillPrevious, FillLinear} {
+		assert.Nil(t, ValidateFill(fill))
+	}
+	assert.ErrorIs(t, ValidateFill("next"), ErrInvalidFill)
+	assert.ErrorIs(t, ValidateFill(""), ErrInvalidFill)
+}
@@ -0,0 +1,41 @@
+package util
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// datasetWatchInterval is the interval between checks of the dataset file for changes.
+const datasetWatchInterval = 10 * time.Second
+
+// WatchDataset reloads the dataset whenever the csv file at path is changed, until ctx is
+// done. Countries with changed values are passed to changed after every successful reload.
+// A failed reload is logged, keeping the current records in place.
+func WatchDataset(ctx context.Context, dataset *CountryDataset, path string, changed func([]string)) {
+	ticker := time.NewTicker(datasetWatchInterval)
+	defer ticker.Stop()
+	version := configFileVersion(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		latest := configFileVersion(path)
+		if latest == version {
+			continue
+		}
+		version = latest
+		slog.Info("dataset: reloading on change of dataset file", "path", path)
+		countries, err := dataset.Reload(path)
+		if err != nil {
+			slog.Error("dataset: reload failed, keeping current records", "error", err)
+			continue
+		}
+		slog.Info("dataset: reloaded", "changed_countries", len(countries), "version", dataset.Version())
+		if len(countries) != 0 {
+			changed(countries)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package util
 import (
 	"Assignment2/consts"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 )
 
 func TestCountryDataset_CalculatePercentage(t *testing.T) {
@@ -13,21 +16,74 @@ func TestCountryDataset_CalculatePercentage(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = dataset.CalculatePercentage("NOR", 1965, 1972)
+	_, _, err = dataset.CalculatePercentage("NOR", 1965, 1972, FillNone)
 	assert.Nil(t, err)
-	_, err = dataset.CalculatePercentage("SWE", 1982, 1972)
+	_, _, err = dataset.CalculatePercentage("SWE", 1982, 1972, FillNone)
 	assert.Error(t, err)
-	_, err = dataset.CalculatePercentage("NOR", 0, 0)
+	_, _, err = dataset.CalculatePercentage("NOR", 0, 0, FillNone)
 	assert.Nil(t, err)
-	_, err = dataset.CalculatePercentage("INV", 1965, 1972)
+	_, _, err = dataset.CalculatePercentage("INV", 1965, 1972, FillNone)
 	assert.Error(t, err)
 }
 
 func TestCountryDataset_Initialize(t *testing.T) {
 	var dataset CountryDataset
 
+	assert.True(t, dataset.LoadedAt().IsZero())
 	assert.Nil(t, dataset.Initialize("."+consts.DataSetPath))
+	assert.False(t, dataset.LoadedAt().IsZero())
 	assert.Error(t, dataset.Initialize("/invalid/path"))
+	// a failed load must release the lock, otherwise this blocks
+	dataset.LoadedAt()
+}
+
+func TestCountryDataset_InitializeFS(t *testing.T) {
+	var dataset CountryDataset
+	files := fstest.MapFS{"dataset.csv": {Data: []byte("Entity,Code,Year,Renewables\nNorway,NOR,2021,71.5\n")}}
+
+	assert.Equal(t, "", dataset.Version())
+	assert.Nil(t, dataset.InitializeFS(files, "dataset.csv"))
+	assert.True(t, dataset.HasCountryInRecords("NOR"))
+	assert.Equal(t, 2021, dataset.GetLastYear("NOR"))
+	version := dataset.Version()
+	assert.NotEmpty(t, version)
+
+	// Reloading the same content keeps the version, while other content changes it.
+	assert.Nil(t, dataset.InitializeFS(files, "dataset.csv"))
+	assert.Equal(t, version, dataset.Version())
+	files["other.csv"] = &fstest.MapFile{Data: []byte("Entity,Code,Year,Renewables\nNorway,NOR,2021,72.0\n")}
+	assert.Nil(t, dataset.InitializeFS(files, "other.csv"))
+	assert.NotEqual(t, version, dataset.Version())
+	assert.Error(t, dataset.InitializeFS(files, "missing.csv"))
+}
+
+func TestCountryDataset_Reload(t *testing.T) {
+	var dataset CountryDataset
+	path := filepath.Join(t.TempDir(), "dataset.csv")
+	header := "Entity,Code,Year,Renewables\n"
+	assert.Nil(t, os.WriteFile(path, []byte(header+
+		"Norway,NOR,2020,71.0\nNorway,NOR,2021,71.5\nSweden,SWE,2021,50.9\nFinland,FIN,2021,43.1\n"), 0600))
+	assert.Nil(t, dataset.Initialize(path))
+
+	// Changed, added and removed countries are reported, while unchanged countries are not.
+	assert.Nil(t, os.WriteFile(path, []byte(header+
+		"Norway,NOR,2020,71.0\nNorway,NOR,2021,72.0\nSweden,SWE,2021,50.9\nDenmark,DNK,2021,41.2\n"), 0600))
+	changed, err := dataset.Reload(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"DNK", "FIN", "NOR"}, changed)
+	_, percentage := dataset.GetPercentage("NOR", 2021)
+	assert.Equal(t, 72.0, percentage)
+
+	// A failed reload keeps the current records.
+	version := dataset.Version()
+	assert.Nil(t, os.WriteFile(path, []byte(header+"Norway,NOR,twenty,72.0\n"), 0600))
+	_, err = dataset.Reload(path)
+	assert.Error(t, err)
+	assert.True(t, dataset.HasCountryInRecords("DNK"))
+	assert.Equal(t, version, dataset.Version())
+	changed, err = dataset.Reload("/invalid/path")
+	assert.Error(t, err)
+	assert.Nil(t, changed)
 }
 
 func TestCountryDataset_GetAverage(t *testing.T) {
@@ -0,0 +1,151 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// Output formats of logged events.
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// RequestIDHeader is the header carrying the ID of a request, both when supplied by
+// the client and in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDLength is the number of random bytes in a generated request ID.
+const requestIDLength = 8
+
+// Attribute keys linking logged events to requests and traces.
+const (
+	logKeyRequestID = "request_id"
+	logKeyTraceID   = "trace_id"
+)
+
+// maxRequestIDLength limits the length of request IDs supplied by clients.
+const maxRequestIDLength = 64
+
+// logLevel is the minimum level of events logged by the default logger, changed as the
+// config is reloaded.
+var logLevel slog.LevelVar
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// ParseLogLevel converts a level name as used in the config file to a slog.Level.
+//
+// On success: level, nil
+// On failure: slog.LevelInfo, error
+func ParseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo, errors.New("log level: unknown level " + name)
+	}
+	return level, nil
+}
+
+// NewLogger returns a logger writing events at or above the level to w in the given format.
+//
+// On success: logger, nil
+// On failure: nil, error
+func NewLogger(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	default:
+		return nil, errors.New("log format: unknown format " + format)
+	}
+}
+
+// SetUpLogging sets the default logger to one writing to w as set up in the config.
+// Any use of the standard log package is routed through the same logger. The level of
+// the logger follows later reloads of the config.
+//
+// On success: nil
+// On failure: error, default logger unchanged
+func SetUpLogging(cfg *Config, w io.Writer) error {
+	logger, err := NewLogger(w, &logLevel, cfg.LogFormat)
+	if err != nil {
+		return err
+	}
+	setLogLevel(cfg)
+	slog.SetDefault(logger)
+	return nil
+}
+
+// setLogLevel sets the level of the default logger to the level of the config, lowered
+// to debug by debug mode.
+func setLogLevel(cfg *Config) {
+	reloadable := cfg.Reloadable()
+	if reloadable.DebugMode {
+		logLevel.Set(slog.LevelDebug)
+	} else {
+		logLevel.Set(reloadable.LogLevel)
+	}
+}
+
+// NewRequestID returns a random ID identifying a single request.
+func NewRequestID() string {
+	id := make([]byte, requestIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or the empty string if there is none.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logger returns the default logger, with the request ID and trace ID carried by ctx
+// attached to every event if present.
+func Logger(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if ctx == nil {
+		return logger
+	}
+	if id := RequestID(ctx); id != "" {
+		logger = logger.With(logKeyRequestID, id)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		logger = logger.With(logKeyTraceID, spanContext.TraceID().String())
+	}
+	return logger
+}
+
+// WithRequestLogging wraps the handler, attaching a request ID to the context of every
+// request. The ID is taken from the X-Request-ID header if supplied by the client, and
+// generated if missing or too long. The ID is returned in the header of the response.
+func WithRequestLogging(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		r = r.WithContext(WithRequestID(r.Context(), id))
+		Logger(r.Context()).Debug("request received", "method", r.Method, "path", r.URL.Path)
+		handler(w, r)
+	}
+}
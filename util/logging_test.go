@@ -0,0 +1,66 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("debug")
+	assert.Nil(t, err)
+	assert.Equal(t, slog.LevelDebug, level)
+	level, err = ParseLogLevel("WARN")
+	assert.Nil(t, err)
+	assert.Equal(t, slog.LevelWarn, level)
+	_, err = ParseLogLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestNewLogger(t *testing.T) {
+	var output bytes.Buffer
+	logger, err := NewLogger(&output, slog.LevelInfo, LogFormatJSON)
+	if !assert.Nil(t, err) {
+		return
+	}
+	logger.Debug("not logged")
+	logger.With(logKeyRequestID, "abc").Info("logged", "country", "NOR")
+
+	event := map[string]any{}
+	assert.Nil(t, json.Unmarshal(output.Bytes(), &event))
+	assert.Equal(t, "logged", event["msg"])
+	assert.Equal(t, "abc", event[logKeyRequestID])
+	assert.Equal(t, "NOR", event["country"])
+
+	_, err = NewLogger(&output, slog.LevelInfo, "xml")
+	assert.Error(t, err)
+}
+
+func TestWithRequestLogging(t *testing.T) {
+	var handledID string
+	handler := WithRequestLogging(func(w http.ResponseWriter, r *http.Request) {
+		handledID = RequestID(r.Context())
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NotEmpty(t, handledID)
+	assert.Equal(t, handledID, recorder.Header().Get(RequestIDHeader))
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(RequestIDHeader, "client-id")
+	recorder = httptest.NewRecorder()
+	handler(recorder, request)
+	assert.Equal(t, "client-id", handledID)
+	assert.Equal(t, "client-id", recorder.Header().Get(RequestIDHeader))
+
+	request = httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(RequestIDHeader, strings.Repeat("x", maxRequestIDLength+1))
+	handler(httptest.NewRecorder(), request)
+	assert.Len(t, handledID, 2*requestIDLength)
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemContentType is the content type of problem documents.
+const ProblemContentType = "application/problem+json"
+
+// problemTypeBlank is the problem type of problems described by their status code and code
+// alone, as defined by RFC 7807.
+const problemTypeBlank = "about:blank"
+
+// Machine-readable codes identifying the problems reported by the service.
+const (
+	ProblemNotFound            = "not_found"
+	ProblemMethodNotAllowed    = "method_not_allowed"
+	ProblemInvalidParameter    = "invalid_parameter"
+	ProblemCountryNotFound     = "country_not_found"
+	ProblemInvalidWebhook      = "invalid_webhook"
+	ProblemWebhookNotFound     = "webhook_not_found"
+	ProblemVerificationFailed  = "webhook_verification_failed"
+	ProblemInsufficientData    = "insufficient_data"
+	ProblemRateLimited         = "rate_limited"
+	ProblemInternalServerError = "internal_error"
+)
+
+// Problem is a problem document as defined by RFC 7807, describing why a request failed.
+// Code identifies the problem, while Detail is meant for humans. RequestID is the ID of the
+// request, matching the ID in logged events.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// NewProblem returns a problem document for the request, titled by the status code.
+func NewProblem(r *http.Request, status int, code string, detail string) Problem {
+	return Problem{
+		Type:      problemTypeBlank,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: RequestID(r.Context()),
+	}
+}
+
+// WriteProblem responds to the request with a problem document, replacing any content type
+// already set on the response.
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	w.Header().Set("content-type", ProblemContentType)
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(NewProblem(r, status, code, detail)); err != nil {
+		Logger(r.Context()).Error("problem: failed to encode problem document", "error", err)
+	}
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/energy/v1/renewables/current/xyz", nil)
+	request = request.WithContext(WithRequestID(request.Context(), "abc123"))
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("content-type", "application/json")
+	WriteProblem(recorder, request, http.StatusNotFound, ProblemCountryNotFound, "country not in dataset")
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, ProblemContentType, recorder.Header().Get("content-type"))
+	problem := Problem{}
+	if assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&problem)) {
+		assert.Equal(t, Problem{
+			Type:      "about:blank",
+			Title:     "Not Found",
+			Status:    http.StatusNotFound,
+			Detail:    "country not in dataset",
+			Instance:  "/energy/v1/renewables/current/xyz",
+			Code:      ProblemCountryNotFound,
+			RequestID: "abc123",
+		}, problem)
+	}
+}
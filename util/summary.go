@@ -0,0 +1,126 @@
+package util
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Quantiles of the shares reported in year summaries.
+const (
+	lowerQuartile = 0.25
+	median        = 0.5
+	upperQuartile = 0.75
+)
+
+// YearSummary describes the distribution of the renewable energy shares of the countries
+// reporting a year. The standard deviation is that of all reporting countries, and quartiles
+// are interpolated linearly between the closest shares. Minimum and Maximum are the countries
+// with the lowest and highest shares, the first by cca3 code on ties.
+type YearSummary struct {
+	Year              int                 `json:"year"`
+	Count             int                 `json:"count"`
+	Mean              float64             `json:"mean"`
+	Median            float64             `json:"median"`
+	StandardDeviation float64             `json:"standard_deviation"`
+	LowerQuartile     float64             `json:"lower_quartile"`
+	UpperQuartile     float64             `json:"upper_quartile"`
+	Minimum           RenewableStatistics `json:"min"`
+	Maximum           RenewableStatistics `json:"max"`
+}
+
+// GetYearSummary returns the summary of the shares of the countries reporting the year.
+//
+// On success: summary of the year, nil
+// On failure: empty summary, error if no country reports the year
+func (c *CountryDataset) GetYearSummary(year int) (YearSummary, error) {
+	c.mutex.RLock()
+	statistics := make([]RenewableStatistics, 0)
+	for cca3, data := range c.data {
+		if percentage, ok := data.YearlyPercentages[year]; ok {
+			statistics = append(statistics, RenewableStatistics{
+				Name:       data.Name,
+				Isocode:    cca3,
+				Year:       year,
+				Percentage: percentage,
+			})
+		}
+	}
+	c.mutex.RUnlock()
+	if len(statistics) == 0 {
+		return YearSummary{}, errors.New("year not on record")
+	}
+	return summarize(year, statistics), nil
+}
+
+// GetYearSummaries returns the summaries of every year reported by any country, ordered by year.
+func (c *CountryDataset) GetYearSummaries() []YearSummary {
+	c.mutex.RLock()
+	years := make(map[int][]RenewableStatistics)
+	for cca3, data := range c.data {
+		for year, percentage := range data.YearlyPercentages {
+			years[year] = append(years[year], RenewableStatistics{
+				Name:       data.Name,
+				Isocode:    cca3,
+				Year:       year,
+				Percentage: percentage,
+			})
+		}
+	}
+	c.mutex.RUnlock()
+	summaries := make([]YearSummary, 0, len(years))
+	for year, statistics := range years {
+		summaries = append(summaries, summarize(year, statistics))
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Year < summaries[j].Year
+	})
+	return summaries
+}
+
+// summarize returns the summary of the non-empty statistics of the year.
+func summarize(year int, statistics []RenewableStatistics) YearSummary {
+	sort.Slice(statistics, func(i, j int) bool {
+		if statistics[i].Percentage != statistics[j].Percentage {
+			return statistics[i].Percentage < statistics[j].Percentage
+		}
+		return statistics[i].Isocode < statistics[j].Isocode
+	})
+	shares := make([]float64, 0, len(statistics))
+	mean := 0.0
+	for _, statistic := range statistics {
+		shares = append(shares, statistic.Percentage)
+		mean += statistic.Percentage / float64(len(statistics))
+	}
+	variance := 0.0
+	for _, share := range shares {
+		variance += (share - mean) * (share - mean) / float64(len(shares))
+	}
+	// the maximum is the first country with the highest share
+	highest := len(statistics) - 1
+	for highest > 0 && statistics[highest-1].Percentage == statistics[highest].Percentage {
+		highest--
+	}
+	return YearSummary{
+		Year:              year,
+		Count:             len(statistics),
+		Mean:              mean,
+		Median:            quantile(shares, median),
+		StandardDeviation: math.Sqrt(variance),
+		LowerQuartile:     quantile(shares, lowerQuartile),
+		UpperQuartile:     quantile(shares, upperQuartile),
+		Minimum:           statistics[0],
+		Maximum:           statistics[highest],
+	}
+}
+
+// quantile returns the quantile of the non-empty sorted shares, interpolating linearly between
+// the closest shares.
+func quantile(shares []float64, q float64) float64 {
+	position := q * float64(len(shares)-1)
+	below := int(math.Floor(position))
+	if below == len(shares)-1 {
+		return shares[below]
+	}
+	return shares[below] + (shares[below+1]-shares[below])*(position-float64(below))
+}
@@ -0,0 +1,41 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCountryDataset_GetYearSummary(t *testing.T) {
+	var dataset CountryDataset
+	files := fstest.MapFS{"dataset.csv": {Data: []byte("Entity,Code,Year,Renewables\n" +
+		"Norway,NOR,2020,70\nNorway,NOR,2021,80\nSweden,SWE,2021,50\nFinland,FIN,2021,30\n" +
+		"Denmark,DNK,2021,40\nIceland,ISL,2021,80\n")}}
+	if err := dataset.InitializeFS(files, "dataset.csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := dataset.GetYearSummary(2021)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, summary.Count)
+	assert.InDelta(t, 56, summary.Mean, 1e-9)
+	assert.InDelta(t, 50, summary.Median, 1e-9)
+	assert.InDelta(t, 40, summary.LowerQuartile, 1e-9)
+	assert.InDelta(t, 80, summary.UpperQuartile, 1e-9)
+	assert.InDelta(t, 20.591260, summary.StandardDeviation, 1e-6)
+	assert.Equal(t, RenewableStatistics{Name: "Finland", Isocode: "FIN", Year: 2021, Percentage: 30}, summary.Minimum)
+	// ties are broken by cca3 code
+	assert.Equal(t, "ISL", summary.Maximum.Isocode)
+
+	_, err = dataset.GetYearSummary(1999)
+	assert.Error(t, err)
+
+	summaries := dataset.GetYearSummaries()
+	if assert.Len(t, summaries, 2) {
+		assert.Equal(t, 2020, summaries[0].Year)
+		assert.Equal(t, 1, summaries[0].Count)
+		assert.InDelta(t, 70, summaries[0].LowerQuartile, 1e-9)
+		assert.Equal(t, summaries[0].Minimum, summaries[0].Maximum)
+		assert.Equal(t, summary, summaries[1])
+	}
+}
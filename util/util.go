@@ -1,5 +1,5 @@
 // Package util contains generic functionality for use with the server handlers, such as
-// url processing, outbound request handling, and encoding of responses.
+// outbound request handling, and encoding of responses.
 
 package util
 
@@ -10,13 +10,12 @@ import (
 	"fmt"
 	"golang.org/x/exp/constraints"
 	"google.golang.org/api/option"
-	"log"
+	"log/slog"
 	"strconv"
 
 	// "os"
 	"net"
 	"net/http"
-	"strings"
 )
 
 // Max returns the largest value
@@ -41,14 +40,7 @@ type RenewableStatistics struct {
 	Isocode    string  `json:"isocode"`
 	Year       int     `json:"year,omitempty"` // if empty, will not be encoded in the response
 	Percentage float64 `json:"percentage"`
-}
-
-// HandlerContext is a container for the name, writer and client object associated with
-// a handler body.
-type HandlerContext struct {
-	Name   string
-	Writer *http.ResponseWriter
-	Client *http.Client
+	Filled     bool    `json:"filled,omitempty"` // set if the year is missing in the dataset and filled
 }
 
 // Country struct that encapsulates the information for one Country in the dataset
@@ -78,34 +70,37 @@ func StatusToString(status int) string {
 // On success: Config struct with valid firestore pointers, nil
 // On failure: Config with nil pointers, error
 func SetUpServiceConfig(configPath string, credentials string) (Config, error) {
+	var config Config
+	err := config.Initialize(configPath)
+	if err != nil { // Allowable error, running service with default config.
+		slog.Warn("service config: using defaults for settings not read", "error", err)
+	}
+	config.CredentialsPath = credentials
+	if err = ConnectFirestore(&config); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// ConnectFirestore initializes the firestore context and client of the config, using the
+// credentials found at the credentials path of the config.
+//
+// On success: Config with valid firestore pointers, nil
+// On failure: Config left unchanged, error
+func ConnectFirestore(cfg *Config) error {
 	ctx := context.Background()
-	opt := option.WithCredentialsFile(credentials)
+	opt := option.WithCredentialsFile(cfg.CredentialsPath)
 	app, err := firebase.NewApp(ctx, nil, opt)
 	if err != nil {
-		return Config{}, err
+		return err
 	}
 	client, err := app.Firestore(ctx)
 	if err != nil {
-		return Config{}, err
-	}
-
-	var config Config
-	err = config.Initialize(configPath)
-	if err != nil { // Allowable error, running service with default config.
-		log.Println(err)
+		return err
 	}
-	config.FirestoreClient = client
-	config.Ctx = &ctx
-	return config, nil
-}
-
-// FragmentsFromPath takes an incoming URL path and the path of a handler, removing the handler portion
-// of the full path, returning the remaining path split on '/'. The function also replaces any whitespace
-// with %20 for safety.
-func FragmentsFromPath(Path string, rootPath string) []string {
-	trimmedPath := strings.TrimPrefix(Path, rootPath)
-	trimmedPath = strings.ReplaceAll(trimmedPath, " ", "%20")
-	return strings.FieldsFunc(trimmedPath, func(c rune) bool { return c == '/' })
+	cfg.FirestoreClient = client
+	cfg.Ctx = &ctx
+	return nil
 }
 
 // GetDomainStatus sends a basic get request to the supplied URL and returns the response
@@ -129,20 +124,13 @@ func GetDomainStatus(URL string) (string, error) {
 
 // EncodeAndWriteResponse attempts to encode data as a json response. Data must be a pointer
 // to an appropriate object suited for encoding as json. Logging and errors are handled
-// within the function.
+// within the function. The content type set by the caller is kept, as the status is written
+// implicitly along with the body.
 func EncodeAndWriteResponse(w *http.ResponseWriter, data interface{}) {
 	encoder := json.NewEncoder(*w)
 	if err := encoder.Encode(data); err != nil {
-		log.Println("Encoding error:", err)
+		slog.Error("encoding error", "error", err)
 		http.Error(*w, "Error during encoding", http.StatusInternalServerError)
 		return
 	}
-	http.Error(*w, "", http.StatusOK)
-}
-
-// LogOnDebug logs all argument items if Config.DebugMode == true
-func LogOnDebug(cfg *Config, msg ...any) {
-	if cfg.DebugMode {
-		log.Println("dbg:", msg)
-	}
 }
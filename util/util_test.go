@@ -20,7 +20,35 @@ func TestConfigInitialize(t *testing.T) {
 		CachingCollection: SettingsCachingCollection,
 		PrimaryCache:      SettingsPrimaryCache,
 		WebhookCollection: SettingsWebhookCollection,
+		LeaseCollection:   SettingsLeaseCollection,
+		EventCollection:   SettingsEventCollection,
 		WebhookEventRate:  SettingsWebhookEventRate,
+		ShutdownTimeout:   SettingsShutdownTimeout,
+
+		WebhookVerification:  SettingsWebhookVerification,
+		AllowPrivateWebhooks: SettingsAllowPrivateWebhooks,
+		WebhookMaxFailures:   SettingsWebhookMaxFailures,
+
+		LogLevel:  SettingsLogLevel,
+		LogFormat: SettingsLogFormat,
+
+		TraceExporter:    SettingsTraceExporter,
+		TraceEndpoint:    SettingsTraceEndpoint,
+		TraceSampleRatio: SettingsTraceSampleRatio,
+
+		Port:            SettingsPort,
+		StubPort:        SettingsStubPort,
+		GRPCPort:        SettingsGRPCPort,
+		CountriesDomain: SettingsCountriesDomain,
+		DatasetPath:     SettingsDatasetPath,
+		AssetsDir:       SettingsAssetsDir,
+		CredentialsPath: SettingsCredentialsPath,
+
+		RateLimitPerMinute: SettingsRateLimitPerMinute,
+		RateLimitBurst:     SettingsRateLimitBurst,
+
+		GraphQLMaxDepth: SettingsGraphQLMaxDepth,
+		GraphQLMaxCost:  SettingsGraphQLMaxCost,
 	}
 	assert.Equal(t, defaultConfig, testConfig)
 	assert.Nil(t, testConfig.Initialize("../config/config.yaml"))
@@ -72,37 +100,6 @@ func TestMin(t *testing.T) {
 	assert.Equal(t, 'a', Min('b', 'a'))
 }
 
-// TestFragmentsFromPath test that verifies parsing of an url string into a set of segments.
-func TestFragmentsFromPath(t *testing.T) {
-	runFragmentsTest := func(path string, rootPath string, expected []string) func(t *testing.T) {
-		return func(t *testing.T) {
-			processed := FragmentsFromPath(path, rootPath)
-			if len(processed) != len(expected) {
-				t.Error("Expected \n", expected, "\nbut got: \n", processed)
-			}
-		}
-	}
-	// defines a slice of test structs before running the above function on each struct.
-	tests := []struct {
-		name     string
-		path     string
-		rootPath string
-		expected []string
-	}{
-		{"test_1", "unibus/v2/filtered/ parrot in/ GARDden", "unibus/v2/",
-			[]string{"filtered", "%20parrot%20in", "%GARDden"}},
-		{"test_2", "unibus/v2/", "/v2/",
-			[]string{"unibus", "v2"}},
-		{"test_3", "unibus//", "unibus",
-			[]string{}},
-		{"test_4", "unibus/ /", "unibus",
-			[]string{"%20"}},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, runFragmentsTest(tt.path, tt.rootPath, tt.expected))
-	}
-}
-
 // TestGetDomainStatus tests the returned status messages. Error messages are not tested for.
 func TestGetDomainStatus(t *testing.T) {
 	testCases := []struct {
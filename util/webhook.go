@@ -0,0 +1,81 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// WebhookClientTimeout is the time limit for any outbound request to a webhook receiver.
+const WebhookClientTimeout = 10 * time.Second
+
+// ValidateWebhookURL checks that rawURL is a well-formed absolute url using the http or https
+// scheme. Hosts given as literal ip addresses are refused if they are private, loopback or
+// link-local, unless allowPrivate is set.
+//
+// On success: nil
+// On failure: error describing why the url was refused
+func ValidateWebhookURL(rawURL string, allowPrivate bool) error {
+	parsed, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return errors.New("webhook url: not a valid absolute url")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("webhook url: scheme must be http or https")
+	}
+	if parsed.Hostname() == "" {
+		return errors.New("webhook url: missing host")
+	}
+	if parsed.User != nil {
+		return errors.New("webhook url: user info is not permitted")
+	}
+	if ip := net.ParseIP(parsed.Hostname()); ip != nil && !allowPrivate && isRestrictedIP(ip) {
+		return errors.New("webhook url: private, loopback and link-local addresses are not permitted")
+	}
+	return nil
+}
+
+// NewWebhookClient returns a http client intended for all outbound traffic to webhook receivers.
+// Unless Config.AllowPrivateWebhooks is set, the client refuses to connect to private, loopback
+// and link-local addresses. The check is done on the resolved address at the time of dialing,
+// meaning host names and redirects pointing to internal hosts are refused as well.
+func NewWebhookClient(cfg *Config) *http.Client {
+	dialer := &net.Dialer{Timeout: WebhookClientTimeout}
+	if !cfg.AllowPrivateWebhooks {
+		dialer.Control = refuseRestrictedAddress
+	}
+	return &http.Client{
+		Timeout: WebhookClientTimeout,
+		Transport: &http.Transport{
+			Proxy:       nil, // a proxy would hide the address of the actual receiver
+			DialContext: dialer.DialContext,
+		},
+	}
+}
+
+// refuseRestrictedAddress is used as the control function of a net.Dialer, failing any
+// connection attempt to a restricted address.
+func refuseRestrictedAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isRestrictedIP(ip) {
+		return errors.New("webhook client: refused connection to restricted address " + address)
+	}
+	return nil
+}
+
+// isRestrictedIP returns true if the address is loopback, private, link-local or unspecified.
+func isRestrictedIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified()
+}